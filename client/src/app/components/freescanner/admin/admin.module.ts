@@ -24,6 +24,7 @@ import { FreeScannerAdminComponent } from './admin.component';
 import { FreeScannerAdminService } from './admin.service';
 import { FreeScannerAdminConfigComponent } from './config/config.component';
 import { FreeScannerAdminAccessComponent } from './config/access/access.component';
+import { FreeScannerAdminAlertsComponent } from './config/alerts/alerts.component';
 import { FreeScannerAdminApiKeysComponent } from './config/api-keys/api-keys.component';
 import { FreeScannerAdminDirWatchComponent } from './config/dir-watch/dir-watch.component';
 import { FreeScannerAdminDownstreamsComponent } from './config/downstreams/downstreams.component';
@@ -49,6 +50,7 @@ import { FreeScannerAdminPasswordComponent } from './tools/password/password.com
         FreeScannerAdminComponent,
         FreeScannerAdminConfigComponent,
         FreeScannerAdminAccessComponent,
+        FreeScannerAdminAlertsComponent,
         FreeScannerAdminApiKeysComponent,
         FreeScannerAdminDirWatchComponent,
         FreeScannerAdminDownstreamsComponent,
@@ -46,23 +46,52 @@ export interface AdminEvent {
     passwordNeedChange?: boolean;
 }
 
+export interface Alert {
+    _id?: string;
+    disabled?: boolean;
+    emergency?: boolean;
+    groups?: number[];
+    keywords?: string;
+    label?: string;
+    order?: number;
+    sink?: 'email' | 'exec' | 'webhook';
+    systems?: {
+        id: number;
+        talkgroups: number[] | '*';
+    }[] | '*';
+    tags?: number[];
+    target?: string;
+    timeFrom?: string;
+    timeTo?: string;
+    units?: string;
+}
+
 export interface ApiKey {
     _id?: string;
+    adminRead?: boolean;
     disabled?: boolean;
+    expiration?: Date;
     ident?: string;
     key?: string;
+    lastUsed?: Date;
+    lastUsedIp?: string;
     order?: number;
+    rateLimit?: number;
+    read?: boolean;
     systems?: {
         id: number;
         talkgroups: number[] | '*';
     }[] | number[] | '*';
+    upload?: boolean;
 }
 
 export interface Config {
     access?: Access[];
+    alerts?: Alert[];
     apiKeys?: ApiKey[];
     dirWatch?: DirWatch[];
     downstreams?: Downstream[];
+    downstreamsStatus?: { [id: string]: DownstreamStatus };
     groups?: Group[];
     options?: Options;
     systems?: System[];
@@ -71,6 +100,7 @@ export interface Config {
 
 export interface DirWatch {
     _id?: string;
+    archiveDir?: string;
     delay?: number;
     deleteAfter?: boolean;
     directory?: string;
@@ -79,16 +109,21 @@ export interface DirWatch {
     frequency?: number;
     mask?: string;
     order?: number;
+    pollingInterval?: number;
+    quarantineDir?: string;
     systemId?: number;
     talkgroupId?: number;
     type?: string;
+    usePolling?: boolean;
 }
 
 export interface Downstream {
     _id?: string;
     apiKey?: string;
+    audioBaseUrl?: string;
     disabled?: boolean;
     order?: number;
+    remoteSystem?: string;
     systems?: {
         id?: number;
         id_as?: number;
@@ -97,12 +132,20 @@ export interface Downstream {
             id_as?: number;
         }[] | number[] | '*';
     }[] | number[] | '*';
+    type?: string;
     url?: string;
 }
 
+export interface DownstreamStatus {
+    backlog: number;
+    lastError?: string;
+    lastErrorAt?: string;
+}
+
 export interface Group {
     _id?: number;
     label?: string;
+    pruneDays?: number | null;
 }
 
 export interface Log {
@@ -129,12 +172,16 @@ export interface LogsQueryOptions {
 }
 
 export interface Options {
+    adminSessionIdle?: number;
+    adminSessionMaxAge?: number;
     afsSystems?: string;
     audioConversion?: 0 | 1 | 2 | 3;
     autoPopulate?: boolean;
     branding?: string;
     dimmerDelay?: number;
     disableDuplicateDetection?: boolean;
+    downstreamRetryMaxAge?: number;
+    downstreamRetryMaxAttempts?: number;
     duplicateDetectionTimeFrame?: number;
     email?: string;
     keypadBeeps?: string;
@@ -142,6 +189,8 @@ export interface Options {
     maxClients?: number;
     playbackGoesLive?: boolean;
     pruneDays?: number;
+    pruneMaxSize?: number;
+    pruneMinFree?: number;
     searchPatchedTalkgroups?: boolean;
     showListenersCount?: boolean;
     sortTalkgroups?: boolean;
@@ -157,6 +206,7 @@ export interface System {
     label?: string;
     led?: string | null;
     order?: number | null;
+    pruneDays?: number | null;
     talkgroups?: Talkgroup[];
     units?: Unit[];
 }
@@ -164,6 +214,7 @@ export interface System {
 export interface Tag {
     _id?: number;
     label?: string;
+    pruneDays?: number | null;
 }
 
 export interface Talkgroup {
@@ -209,12 +260,18 @@ export class FreeScannerAdminService implements OnDestroy {
         return this._passwordNeedChange;
     }
 
+    get totpRequired() {
+        return this._totpRequired;
+    }
+
     private configWebSocket: WebSocket | undefined;
 
     private _docker = false;
 
     private _passwordNeedChange = false;
 
+    private _totpRequired = false;
+
     private get token(): string {
         return window?.sessionStorage?.getItem(SESSION_STORAGE_KEY) || '';
     }
@@ -311,14 +368,18 @@ export class FreeScannerAdminService implements OnDestroy {
         }
     }
 
-    async login(password: string): Promise<boolean> {
+    async login(password: string, username?: string, code?: string): Promise<boolean> {
+        this._totpRequired = false;
+
         try {
             const res = await firstValueFrom(this.ngHttpClient.post<{
                 passwordNeedChange: boolean,
-                token: string
+                role: string,
+                token: string,
+                username: string,
             }>(
                 this.getUrl(url.login),
-                { password },
+                { code, password, username },
                 { headers: this.getHeaders(), responseType: 'json' },
             ));
 
@@ -336,6 +397,8 @@ export class FreeScannerAdminService implements OnDestroy {
             return !!this.token;
 
         } catch (error) {
+            this._totpRequired = error instanceof HttpErrorResponse && error.status === 401 && !!error.error?.totpRequired;
+
             this.errorHandler(error);
 
             return false;
@@ -394,20 +457,47 @@ export class FreeScannerAdminService implements OnDestroy {
         });
     }
 
+    newAlertForm(alert?: Alert): FormGroup {
+        return this.ngFormBuilder.group({
+            _id: [alert?._id],
+            disabled: [alert?.disabled],
+            emergency: [alert?.emergency],
+            groups: [alert?.groups || []],
+            keywords: [alert?.keywords],
+            label: [alert?.label, Validators.required],
+            order: [alert?.order],
+            sink: [alert?.sink, Validators.required],
+            systems: [alert?.systems, Validators.required],
+            tags: [alert?.tags || []],
+            target: [alert?.target, [Validators.required, this.validateAlertTarget()]],
+            timeFrom: [alert?.timeFrom],
+            timeTo: [alert?.timeTo],
+            units: [alert?.units, this.validateUnits()],
+        });
+    }
+
     newApiKeyForm(apiKey?: ApiKey): FormGroup {
         return this.ngFormBuilder.group({
             _id: [apiKey?._id],
+            adminRead: [apiKey?.adminRead],
             disabled: [apiKey?.disabled],
+            expiration: [apiKey?.expiration],
             ident: [apiKey?.ident, Validators.required],
             key: [apiKey?.key, [Validators.required, this.validateApiKey()]],
+            lastUsed: [apiKey?.lastUsed],
+            lastUsedIp: [apiKey?.lastUsedIp],
             order: [apiKey?.order],
+            rateLimit: [apiKey?.rateLimit, Validators.min(0)],
+            read: [apiKey?.read],
             systems: [apiKey?.systems, Validators.required],
+            upload: [apiKey?.upload ?? true],
         });
     }
 
     newConfigForm(config?: Config): FormGroup {
         return this.ngFormBuilder.group({
             access: this.ngFormBuilder.array(config?.access?.map((access) => this.newAccessForm(access)) || []),
+            alerts: this.ngFormBuilder.array(config?.alerts?.map((alert) => this.newAlertForm(alert)) || []),
             apiKeys: this.ngFormBuilder.array(config?.apiKeys?.map((apiKey) => this.newApiKeyForm(apiKey)) || []),
             dirWatch: this.ngFormBuilder.array(config?.dirWatch?.map((dirWatch) => this.newDirWatchForm(dirWatch)) || []),
             downstreams: this.ngFormBuilder.array(config?.downstreams?.map((downstream) => this.newDownstreamForm(downstream)) || []),
@@ -421,6 +511,7 @@ export class FreeScannerAdminService implements OnDestroy {
     newDirWatchForm(dirWatch?: DirWatch): FormGroup {
         return this.ngFormBuilder.group({
             _id: [dirWatch?._id],
+            archiveDir: [dirWatch?.archiveDir],
             delay: [typeof dirWatch?.delay === 'number' ? Math.max(2000, dirWatch?.delay) : 2000],
             deleteAfter: [dirWatch?.deleteAfter],
             directory: [dirWatch?.directory, [Validators.required, this.validateDirectory()]],
@@ -429,19 +520,25 @@ export class FreeScannerAdminService implements OnDestroy {
             frequency: [dirWatch?.frequency, Validators.min(0)],
             mask: [dirWatch?.mask, this.validateMask()],
             order: [dirWatch?.order],
+            pollingInterval: [typeof dirWatch?.pollingInterval === 'number' ? dirWatch.pollingInterval : 5000, Validators.min(1000)],
+            quarantineDir: [dirWatch?.quarantineDir],
             systemId: [dirWatch?.systemId, this.validateDirwatchSystemId()],
             talkgroupId: [dirWatch?.talkgroupId, this.validateDirwatchTalkgroupId()],
             type: [dirWatch?.type],
+            usePolling: [dirWatch?.usePolling],
         });
     }
 
     newDownstreamForm(downstream?: Downstream): FormGroup {
         return this.ngFormBuilder.group({
             _id: [downstream?._id],
-            apiKey: [downstream?.apiKey, [Validators.required, this.validateApiKey()]],
+            apiKey: [downstream?.apiKey, [this.validateDownstreamApiKey(), this.validateApiKey()]],
+            audioBaseUrl: [downstream?.audioBaseUrl, this.validateUrl()],
             disabled: [downstream?.disabled],
             order: [downstream?.order],
+            remoteSystem: [downstream?.remoteSystem],
             systems: [downstream?.systems, Validators.required],
+            type: [downstream?.type || 'freescanner'],
             url: [downstream?.url, [Validators.required, this.validateUrl(), this.validateDownstreamUrl()]],
         });
     }
@@ -450,6 +547,7 @@ export class FreeScannerAdminService implements OnDestroy {
         return this.ngFormBuilder.group({
             _id: [group?._id],
             label: [group?.label, Validators.required],
+            pruneDays: [group?.pruneDays, Validators.min(0)],
         });
     }
 
@@ -457,6 +555,7 @@ export class FreeScannerAdminService implements OnDestroy {
         return this.ngFormBuilder.group({
             _id: [tag?._id],
             label: [tag?.label, Validators.required],
+            pruneDays: [tag?.pruneDays, Validators.min(0)],
         });
     }
 
@@ -469,6 +568,7 @@ export class FreeScannerAdminService implements OnDestroy {
             label: [system?.label, Validators.required],
             led: [system?.led],
             order: [system?.order],
+            pruneDays: [system?.pruneDays, Validators.min(0)],
             talkgroups: this.ngFormBuilder.array(system?.talkgroups?.map((talkgroup) => this.newTalkgroupForm(talkgroup)) || []),
             units: this.ngFormBuilder.array(system?.units?.map((unit) => this.newUnitForm(unit)) || []),
         });
@@ -497,12 +597,16 @@ export class FreeScannerAdminService implements OnDestroy {
 
     newOptionsForm(options?: Options): FormGroup {
         return this.ngFormBuilder.group({
+            adminSessionIdle: [options?.adminSessionIdle, [Validators.required, Validators.min(0)]],
+            adminSessionMaxAge: [options?.adminSessionMaxAge, [Validators.required, Validators.min(1)]],
             afsSystems: [options?.afsSystems, this.validateAfsSystems()],
             audioConversion: [options?.audioConversion],
             autoPopulate: [options?.autoPopulate],
             branding: [options?.branding],
             dimmerDelay: [options?.dimmerDelay, [Validators.required, Validators.min(0)]],
             disableDuplicateDetection: [options?.disableDuplicateDetection],
+            downstreamRetryMaxAge: [options?.downstreamRetryMaxAge, [Validators.required, Validators.min(0)]],
+            downstreamRetryMaxAttempts: [options?.downstreamRetryMaxAttempts, [Validators.required, Validators.min(0)]],
             duplicateDetectionTimeFrame: [options?.duplicateDetectionTimeFrame, [Validators.required, Validators.min(0)]],
             email: [options?.email],
             disableBeeps: [options?.disableBeeps],
@@ -510,6 +614,8 @@ export class FreeScannerAdminService implements OnDestroy {
             maxClients: [options?.maxClients, [Validators.required, Validators.min(1)]],
             playbackGoesLive: [options?.playbackGoesLive],
             pruneDays: [options?.pruneDays, [Validators.required, Validators.min(0)]],
+            pruneMaxSize: [options?.pruneMaxSize, [Validators.required, Validators.min(0)]],
+            pruneMinFree: [options?.pruneMinFree, [Validators.required, Validators.min(0)]],
 			searchPatchedTalkgroups: [options?.searchPatchedTalkgroups],
 			showListenersCount: [options?.showListenersCount],
             sortTalkgroups: [options?.sortTalkgroups],
@@ -613,6 +719,23 @@ export class FreeScannerAdminService implements OnDestroy {
         };
     }
 
+    private validateAlertTarget(): ValidatorFn {
+        return (control: AbstractControl): ValidationErrors | null => {
+            if (typeof control.value !== 'string' || !control.value.length) {
+                return null;
+            }
+
+            switch (control.parent?.get('sink')?.value) {
+                case 'email':
+                    return /^[^@\s,]+@[^@\s,]+(\s*,\s*[^@\s,]+@[^@\s,]+)*$/.test(control.value) ? null : { invalid: true };
+                case 'webhook':
+                    return /^https?:\/\/.+$/.test(control.value) ? null : { invalid: true };
+                default:
+                    return null;
+            }
+        };
+    }
+
     private validateApiKey(): ValidatorFn {
         return (control: AbstractControl): ValidationErrors | null => {
             if (typeof control.value !== 'string' || !control.value.length) {
@@ -659,7 +782,7 @@ export class FreeScannerAdminService implements OnDestroy {
 
             const type = dirwatch.type;
 
-            return ['dsdplus', 'trunk-recorder', 'sdr-trunk'].includes(type) || control.value !== null || /#SYS/.test(mask) ? null : { required: true };
+            return ['dsdplus', 'op25', 'trunk-recorder', 'sdr-trunk'].includes(type) || control.value !== null || /#SYS/.test(mask) ? null : { required: true };
         };
     }
 
@@ -671,7 +794,15 @@ export class FreeScannerAdminService implements OnDestroy {
 
             const type = dirwatch.type;
 
-            return ['dsdplus', 'trunk-recorder', 'sdr-trunk'].includes(type) || control.value !== null || /#TG/.test(mask) ? null : { required: true };
+            return ['dsdplus', 'op25', 'trunk-recorder', 'sdr-trunk'].includes(type) || control.value !== null || /#TG/.test(mask) ? null : { required: true };
+        };
+    }
+
+    private validateDownstreamApiKey(): ValidatorFn {
+        return (control: AbstractControl): ValidationErrors | null => {
+            const type = control.parent?.get('type')?.value;
+
+            return type === 'webhook' || (typeof control.value === 'string' && control.value.length) ? null : { required: true };
         };
     }
 
@@ -759,6 +890,12 @@ export class FreeScannerAdminService implements OnDestroy {
         };
     }
 
+    private validateUnits(): ValidatorFn {
+        return (control: AbstractControl): ValidationErrors | null => {
+            return typeof control.value === 'string' && control.value.length ? /^\s*[0-9]+(\s*,\s*[0-9]+)*\s*$/.test(control.value) ? null : { invalid: true } : null;
+        };
+    }
+
     private validateUrl(): ValidatorFn {
         return (control: AbstractControl): ValidationErrors | null => {
             if (typeof control.value !== 'string' || !control.value.length) {
@@ -0,0 +1,112 @@
+/*
+ * *****************************************************************************
+ * Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ * ****************************************************************************
+ */
+
+import { CdkDragDrop, moveItemInArray } from '@angular/cdk/drag-drop';
+import { Component, Input, OnChanges, QueryList, ViewChildren } from '@angular/core';
+import { MatDialog } from '@angular/material/dialog';
+import { FormArray, FormControl, FormGroup } from '@angular/forms';
+import { MatExpansionPanel } from '@angular/material/expansion';
+import { FreeScannerAdminService, Group, Tag } from '../../admin.service';
+import { FreeScannerAdminSystemsSelectComponent } from '../systems/select/select.component';
+
+@Component({
+    selector: 'freescanner-admin-alerts',
+    templateUrl: './alerts.component.html',
+})
+export class FreeScannerAdminAlertsComponent implements OnChanges {
+    @Input() form: FormArray | undefined;
+
+    get alerts(): FormGroup[] {
+        return this.form?.controls
+            .sort((a, b) => a.value.order - b.value.order) as FormGroup[];
+    }
+
+    get groups(): Group[] {
+        return this.form?.root.get('groups')?.value || [];
+    }
+
+    get tags(): Tag[] {
+        return this.form?.root.get('tags')?.value || [];
+    }
+
+    @ViewChildren(MatExpansionPanel) private panels: QueryList<MatExpansionPanel> | undefined;
+
+    constructor(private adminService: FreeScannerAdminService, private matDialog: MatDialog) { }
+
+    ngOnChanges(): void {
+        if (this.form) {
+            this.alerts.forEach((control) => this.registerOnChanges(control));
+        }
+    }
+
+    add(): void {
+        const alert = this.adminService.newAlertForm({ sink: 'webhook', systems: '*' });
+
+        alert.markAllAsTouched();
+
+        this.registerOnChanges(alert);
+
+        this.form?.insert(0, alert);
+
+        this.form?.markAsDirty();
+    }
+
+    closeAll(): void {
+        this.panels?.forEach((panel) => panel.close());
+    }
+
+    drop(event: CdkDragDrop<FormGroup[]>): void {
+        if (event.previousIndex !== event.currentIndex) {
+            moveItemInArray(event.container.data, event.previousIndex, event.currentIndex);
+
+            event.container.data.forEach((dat, idx) => dat.get('order')?.setValue(idx + 1, { emitEvent: false }));
+
+            this.form?.markAsDirty();
+        }
+    }
+
+    remove(index: number): void {
+        this.form?.removeAt(index);
+
+        this.form?.markAsDirty();
+    }
+
+    select(alert: FormGroup): void {
+        const matDialogRef = this.matDialog.open(FreeScannerAdminSystemsSelectComponent, { data: alert });
+
+        matDialogRef.afterClosed().subscribe((data) => {
+            if (data) {
+                alert.get('systems')?.setValue(data);
+
+                alert.markAsDirty();
+            }
+        });
+    }
+
+    private registerOnChanges(control: FormGroup): void {
+        const sink = control.get('sink') as FormControl;
+
+        sink.valueChanges.subscribe(() => {
+            const target = control.get('target');
+
+            target?.updateValueAndValidity();
+            target?.markAsTouched();
+        });
+    }
+}
@@ -20,7 +20,7 @@
 import { ChangeDetectionStrategy, ChangeDetectorRef, Component, OnDestroy, OnInit, QueryList, ViewChildren, ViewEncapsulation } from '@angular/core';
 import { FormArray, FormControl, FormGroup } from '@angular/forms';
 import { MatExpansionPanel } from '@angular/material/expansion';
-import { AdminEvent, FreeScannerAdminService, Config } from '../admin.service';
+import { AdminEvent, FreeScannerAdminService, Config, DownstreamStatus } from '../admin.service';
 
 @Component({
     changeDetection: ChangeDetectionStrategy.OnPush,
@@ -38,6 +38,10 @@ export class FreeScannerAdminConfigComponent implements OnDestroy, OnInit {
         return this.form?.get('access') as FormArray;
     }
 
+    get alerts(): FormArray {
+        return this.form?.get('alerts') as FormArray;
+    }
+
     get apiKeys(): FormArray {
         return this.form?.get('apiKeys') as FormArray;
     }
@@ -58,6 +62,10 @@ export class FreeScannerAdminConfigComponent implements OnDestroy, OnInit {
         return this.form?.get('options') as FormGroup;
     }
 
+    get downstreamsStatus(): { [id: string]: DownstreamStatus } | undefined {
+        return this.config?.downstreamsStatus;
+    }
+
     get systems(): FormArray {
         return this.form?.get('systems') as FormArray;
     }
@@ -22,7 +22,7 @@ import { Component, Input, QueryList, ViewChildren } from '@angular/core';
 import { MatDialog } from '@angular/material/dialog';
 import { FormArray, FormGroup } from '@angular/forms';
 import { MatExpansionPanel } from '@angular/material/expansion';
-import { FreeScannerAdminService } from '../../admin.service';
+import { DownstreamStatus, FreeScannerAdminService } from '../../admin.service';
 import { FreeScannerAdminSystemsSelectComponent } from '../systems/select/select.component';
 
 @Component({
@@ -32,6 +32,8 @@ import { FreeScannerAdminSystemsSelectComponent } from '../systems/select/select
 export class FreeScannerAdminDownstreamsComponent {
     @Input() form: FormArray | undefined;
 
+    @Input() status: { [id: string]: DownstreamStatus } | undefined;
+
     get downstreams(): FormGroup[] {
         return this.form?.controls
             .sort((a, b) => a.value.order - b.value.order) as FormGroup[];
@@ -30,33 +30,47 @@ export class FreeScannerAdminLoginComponent {
     @Output() loggedIn = new EventEmitter<void>();
 
     form = this.formBuilder.group({
+        code: [null],
         password: [null, Validators.required],
+        username: [null],
     });
 
     message = '';
 
+    totpRequired = false;
+
     constructor(
         private adminService: FreeScannerAdminService,
         private formBuilder: FormBuilder,
     ) { }
 
-    async login(password = this.form.get('password')?.value): Promise<void> {
+    async login(password = this.form.get('password')?.value, username = this.form.get('username')?.value, code = this.form.get('code')?.value): Promise<void> {
         if (!password) {
             return;
         }
 
         this.form.disable();
 
-        const loggedIn = await this.adminService.login(password);
+        const loggedIn = await this.adminService.login(password, username, code || undefined);
 
         if (loggedIn) {
             this.loggedIn.emit();
 
+        } else if (this.adminService.totpRequired) {
+            this.form.enable();
+            this.form.get('code')?.reset();
+
+            this.message = code ? 'Invalid authentication code' : '';
+
+            this.totpRequired = true;
+
         } else {
             this.form.enable();
             this.form.reset();
 
-            this.message = 'Invalid password';
+            this.message = 'Invalid username or password';
+
+            this.totpRequired = false;
         }
     }
 }
@@ -59,6 +59,7 @@ enum WebsocketCommand {
     LivefeedMap = 'LFM',
     Max = 'MAX',
     Pin = 'PIN',
+    Transcript = 'TRN',
     Version = 'VER',
 }
 
@@ -111,6 +112,7 @@ export class FreeScannerService implements OnDestroy {
     private skipDelay: Subscription | undefined;
 
     private websocket: WebSocket | undefined;
+    private websocketPendingCall: unknown[] | undefined;
 
     constructor(
         appUpdateService: AppUpdateService,
@@ -799,7 +801,12 @@ export class FreeScannerService implements OnDestroy {
 
     private download(call: FreeScannerCall): void {
         if (call.audio) {
-            const file = call.audio.data.reduce((str, val) => str += String.fromCharCode(val), '');
+            let file = '';
+
+            for (let i = 0; i < call.audio.data.length; i++) {
+                file += String.fromCharCode(call.audio.data[i]);
+            }
+
             const fileName = call.audioName || 'unknown.dat';
             const fileType = call.audioType || 'audio/*';
             const fileUri = `data:${fileType};base64,${window.btoa(file)}`;
@@ -851,6 +858,8 @@ export class FreeScannerService implements OnDestroy {
 
         this.websocket = new WebSocket(websocketUrl);
 
+        this.websocket.binaryType = 'arraybuffer';
+
         this.websocket.onclose = (ev: CloseEvent) => {
             this.event.emit({ linked: false });
 
@@ -863,22 +872,17 @@ export class FreeScannerService implements OnDestroy {
             this.event.emit({ linked: true });
 
             if (this.websocket instanceof WebSocket) {
-                this.websocket.onmessage = (ev: MessageEvent) => this.parseWebsocketMessage(ev.data);
+                this.websocket.onmessage = (ev: MessageEvent) => this.readWebsocketMessage(ev.data);
             }
 
-            this.sendtoWebsocket(WebsocketCommand.Version);
+            this.websocketPendingCall = undefined;
+
+            this.sendtoWebsocket(WebsocketCommand.Version, { audioEncoding: 'binary' });
             this.sendtoWebsocket(WebsocketCommand.Config);
         };
     }
 
-    private parseWebsocketMessage(message: string): void {
-        try {
-            message = JSON.parse(message);
-
-        } catch (error) {
-            console.warn(`Invalid control message received, ${error}`);
-        }
-
+    private parseWebsocketMessage(message: unknown): void {
         if (Array.isArray(message)) {
             switch (message[0]) {
                 case WebsocketCommand.Call:
@@ -976,6 +980,24 @@ export class FreeScannerService implements OnDestroy {
 
                     break;
 
+                case WebsocketCommand.Transcript: {
+                    const data = message[1];
+
+                    if (data !== null && typeof data === 'object' && typeof data.transcript === 'string') {
+                        const call = [this.call, ...this.callQueue].find((c) => c?.id === data.id);
+
+                        if (call) {
+                            call.transcript = data.transcript;
+
+                            if (call === this.call) {
+                                this.event.emit({ transcript: call });
+                            }
+                        }
+                    }
+
+                    break;
+                }
+
                 case WebsocketCommand.Version: {
                     const data = message[1];
 
@@ -1129,6 +1151,42 @@ export class FreeScannerService implements OnDestroy {
         this.categories.sort((a, b) => a.label.localeCompare(b.label));
     }
 
+    private readWebsocketMessage(data: string | ArrayBuffer): void {
+        // with the binary audio encoding, the call audio comes in the frame following the call message
+        if (data instanceof ArrayBuffer) {
+            const message = this.websocketPendingCall;
+
+            this.websocketPendingCall = undefined;
+
+            if (Array.isArray(message) && message[1] !== null && typeof message[1] === 'object') {
+                (message[1] as FreeScannerCall).audio = { type: 'Buffer', data: new Uint8Array(data) };
+
+                this.parseWebsocketMessage(message);
+            }
+
+            return;
+        }
+
+        let message: unknown;
+
+        try {
+            message = JSON.parse(data);
+
+        } catch (error) {
+            console.warn(`Invalid control message received, ${error}`);
+
+            return;
+        }
+
+        if (Array.isArray(message) && message[0] === WebsocketCommand.Call && typeof message[1]?.audioSize === 'number') {
+            this.websocketPendingCall = message;
+
+            return;
+        }
+
+        this.parseWebsocketMessage(message);
+    }
+
     private rebuildLivefeedMap(): void {
         const lfm = this.config.systems.reduce((sysMap, sys) => {
             sysMap[sys.id] = sys.talkgroups.reduce((tgMap, tg) => {
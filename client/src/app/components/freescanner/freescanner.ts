@@ -44,7 +44,7 @@ export enum FreeScannerBeepStyle {
 export interface FreeScannerCall {
     audio?: {
         type: 'Buffer';
-        data: number[];
+        data: ArrayLike<number>;
     };
     audioName?: string;
     audioType?: string;
@@ -57,8 +57,10 @@ export interface FreeScannerCall {
     sources?: FreeScannerCallSource[];
     system: number;
     talkgroup: number;
+    snippet?: string;
     talkgroupData?: FreeScannerTalkgroup;
     systemData?: FreeScannerSystem;
+    transcript?: string;
 }
 
 export interface FreeScannerCallFrequency {
@@ -125,6 +127,7 @@ export interface FreeScannerEvent {
     queue?: number;
     time?: number;
     tooMany?: boolean;
+    transcript?: FreeScannerCall;
 }
 
 export interface FreeScannerKeypadBeeps {
@@ -164,6 +167,7 @@ export interface FreeScannerSearchOptions {
     group?: string;
     limit: number;
     offset: number;
+    query?: string;
     sort: number;
     system?: number;
     tag?: string;
@@ -80,6 +80,7 @@ export class FreeScannerMainComponent implements OnDestroy, OnInit {
     //
 
     callTime = 0;
+    callTranscript = '';
     callUnit = '0';
 
     clock = new Date();
@@ -548,6 +549,8 @@ export class FreeScannerMainComponent implements OnDestroy, OnInit {
 
             this.callTalkgroupName = this.call.talkgroupData?.name || this.formatFrequency(this.call?.frequency);
 
+            this.callTranscript = this.call.transcript || '';
+
             if (Array.isArray(this.call.frequencies) && this.call.frequencies.length) {
                 const frequency = this.call.frequencies.reduce((p, v) => (v.pos || 0) <= time ? v : p, {});
 
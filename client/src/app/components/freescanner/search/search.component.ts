@@ -45,6 +45,7 @@ export class FreeScannerSearchComponent implements OnDestroy {
     form = this.ngFormBuilder.group({
         date: [null],
         group: [-1],
+        query: [''],
         sort: [-1],
         system: [-1],
         tag: [-1],
@@ -205,6 +206,7 @@ export class FreeScannerSearchComponent implements OnDestroy {
         this.form.reset({
             date: null,
             group: -1,
+            query: '',
             sort: -1,
             system: -1,
             tag: -1,
@@ -245,6 +247,10 @@ export class FreeScannerSearchComponent implements OnDestroy {
             }
         }
 
+        if (typeof this.form.value.query === 'string' && this.form.value.query.trim()) {
+            options.query = this.form.value.query.trim();
+        }
+
         if (this.form.value.system >= 0) {
             const system = this.getSelectedSystem();
 
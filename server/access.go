@@ -19,7 +19,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"math"
 	"sync"
 	"time"
 )
@@ -336,14 +336,9 @@ func (accesses *Accesses) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerAccesses` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerAccesses").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -375,3 +370,19 @@ func (accesses *Accesses) Write(db *Database) error {
 
 	return nil
 }
+
+func toUint(v any) (uint, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v >= 0 && v == math.Trunc(v) {
+			return uint(v), true
+		}
+	case int:
+		if v >= 0 {
+			return uint(v), true
+		}
+	case uint:
+		return v, true
+	}
+	return 0, false
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,7 +29,6 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Admin struct {
@@ -39,7 +39,6 @@ type Admin struct {
 	Conns            map[*websocket.Conn]bool
 	Controller       *Controller
 	Register         chan *websocket.Conn
-	Tokens           []string
 	Unregister       chan *websocket.Conn
 	mutex            sync.Mutex
 	running          bool
@@ -61,12 +60,196 @@ func NewAdmin(controller *Controller) *Admin {
 		Conns:            make(map[*websocket.Conn]bool),
 		Controller:       controller,
 		Register:         make(chan *websocket.Conn),
-		Tokens:           []string{},
 		Unregister:       make(chan *websocket.Conn),
 		mutex:            sync.Mutex{},
 	}
 }
 
+// AdminUserAddHandler creates an admin user or updates its role and password,
+// given as {"username": "jane", "password": "secret", "role": "editor"}. The
+// sessions of an admin user are revoked when another one resets its password.
+func (admin *Admin) AdminUserAddHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.adminuseraddhandler.post: %s", err.Error()))
+		}
+
+		user, ok := admin.authorize(w, r, (*AdminUser).CanManageUsers)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		target := &AdminUser{Role: AdminRoleReadOnly}
+
+		switch v := m["username"].(type) {
+		case string:
+			target.Username = strings.TrimSpace(v)
+		}
+
+		current, found := admin.Controller.AdminUsers.GetUser(target.Username)
+		if found {
+			*target = *current
+		}
+
+		switch v := m["role"].(type) {
+		case string:
+			target.Role = v
+		}
+
+		if err := target.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		passwordChanged := false
+
+		switch v := m["password"].(type) {
+		case string:
+			if len(v) > 0 {
+				if err := target.SetPassword(v); err != nil {
+					logError(err)
+					w.WriteHeader(http.StatusExpectationFailed)
+					return
+				}
+				target.PasswordNeedChange = target.PasswordNeedChange || !strings.EqualFold(target.Username, user.Username)
+				passwordChanged = true
+			}
+		}
+
+		if len(target.Password) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if found && current.Role == AdminRoleOwner && target.Role != AdminRoleOwner && admin.Controller.AdminUsers.CountOwners(target.Username) == 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		admin.Controller.AdminUsers.Add(target)
+
+		if err := admin.Controller.AdminUsers.Write(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		if err := admin.Controller.AdminUsers.Read(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		// a password reset locks the admin user out of its current sessions
+		if found && passwordChanged && !strings.EqualFold(target.Username, user.Username) {
+			if _, err := admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(session *AdminSession) bool {
+				return strings.EqualFold(session.Username, target.Username)
+			}); err != nil {
+				logError(err)
+			}
+		}
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("admin user %s with role %s saved by %s", target.Username, target.Role, user.Username))
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminUserRemoveHandler removes an admin user, given as {"username": "jane"},
+// and revokes its tokens. The last owner can't be removed.
+func (admin *Admin) AdminUserRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.adminuserremovehandler.post: %s", err.Error()))
+		}
+
+		user, ok := admin.authorize(w, r, (*AdminUser).CanManageUsers)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var username string
+
+		switch v := m["username"].(type) {
+		case string:
+			username = strings.TrimSpace(v)
+		}
+
+		target, found := admin.Controller.AdminUsers.GetUser(username)
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if target.Role == AdminRoleOwner && admin.Controller.AdminUsers.CountOwners(target.Username) == 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		admin.Controller.AdminUsers.Remove(target.Username)
+
+		if err := admin.Controller.AdminUsers.Write(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		if err := admin.Controller.AdminUsers.Read(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		if _, err := admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(session *AdminSession) bool {
+			return strings.EqualFold(session.Username, target.Username)
+		}); err != nil {
+			logError(err)
+		}
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("admin user %s removed by %s", target.Username, user.Username))
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := admin.authorize(w, r, (*AdminUser).CanManageUsers); !ok {
+			return
+		}
+
+		if b, err := json.Marshal(admin.Controller.AdminUsers.List); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (admin *Admin) BroadcastConfig() {
 	if b, err := json.Marshal(admin.GetConfig()); err == nil {
 		for conn := range admin.Conns {
@@ -75,43 +258,80 @@ func (admin *Admin) BroadcastConfig() {
 	}
 }
 
-func (admin *Admin) ChangePassword(currentPassword any, newPassword string) error {
-	var (
-		err  error
-		hash []byte
-	)
-
+func (admin *Admin) ChangePassword(user *AdminUser, currentPassword any, newPassword string) error {
 	if len(newPassword) == 0 {
 		return errors.New("newPassword is empty")
 	}
 
 	switch v := currentPassword.(type) {
 	case string:
-		if err = bcrypt.CompareHashAndPassword([]byte(admin.Controller.Options.adminPassword), []byte(v)); err != nil {
-			return err
+		if !user.CheckPassword(v) {
+			return errors.New("current password is invalid")
 		}
 	}
 
-	if hash, err = bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost); err != nil {
-		return err
-	}
-
-	admin.Controller.Options.adminPassword = string(hash)
-	admin.Controller.Options.adminPasswordNeedChange = newPassword == defaults.adminPassword
-
-	if err := admin.Controller.Options.Write(admin.Controller.Database); err != nil {
+	if err := user.SetPassword(newPassword); err != nil {
 		return err
 	}
 
-	if err := admin.Controller.Options.Read(admin.Controller.Database); err != nil {
+	if err := admin.Controller.AdminUsers.Write(admin.Controller.Database); err != nil {
 		return err
 	}
 
-	admin.Controller.Logs.LogEvent(LogLevelWarn, "admin password changed.")
+	admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("admin password changed for %s.", user.Username))
 
 	return nil
 }
 
+// CallKeepHandler flags a call, given as {"id": 1, "keep": true}, to be kept
+// forever whatever the retention rules.
+func (admin *Admin) CallKeepHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user, ok := admin.authorize(w, r, (*AdminUser).CanEditConfig)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var (
+			id   uint
+			keep bool
+		)
+
+		switch v := m["id"].(type) {
+		case float64:
+			id = uint(v)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch v := m["keep"].(type) {
+		case bool:
+			keep = v
+		}
+
+		if ok, err := admin.Controller.Calls.SetKeep(admin.Controller.Database, id, keep); err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.callkeephandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+		} else if !ok {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			admin.Controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("call %d keep set to %v by %s", id, keep, user.Username))
+			w.WriteHeader(http.StatusOK)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if strings.EqualFold(r.Header.Get("upgrade"), "websocket") {
 		upgrader := websocket.Upgrader{}
@@ -132,7 +352,7 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 					break
 				}
 
-				if !admin.ValidateToken(string(b)) {
+				if user, ok := admin.GetUser(string(b)); !ok || !user.CanReadConfig() {
 					break
 				}
 			}
@@ -143,21 +363,21 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		}()
 
 	} else {
-		logError := func(err error) {
-			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.confighandler.put: %s", err.Error()))
-		}
-
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
 		switch r.Method {
 		case http.MethodGet:
-			admin.SendConfig(w)
+			user, ok := admin.authorize(w, r, (*AdminUser).CanReadConfig)
+			if !ok {
+				return
+			}
+
+			admin.SendConfig(w, user)
 
 		case http.MethodPut:
+			user, ok := admin.authorize(w, r, (*AdminUser).CanEditConfig)
+			if !ok {
+				return
+			}
+
 			m := map[string]any{}
 			err := json.NewDecoder(r.Body).Decode(&m)
 			if err != nil {
@@ -168,164 +388,251 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 			admin.mutex.Lock()
 			defer admin.mutex.Unlock()
 
-			admin.Controller.Dirwatches.Stop()
+			before := admin.getConfigSnapshot()
 
-			switch v := m["access"].(type) {
-			case []any:
-				admin.Controller.Accesses.FromMap(v)
-				err := admin.Controller.Accesses.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Accesses.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
-				}
-			}
+			admin.applyConfig(m)
 
-			switch v := m["apiKeys"].(type) {
-			case []any:
-				admin.Controller.Apikeys.FromMap(v)
-				err = admin.Controller.Apikeys.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Apikeys.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
-				}
+			admin.SendConfig(w, user)
+
+			admin.saveConfigVersion(before, user, "")
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ConfigRollbackHandler restores the config snapshot of a version, given as
+// {"id": 12}, which is recorded as a new version.
+func (admin *Admin) ConfigRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user, ok := admin.authorize(w, r, (*AdminUser).CanEditConfig)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var id uint
+
+		switch v := m["id"].(type) {
+		case float64:
+			id = uint(v)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version, err := admin.Controller.ConfigVersions.Get(admin.Controller.Database, id)
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.configrollbackhandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		} else if version == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		before := admin.getConfigSnapshot()
+
+		admin.applyConfig(version.Config)
+
+		admin.SendConfig(w, user)
+
+		admin.saveConfigVersion(before, user, fmt.Sprintf("rollback to version %d", id))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// ConfigVersionsHandler lists the config versions, or returns the one of the id
+// query parameter with its snapshot and diff. With the compare query parameter,
+// the diff goes from that version to another version or to the current config.
+func (admin *Admin) ConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var v any
+
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.configversionshandler.get: %s", err.Error()))
+		}
+
+		user, ok := admin.authorize(w, r, (*AdminUser).CanReadConfig)
+		if !ok {
+			return
+		}
+
+		getVersion := func(s string) (*ConfigVersion, bool) {
+			id, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return nil, false
 			}
 
-			switch v := m["dirWatch"].(type) {
-			case []any:
-				admin.Controller.Dirwatches.FromMap(v)
-				err = admin.Controller.Dirwatches.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Dirwatches.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
-				}
+			version, err := admin.Controller.ConfigVersions.Get(admin.Controller.Database, uint(id))
+			if err != nil {
+				logError(err)
+				w.WriteHeader(http.StatusExpectationFailed)
+				return nil, false
+			} else if version == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return nil, false
 			}
 
-			switch v := m["downstreams"].(type) {
-			case []any:
-				admin.Controller.Downstreams.FromMap(v)
-				err = admin.Controller.Downstreams.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Downstreams.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
-				}
+			return version, true
+		}
+
+		query := r.URL.Query()
+
+		if query.Has("id") {
+			version, ok := getVersion(query.Get("id"))
+			if !ok {
+				return
 			}
 
-			switch v := m["groups"].(type) {
-			case []any:
-				admin.Controller.Groups.FromMap(v)
-				err = admin.Controller.Groups.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Groups.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
+			switch compare := query.Get("compare"); compare {
+			case "":
+			case "current":
+				version.Diff = NewConfigDiff(version.Config, admin.getConfigSnapshot())
+				version.Sections = version.Diff.getSections()
+			default:
+				other, ok := getVersion(compare)
+				if !ok {
+					return
 				}
+				version.Diff = NewConfigDiff(version.Config, other.Config)
+				version.Sections = version.Diff.getSections()
 			}
 
-			switch v := m["options"].(type) {
-			case map[string]any:
-				admin.Controller.Options.FromMap(v)
-				err = admin.Controller.Options.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				}
+			if user.apikey {
+				redactConfigSecrets(version.Config)
+				version.Diff.redactSecrets()
 			}
 
-			switch v := m["systems"].(type) {
-			case []any:
-				admin.Controller.Systems.FromMap(v)
-				err = admin.Controller.Systems.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Systems.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
-				}
+			v = version
+
+		} else {
+			versions, err := admin.Controller.ConfigVersions.List(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+				w.WriteHeader(http.StatusExpectationFailed)
+				return
 			}
 
-			switch v := m["tags"].(type) {
-			case []any:
-				admin.Controller.Tags.FromMap(v)
-				err = admin.Controller.Tags.Write(admin.Controller.Database)
-				if err != nil {
-					logError(err)
-				} else {
-					err = admin.Controller.Tags.Read(admin.Controller.Database)
-					if err != nil {
-						logError(err)
-					}
+			if user.apikey {
+				for _, version := range versions {
+					version.Diff.redactSecrets()
 				}
 			}
 
-			admin.Controller.EmitConfig()
-			admin.Controller.Dirwatches.Start(admin.Controller)
+			v = versions
+		}
 
-			admin.SendConfig(w)
+		if b, err := json.Marshal(v); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
+		}
 
-			admin.Controller.Logs.LogEvent(LogLevelWarn, "configuration changed")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
 
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
+func (admin *Admin) GetAuthorization(r *http.Request) string {
+	return r.Header.Get("Authorization")
+}
+
+func (admin *Admin) GetConfig() map[string]any {
+	systems := []map[string]any{}
+	for _, system := range admin.Controller.Systems.List {
+		systems = append(systems, map[string]any{
+			"_id":          system.RowId,
+			"autoPopulate": system.AutoPopulate,
+			"blacklists":   system.Blacklists,
+			"id":           system.Id,
+			"label":        system.Label,
+			"led":          system.Led,
+			"order":        system.Order,
+			"pruneDays":    system.PruneDays,
+			"talkgroups":   system.Talkgroups.List,
+			"units":        system.Units.List,
+		})
+	}
+
+	downstreamsStatus, err := admin.Controller.DownstreamQueue.GetStatus()
+	if err != nil {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.getconfig: %v", err))
+	}
+
+	return map[string]any{
+		"access":            admin.Controller.Accesses.List,
+		"alerts":            admin.Controller.Alerts.List,
+		"apiKeys":           admin.Controller.Apikeys.List,
+		"dirWatch":          admin.Controller.Dirwatches.List,
+		"downstreams":       admin.Controller.Downstreams.List,
+		"downstreamsStatus": downstreamsStatus,
+		"groups":            admin.Controller.Groups.List,
+		"options":           admin.Controller.Options,
+		"systems":           systems,
+		"tags":              admin.Controller.Tags.List,
+	}
+}
+
+// GetSession returns the session of a token that is valid, neither expired nor
+// revoked, and marks it as seen.
+func (admin *Admin) GetSession(sToken string) (*AdminSession, bool) {
+	claims := &jwt.RegisteredClaims{}
+
+	token, err := jwt.ParseWithClaims(sToken, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+
+		return []byte(admin.Controller.Options.secret), nil
+	})
+	if err != nil || !token.Valid || claims.ExpiresAt == nil {
+		return nil, false
+	}
+
+	session, ok := admin.Controller.AdminSessions.GetSession(claims.ID)
+	if !ok || session.Username != claims.Subject {
+		return nil, false
+	}
+
+	if session.IsExpired(admin.getSessionIdle()) {
+		admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(s *AdminSession) bool { return s == session })
+		return nil, false
+	}
+
+	if err := admin.Controller.AdminSessions.Touch(admin.Controller.Database, session); err != nil {
+		admin.Controller.Logs.LogEvent(LogLevelError, err.Error())
 	}
-}
 
-func (admin *Admin) GetAuthorization(r *http.Request) string {
-	return r.Header.Get("Authorization")
+	return session, true
 }
 
-func (admin *Admin) GetConfig() map[string]any {
-	systems := []map[string]any{}
-	for _, system := range admin.Controller.Systems.List {
-		systems = append(systems, map[string]any{
-			"_id":          system.RowId,
-			"autoPopulate": system.AutoPopulate,
-			"blacklists":   system.Blacklists,
-			"id":           system.Id,
-			"label":        system.Label,
-			"led":          system.Led,
-			"order":        system.Order,
-			"talkgroups":   system.Talkgroups.List,
-			"units":        system.Units.List,
-		})
+// GetUser returns the admin user of the session of a valid token.
+func (admin *Admin) GetUser(sToken string) (*AdminUser, bool) {
+	session, ok := admin.GetSession(sToken)
+	if !ok {
+		return nil, false
 	}
 
-	return map[string]any{
-		"access":      admin.Controller.Accesses.List,
-		"apiKeys":     admin.Controller.Apikeys.List,
-		"dirWatch":    admin.Controller.Dirwatches.List,
-		"downstreams": admin.Controller.Downstreams.List,
-		"groups":      admin.Controller.Groups.List,
-		"options":     admin.Controller.Options,
-		"systems":     systems,
-		"tags":        admin.Controller.Tags.List,
-	}
+	return admin.Controller.AdminUsers.GetUser(session.Username)
 }
 
 func (admin *Admin) LogsHandler(w http.ResponseWriter, r *http.Request) {
-	t := admin.GetAuthorization(r)
-	if !admin.ValidateToken(t) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if _, ok := admin.authorize(w, r, (*AdminUser).CanReadLogs); !ok {
 		return
 	}
 
@@ -399,23 +706,67 @@ func (admin *Admin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		ok := false
+		var (
+			password string
+			username = defaults.adminUsername
+		)
 
 		switch v := m["password"].(type) {
 		case string:
-			if len(v) > 0 {
-				if err := bcrypt.CompareHashAndPassword([]byte(admin.Controller.Options.adminPassword), []byte(v)); err == nil {
-					ok = true
-				}
+			password = v
+		}
+
+		switch v := m["username"].(type) {
+		case string:
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				username = v
 			}
 		}
 
-		if !ok {
-			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("invalid login attempt for ip %v", remoteAddr))
+		admin.readAdminUsers()
+
+		user, ok := admin.Controller.AdminUsers.GetUser(username)
+
+		if !ok || !user.CheckPassword(password) {
+			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("invalid login attempt for username %s and ip %v", username, remoteAddr))
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		if user.TotpEnabled {
+			var code string
+
+			switch v := m["code"].(type) {
+			case string:
+				code = v
+			}
+
+			if len(code) == 0 {
+				attempt.Count--
+				admin.sendTotpRequired(w)
+				return
+			}
+
+			admin.mutex.Lock()
+			recovery, ok := user.CheckTotp(code)
+			if ok && recovery {
+				if err := admin.Controller.AdminUsers.Write(admin.Controller.Database); err != nil {
+					admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.loginhandler.post: %s", err.Error()))
+				}
+			}
+			admin.mutex.Unlock()
+
+			if !ok {
+				admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("invalid two-factor code for username %s and ip %v", username, remoteAddr))
+				admin.sendTotpRequired(w)
+				return
+			}
+
+			if recovery {
+				admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("recovery code used by %s, %d left", user.Username, len(user.RecoveryCodes)))
+			}
+		}
+
 		id, err := uuid.NewRandom()
 
 		if err != nil {
@@ -423,7 +774,24 @@ func (admin *Admin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{ID: id.String()})
+		now := time.Now().UTC()
+
+		session := &AdminSession{
+			DateCreated:  now,
+			DateExpires:  now.Add(admin.getSessionMaxAge()),
+			DateLastSeen: now,
+			Ip:           remoteAddr,
+			TokenId:      id.String(),
+			UserAgent:    r.UserAgent(),
+			Username:     user.Username,
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.DateExpires),
+			ID:        session.TokenId,
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   user.Username,
+		})
 		sToken, err := token.SignedString([]byte(admin.Controller.Options.secret))
 
 		if err != nil {
@@ -431,15 +799,20 @@ func (admin *Admin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if len(admin.Tokens) < 5 {
-			admin.Tokens = append(admin.Tokens, sToken)
-		} else {
-			admin.Tokens = append(admin.Tokens[1:], sToken)
+		if err = admin.Controller.AdminSessions.Add(admin.Controller.Database, session); err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.loginhandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
 		}
 
+		admin.Controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("admin user %s logged in from ip %v", user.Username, remoteAddr))
+
 		b, err := json.Marshal(map[string]any{
-			"passwordNeedChange": true,
+			"expires":            session.DateExpires,
+			"passwordNeedChange": user.PasswordNeedChange,
+			"role":               user.Role,
 			"token":              sToken,
+			"username":           user.Username,
 		})
 		if err != nil {
 			w.WriteHeader(http.StatusExpectationFailed)
@@ -462,16 +835,289 @@ func (admin *Admin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 func (admin *Admin) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
+		session, ok := admin.GetSession(admin.GetAuthorization(r))
+		if !ok {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		for k, v := range admin.Tokens {
-			if v == t {
-				admin.Tokens = append(admin.Tokens[:k], admin.Tokens[k+1:]...)
-			}
-		}
+		if _, err := admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(s *AdminSession) bool { return s == session }); err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.logouthandler.post: %s", err.Error()))
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) PasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var (
+			b               []byte
+			currentPassword any
+			newPassword     string
+		)
+
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.passwordhandler.post: %s", err.Error()))
+		}
+
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch v := m["currentPassword"].(type) {
+		case string:
+			currentPassword = v
+		}
+
+		switch v := m["newPassword"].(type) {
+		case string:
+			newPassword = v
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err = admin.ChangePassword(user, currentPassword, newPassword); err != nil {
+			logError(fmt.Errorf("unable to change admin password for %s, %v", user.Username, err))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		session, _ := admin.GetSession(admin.GetAuthorization(r))
+
+		if _, err = admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(s *AdminSession) bool {
+			return s.Username == user.Username && s != session
+		}); err != nil {
+			logError(err)
+		}
+
+		if b, err = json.Marshal(map[string]any{"passwordNeedChange": user.PasswordNeedChange}); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// SendConfig sends the config along with the admin user details, the secrets
+// of the config being left out for the API keys.
+func (admin *Admin) SendConfig(w http.ResponseWriter, user *AdminUser) {
+	var config any = admin.GetConfig()
+
+	if user.apikey {
+		redacted := map[string]any{}
+		if b, err := json.Marshal(config); err == nil {
+			json.Unmarshal(b, &redacted)
+		}
+		redactConfigSecrets(redacted)
+		config = redacted
+	}
+
+	m := map[string]any{
+		"config":             config,
+		"passwordNeedChange": user.PasswordNeedChange,
+		"role":               user.Role,
+		"totpEnabled":        user.TotpEnabled,
+		"username":           user.Username,
+	}
+	if _, docker := os.LookupEnv("DOCKER"); docker {
+		m["docker"] = docker
+	}
+	if b, err := json.Marshal(m); err == nil {
+		w.Write(b)
+	} else {
+		w.WriteHeader(http.StatusExpectationFailed)
+	}
+}
+
+// SessionRevokeHandler revokes a session, given as {"id": 3}, or all the
+// sessions of an admin user, given as {"username": "jane"}. Only the owners
+// may revoke the sessions of others.
+func (admin *Admin) SessionRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var match func(*AdminSession) bool
+
+		switch v := m["id"].(type) {
+		case float64:
+			match = func(session *AdminSession) bool { return session.Id == uint(v) }
+		}
+
+		switch v := m["username"].(type) {
+		case string:
+			match = func(session *AdminSession) bool { return strings.EqualFold(session.Username, v) }
+		}
+
+		if match == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		count, err := admin.Controller.AdminSessions.Remove(admin.Controller.Database, func(session *AdminSession) bool {
+			return match(session) && (user.CanManageUsers() || session.Username == user.Username)
+		})
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.sessionrevokehandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		if count == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("%d admin sessions revoked by %s", count, user.Username))
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// SessionsHandler lists the active sessions, of all the admin users for the
+// owners and of their own for the others.
+func (admin *Admin) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
+			return
+		}
+
+		current, _ := admin.GetSession(admin.GetAuthorization(r))
+
+		username := user.Username
+		if user.CanManageUsers() {
+			username = ""
+		}
+
+		type session struct {
+			*AdminSession
+			Current bool `json:"current"`
+		}
+
+		sessions := []session{}
+		for _, s := range admin.Controller.AdminSessions.GetSessions(username) {
+			if !s.IsExpired(admin.getSessionIdle()) {
+				sessions = append(sessions, session{AdminSession: s, Current: s == current})
+			}
+		}
+
+		if b, err := json.Marshal(sessions); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) Start() error {
+	if admin.running {
+		return errors.New("admin already running")
+	} else {
+		admin.running = true
+	}
+
+	go func() {
+		for {
+			select {
+			case data, ok := <-admin.Broadcast:
+				if !ok {
+					return
+				}
+
+				for conn := range admin.Conns {
+					err := conn.WriteMessage(websocket.TextMessage, *data)
+					if err != nil {
+						admin.Unregister <- conn
+					}
+				}
+
+			case conn := <-admin.Register:
+				admin.Conns[conn] = true
+
+			case conn := <-admin.Unregister:
+				if _, ok := admin.Conns[conn]; ok {
+					delete(admin.Conns, conn)
+					conn.Close()
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// TotpDisableHandler turns off the two-factor authentication of the admin user,
+// given its password as {"password": "secret"}.
+func (admin *Admin) TotpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
+			return
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch v := m["password"].(type) {
+		case string:
+			if !user.CheckPassword(v) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		user.ResetTotp()
+
+		if err := admin.Controller.AdminUsers.Write(admin.Controller.Database); err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.totpdisablehandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("two-factor authentication disabled for %s", user.Username))
+
 		w.WriteHeader(http.StatusOK)
 
 	default:
@@ -479,52 +1125,70 @@ func (admin *Admin) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (admin *Admin) PasswordHandler(w http.ResponseWriter, r *http.Request) {
+// TotpEnableHandler turns on the two-factor authentication once the admin user
+// proves its authenticator holds the secret, given a code as {"code": "123456"},
+// and returns the recovery codes, which are shown only once.
+func (admin *Admin) TotpEnableHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		var (
-			b               []byte
-			currentPassword any
-			newPassword     string
-		)
-
-		logError := func(err error) {
-			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.passwordhandler.post: %s", err.Error()))
-		}
+		var code string
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
-			w.WriteHeader(http.StatusUnauthorized)
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
 			return
 		}
 
 		m := map[string]any{}
-		err := json.NewDecoder(r.Body).Decode(&m)
-		if err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		switch v := m["currentPassword"].(type) {
-		case string:
-			currentPassword = v
-		}
-
-		switch v := m["newPassword"].(type) {
+		switch v := m["code"].(type) {
 		case string:
-			newPassword = v
+			code = v
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if err = admin.ChangePassword(currentPassword, newPassword); err != nil {
-			logError(errors.New("unable to change admin password, current password is invalid"))
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		if user.TotpEnabled {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		if len(user.TotpSecret) == 0 {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		step, ok := ValidateTotpCode(user.TotpSecret, code, time.Now())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		codes, err := NewTotpRecoveryCodes()
+		if err == nil {
+			err = user.SetRecoveryCodes(codes)
+		}
+		if err == nil {
+			user.TotpEnabled = true
+			user.totpStep = step
+			err = admin.Controller.AdminUsers.Write(admin.Controller.Database)
+		}
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.totpenablehandler.post: %s", err.Error()))
 			w.WriteHeader(http.StatusExpectationFailed)
 			return
 		}
 
-		if b, err = json.Marshal(map[string]any{"passwordNeedChange": admin.Controller.Options.adminPasswordNeedChange}); err == nil {
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("two-factor authentication enabled for %s", user.Username))
+
+		if b, err := json.Marshal(map[string]any{"recoveryCodes": codes}); err == nil {
 			w.Write(b)
 		} else {
 			w.WriteHeader(http.StatusExpectationFailed)
@@ -535,63 +1199,62 @@ func (admin *Admin) PasswordHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (admin *Admin) SendConfig(w http.ResponseWriter) {
-	var m map[string]any
-	_, docker := os.LookupEnv("DOCKER")
-	if docker {
-		m = map[string]any{
-			"config":             admin.GetConfig(),
-			"docker":             docker,
-			"passwordNeedChange": admin.Controller.Options.adminPasswordNeedChange,
+// TotpSetupHandler generates a new secret for the admin user, given its
+// password as {"password": "secret"}, and returns it along with the
+// provisioning uri for the authenticator apps. It takes effect once enabled.
+func (admin *Admin) TotpSetupHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user, ok := admin.authorize(w, r, nil)
+		if !ok {
+			return
 		}
-	} else {
-		m = map[string]any{
-			"config":             admin.GetConfig(),
-			"passwordNeedChange": admin.Controller.Options.adminPasswordNeedChange,
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
-	}
-	if b, err := json.Marshal(m); err == nil {
-		w.Write(b)
-	} else {
-		w.WriteHeader(http.StatusExpectationFailed)
-	}
-}
 
-func (admin *Admin) Start() error {
-	if admin.running {
-		return errors.New("admin already running")
-	} else {
-		admin.running = true
-	}
+		switch v := m["password"].(type) {
+		case string:
+			if !user.CheckPassword(v) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-	go func() {
-		for {
-			select {
-			case data, ok := <-admin.Broadcast:
-				if !ok {
-					return
-				}
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
 
-				for conn := range admin.Conns {
-					err := conn.WriteMessage(websocket.TextMessage, *data)
-					if err != nil {
-						admin.Unregister <- conn
-					}
-				}
+		if user.TotpEnabled {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
 
-			case conn := <-admin.Register:
-				admin.Conns[conn] = true
+		secret, err := NewTotpSecret()
+		if err == nil {
+			user.TotpSecret = secret
+			err = admin.Controller.AdminUsers.Write(admin.Controller.Database)
+		}
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.totpsetuphandler.post: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
 
-			case conn := <-admin.Unregister:
-				if _, ok := admin.Conns[conn]; ok {
-					delete(admin.Conns, conn)
-					conn.Close()
-				}
-			}
+		if b, err := json.Marshal(map[string]any{"secret": secret, "uri": GetTotpUri(user.Username, secret)}); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
 		}
-	}()
 
-	return nil
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
 }
 
 func (admin *Admin) UserAddHandler(w http.ResponseWriter, r *http.Request) {
@@ -601,9 +1264,8 @@ func (admin *Admin) UserAddHandler(w http.ResponseWriter, r *http.Request) {
 			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.useraddhandler.post: %s", err.Error()))
 		}
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
-			w.WriteHeader(http.StatusUnauthorized)
+		user, ok := admin.authorize(w, r, (*AdminUser).CanEditConfig)
+		if !ok {
 			return
 		}
 
@@ -614,11 +1276,19 @@ func (admin *Admin) UserAddHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		admin.Controller.Accesses.Add(NewAccess().FromMap(m))
+		access := NewAccess().FromMap(m)
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		before := admin.getConfigSnapshot()
+
+		admin.Controller.Accesses.Add(access)
 
 		if err := admin.Controller.Accesses.Write(admin.Controller.Database); err == nil {
 			if err := admin.Controller.Accesses.Read(admin.Controller.Database); err == nil {
 				admin.BroadcastConfig()
+				admin.saveConfigVersion(before, user, fmt.Sprintf("user access %s added", access.Ident))
 				w.WriteHeader(http.StatusOK)
 			} else {
 				logError(err)
@@ -641,9 +1311,8 @@ func (admin *Admin) UserRemoveHandler(w http.ResponseWriter, r *http.Request) {
 			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.userremovehandler.post: %s", err.Error()))
 		}
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
-			w.WriteHeader(http.StatusUnauthorized)
+		user, ok := admin.authorize(w, r, (*AdminUser).CanEditConfig)
+		if !ok {
 			return
 		}
 
@@ -654,10 +1323,18 @@ func (admin *Admin) UserRemoveHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if _, ok := admin.Controller.Accesses.Remove(NewAccess().FromMap(m)); ok {
+		access := NewAccess().FromMap(m)
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		before := admin.getConfigSnapshot()
+
+		if _, ok := admin.Controller.Accesses.Remove(access); ok {
 			if err := admin.Controller.Accesses.Write(admin.Controller.Database); err == nil {
 				if err := admin.Controller.Accesses.Read(admin.Controller.Database); err == nil {
 					admin.BroadcastConfig()
+					admin.saveConfigVersion(before, user, fmt.Sprintf("user access %s removed", access.Ident))
 					w.WriteHeader(http.StatusOK)
 				} else {
 					logError(err)
@@ -674,28 +1351,291 @@ func (admin *Admin) UserRemoveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ValidateToken tells if the token is valid and its session neither expired
+// nor revoked.
 func (admin *Admin) ValidateToken(sToken string) bool {
-	found := false
-	for _, t := range admin.Tokens {
-		if t == sToken {
-			found = true
-			break
+	_, ok := admin.GetSession(sToken)
+	return ok
+}
+
+// applyConfig writes the sections of the config that are given, the others
+// being left untouched, and emits the new config.
+func (admin *Admin) applyConfig(m map[string]any) {
+	var err error
+
+	logError := func(err error) {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.applyconfig: %s", err.Error()))
+	}
+
+	admin.Controller.Dirwatches.Stop()
+
+	switch v := m["access"].(type) {
+	case []any:
+		admin.Controller.Accesses.FromMap(v)
+		err = admin.Controller.Accesses.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Accesses.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
 		}
 	}
-	if !found {
-		return false
+
+	switch v := m["alerts"].(type) {
+	case []any:
+		admin.Controller.Alerts.FromMap(v)
+		err = admin.Controller.Alerts.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Alerts.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
 	}
 
-	token, err := jwt.Parse(sToken, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	switch v := m["apiKeys"].(type) {
+	case []any:
+		admin.Controller.Apikeys.FromMap(v)
+		err = admin.Controller.Apikeys.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Apikeys.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
 		}
+	}
 
-		return []byte(admin.Controller.Options.secret), nil
-	})
-	if err != nil {
-		return false
+	switch v := m["dirWatch"].(type) {
+	case []any:
+		admin.Controller.Dirwatches.FromMap(v)
+		err = admin.Controller.Dirwatches.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Dirwatches.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
+	}
+
+	switch v := m["downstreams"].(type) {
+	case []any:
+		admin.Controller.Downstreams.FromMap(v)
+		err = admin.Controller.Downstreams.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Downstreams.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
+	}
+
+	switch v := m["groups"].(type) {
+	case []any:
+		admin.Controller.Groups.FromMap(v)
+		err = admin.Controller.Groups.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Groups.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
+	}
+
+	switch v := m["options"].(type) {
+	case map[string]any:
+		admin.Controller.Options.FromMap(v)
+		err = admin.Controller.Options.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		}
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		admin.Controller.Systems.FromMap(v)
+		err = admin.Controller.Systems.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Systems.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
+	}
+
+	switch v := m["tags"].(type) {
+	case []any:
+		admin.Controller.Tags.FromMap(v)
+		err = admin.Controller.Tags.Write(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+		} else {
+			err = admin.Controller.Tags.Read(admin.Controller.Database)
+			if err != nil {
+				logError(err)
+			}
+		}
+	}
+
+	admin.Controller.EmitConfig()
+	admin.Controller.Dirwatches.Start(admin.Controller)
+}
+
+// authorize answers 401 if the request has no valid token and 403 if the
+// role of its admin user is not allowed, a nil allowed accepting any role.
+// Unless allowed is nil, an API key with the admin-read permission is also
+// accepted, acting as a read-only user.
+func (admin *Admin) authorize(w http.ResponseWriter, r *http.Request, allowed func(*AdminUser) bool) (*AdminUser, bool) {
+	admin.readAdminUsers()
+
+	user, ok := admin.GetUser(admin.GetAuthorization(r))
+	if !ok && allowed != nil {
+		user, ok = admin.getApikeyUser(r)
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if allowed != nil && !allowed(user) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// getApikeyUser returns a read-only user for the API key of the X-Api-Key
+// header if it has the admin-read permission.
+func (admin *Admin) getApikeyUser(r *http.Request) (*AdminUser, bool) {
+	key := r.Header.Get("X-Api-Key")
+	if len(key) == 0 {
+		return nil, false
+	}
+
+	apikey, err := admin.Controller.Apikeys.Authorize(admin.Controller.Database, key, ApikeyPermissionAdminRead, nil, GetRemoteAddr(r))
+	if apikey == nil {
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("admin: %v for ip %s", err, GetRemoteAddr(r)))
+		return nil, false
+	} else if err != nil {
+		admin.Controller.Logs.LogEvent(LogLevelError, err.Error())
+	}
+
+	return &AdminUser{Role: AdminRoleReadOnly, Username: fmt.Sprintf("apikey:%s", apikey.Ident), apikey: true}, true
+}
+
+// getConfigSnapshot returns the config as saved, normalized through json to
+// be compared with another snapshot.
+func (admin *Admin) getConfigSnapshot() map[string]any {
+	snapshot := map[string]any{}
+
+	config := admin.GetConfig()
+	delete(config, "downstreamsStatus")
+
+	if b, err := json.Marshal(config); err == nil {
+		json.Unmarshal(b, &snapshot)
+	}
+
+	// the last use of the api keys is not part of the configuration
+	switch apikeys := snapshot["apiKeys"].(type) {
+	case []any:
+		for _, f := range apikeys {
+			switch apikey := f.(type) {
+			case map[string]any:
+				delete(apikey, "lastUsed")
+				delete(apikey, "lastUsedIp")
+			}
+		}
+	}
+
+	return snapshot
+}
+
+func (admin *Admin) getSessionIdle() time.Duration {
+	return time.Duration(admin.Controller.Options.AdminSessionIdle) * time.Minute
+}
+
+func (admin *Admin) getSessionMaxAge() time.Duration {
+	maxAge := admin.Controller.Options.AdminSessionMaxAge
+	if maxAge == 0 {
+		maxAge = defaults.options.adminSessionMaxAge
+	}
+
+	return time.Duration(maxAge) * time.Hour
+}
+
+// saveConfigVersion records the config as a new version with what changed
+// since the before snapshot. The very first change also records the config as
+// it was, so that it can be rolled back to.
+// readAdminUsers reads the admin users again before they are used, so that a
+// change made by the command line while the server runs is never written over.
+func (admin *Admin) readAdminUsers() {
+	if err := admin.Controller.AdminUsers.Read(admin.Controller.Database); err != nil {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.readadminusers: %s", err.Error()))
+	}
+}
+
+func (admin *Admin) saveConfigVersion(before map[string]any, user *AdminUser, comment string) {
+	logError := func(err error) {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.saveconfigversion: %s", err.Error()))
+	}
+
+	db := admin.Controller.Database
+	versions := admin.Controller.ConfigVersions
+
+	after := admin.getConfigSnapshot()
+
+	diff := NewConfigDiff(before, after)
+	if len(diff) == 0 {
+		admin.Controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("configuration saved by %s without changes", user.Username))
+		return
+	}
+
+	if count, err := versions.Count(db); err != nil {
+		logError(err)
+	} else if count == 0 {
+		if err := versions.Add(db, &ConfigVersion{Comment: "configuration before the first recorded change", Config: before, DateTime: time.Now().UTC(), Diff: ConfigDiff{}}); err != nil {
+			logError(err)
+		}
 	}
 
-	return token.Valid
+	version := &ConfigVersion{
+		Comment:  comment,
+		Config:   after,
+		DateTime: time.Now().UTC(),
+		Diff:     diff,
+		Username: user.Username,
+	}
+
+	if err := versions.Add(db, version); err != nil {
+		logError(err)
+	}
+
+	message := fmt.Sprintf("configuration changed by %s, version %v with changes to %s", user.Username, version.Id, strings.Join(diff.getSections(), ", "))
+	if len(comment) > 0 {
+		message = fmt.Sprintf("%s, %s", message, comment)
+	}
+
+	admin.Controller.Logs.LogEvent(LogLevelWarn, message)
+}
+
+// sendTotpRequired tells the client to log in again with a code of the
+// authenticator or a recovery code.
+func (admin *Admin) sendTotpRequired(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"totpRequired":true}`))
 }
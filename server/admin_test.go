@@ -0,0 +1,307 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminApikeyReadOnly(t *testing.T) {
+	const (
+		adminReadKey  = "admin-read-secret"
+		accessCode    = "access-secret"
+		downstreamKey = "downstream-secret"
+		uploadKey     = "upload-secret"
+		uploadReadKey = "upload-read-secret"
+	)
+
+	controller := newTestController(t)
+	admin := controller.Admin
+	db := controller.Database
+
+	before := admin.getConfigSnapshot()
+
+	controller.Accesses.FromMap([]any{
+		map[string]any{"code": accessCode, "ident": "listener", "systems": "*"},
+	})
+	controller.Apikeys.FromMap([]any{
+		map[string]any{"adminRead": true, "ident": "dashboard", "key": adminReadKey, "systems": "*", "upload": false},
+		map[string]any{"ident": "recorder", "key": uploadKey, "systems": "*"},
+		map[string]any{"ident": "mirror", "key": uploadReadKey, "read": true, "systems": "*"},
+	})
+	controller.Downstreams.FromMap([]any{
+		map[string]any{"apiKey": downstreamKey, "systems": "*", "type": DownstreamTypeWebhook, "url": "http://127.0.0.1:1"},
+	})
+
+	for _, write := range []func(*Database) error{controller.Accesses.Write, controller.Apikeys.Write, controller.Downstreams.Write} {
+		if err := write(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, read := range []func(*Database) error{controller.Accesses.Read, controller.Apikeys.Read, controller.Downstreams.Read} {
+		if err := read(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin.saveConfigVersion(before, &AdminUser{Role: AdminRoleOwner, Username: "owner"}, "")
+
+	request := func(handler http.HandlerFunc, method string, target string, key string) (int, string) {
+		r := httptest.NewRequest(method, target, strings.NewReader("{}"))
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		b, _ := io.ReadAll(w.Result().Body)
+		return w.Code, string(b)
+	}
+
+	reads := []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{admin.ConfigHandler, "/api/admin/config"},
+		{admin.ConfigVersionsHandler, "/api/admin/config-versions"},
+		{admin.ConfigVersionsHandler, "/api/admin/config-versions?id=1&compare=current"},
+		{admin.ConfigVersionsHandler, "/api/admin/config-versions?id=2"},
+		{admin.ConfigVersionsHandler, "/api/admin/config-versions?id=1&compare=2"},
+	}
+
+	for _, read := range reads {
+		status, body := request(read.handler, http.MethodGet, read.target, adminReadKey)
+		if status != http.StatusOK {
+			t.Errorf("%s: got status %d", read.target, status)
+			continue
+		}
+
+		// the list of versions comes without their snapshots
+		if read.target != "/api/admin/config-versions" && !strings.Contains(body, "recorder") {
+			t.Errorf("%s: got no api keys in %s", read.target, body)
+		}
+
+		for _, secret := range []string{accessCode, adminReadKey, downstreamKey, uploadKey, uploadReadKey} {
+			if strings.Contains(body, secret) {
+				t.Errorf("%s: got secret %s", read.target, secret)
+			}
+		}
+	}
+
+	writes := []struct {
+		handler http.HandlerFunc
+		method  string
+		target  string
+		status  int
+	}{
+		{admin.AdminUserAddHandler, http.MethodPost, "/api/admin/admin-user-add", http.StatusForbidden},
+		{admin.AdminUserRemoveHandler, http.MethodPost, "/api/admin/admin-user-remove", http.StatusForbidden},
+		{admin.AdminUsersHandler, http.MethodGet, "/api/admin/admin-users", http.StatusForbidden},
+		{admin.CallKeepHandler, http.MethodPost, "/api/admin/call-keep", http.StatusForbidden},
+		{admin.ConfigHandler, http.MethodPut, "/api/admin/config", http.StatusForbidden},
+		{admin.ConfigRollbackHandler, http.MethodPost, "/api/admin/config-rollback", http.StatusForbidden},
+		{admin.UserAddHandler, http.MethodPost, "/api/admin/user-add", http.StatusForbidden},
+		{admin.UserRemoveHandler, http.MethodPost, "/api/admin/user-remove", http.StatusForbidden},
+		{admin.PasswordHandler, http.MethodPost, "/api/admin/password", http.StatusUnauthorized},
+		{admin.TotpSetupHandler, http.MethodPost, "/api/admin/totp-setup", http.StatusUnauthorized},
+	}
+
+	for _, write := range writes {
+		if status, _ := request(write.handler, write.method, write.target, adminReadKey); status != write.status {
+			t.Errorf("%s: got status %d, want %d", write.target, status, write.status)
+		}
+	}
+
+	if status, _ := request(admin.ConfigHandler, http.MethodGet, "/api/admin/config", uploadReadKey); status != http.StatusUnauthorized {
+		t.Errorf("got status %d for a key without the admin-read permission", status)
+	}
+}
+
+func TestAdminTotpResetFromCommandLine(t *testing.T) {
+	controller := newTestController(t)
+	admin := controller.Admin
+	db := controller.Database
+
+	admin.AttemptsMaxDelay = 0
+
+	secret, err := NewTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"owner", "jane"} {
+		user := &AdminUser{Role: AdminRoleOwner, Username: username}
+		if err := user.SetPassword(username + "-password"); err != nil {
+			t.Fatal(err)
+		}
+		if username == "jane" {
+			user.TotpEnabled = true
+			user.TotpSecret = secret
+		}
+		controller.AdminUsers.Add(user)
+	}
+	if err := controller.AdminUsers.Write(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.AdminUsers.Read(db); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(fmt.Sprintf(`{"username":%q,"password":%q}`, username, username+"-password")))
+		w := httptest.NewRecorder()
+		admin.LoginHandler(w, r)
+
+		m := map[string]any{}
+		json.NewDecoder(w.Result().Body).Decode(&m)
+		token, _ := m["token"].(string)
+		return w.Code, token
+	}
+
+	_, owner := login("owner")
+
+	if status, _ := login("jane"); status != http.StatusUnauthorized {
+		t.Fatalf("got status %d without a two-factor code", status)
+	}
+
+	// the reset as done by the command line, behind the running server
+	users := NewAdminUsers()
+	if err := users.Read(db); err != nil {
+		t.Fatal(err)
+	}
+	jane, _ := users.GetUser("jane")
+	jane.ResetTotp()
+	if err := users.Write(db); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/admin-user-add", strings.NewReader(`{"username":"john","role":"editor","password":"john-password"}`))
+	r.Header.Set("Authorization", owner)
+	w := httptest.NewRecorder()
+	admin.AdminUserAddHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d adding a user", w.Code)
+	}
+
+	var enabled bool
+	if err := db.Sql.QueryRow("select `totpEnabled` from `freeScannerAdminUsers` where `username` = ?", "jane").Scan(&enabled); err != nil || enabled {
+		t.Errorf("got the reset written over, %v", err)
+	}
+
+	if status, token := login("jane"); status != http.StatusOK || len(token) == 0 {
+		t.Errorf("got status %d after the reset", status)
+	}
+}
+
+func TestAdminUserAddRevokesSessions(t *testing.T) {
+	controller := newTestController(t)
+	admin := controller.Admin
+	db := controller.Database
+
+	admin.AttemptsMaxDelay = 0
+
+	for _, u := range []struct{ username, role string }{{"owner", AdminRoleOwner}, {"jane", AdminRoleEditor}} {
+		user := &AdminUser{Role: u.role, Username: u.username}
+		if err := user.SetPassword(u.username + "-password"); err != nil {
+			t.Fatal(err)
+		}
+		controller.AdminUsers.Add(user)
+	}
+	if err := controller.AdminUsers.Write(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.AdminUsers.Read(db); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username string) string {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(fmt.Sprintf(`{"username":%q,"password":%q}`, username, username+"-password")))
+		w := httptest.NewRecorder()
+		admin.LoginHandler(w, r)
+
+		m := map[string]any{}
+		json.NewDecoder(w.Result().Body).Decode(&m)
+		token, _ := m["token"].(string)
+		if len(token) == 0 {
+			t.Fatalf("got no token for %s, status %d", username, w.Code)
+		}
+		return token
+	}
+
+	save := func(token string, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/admin-user-add", strings.NewReader(body))
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		admin.AdminUserAddHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d saving %s", w.Code, body)
+		}
+	}
+
+	owner := login("owner")
+	jane := login("jane")
+
+	save(owner, `{"username":"jane","role":"editor"}`)
+	if !admin.ValidateToken(jane) {
+		t.Error("got the session revoked without a new password")
+	}
+
+	save(owner, `{"username":"owner","role":"owner","password":"owner-password"}`)
+	if !admin.ValidateToken(owner) {
+		t.Error("got the session of the caller revoked")
+	}
+
+	save(owner, `{"username":"jane","role":"editor","password":"jane-new-password"}`)
+	if admin.ValidateToken(jane) {
+		t.Error("got the session kept after a password reset")
+	}
+	if !admin.ValidateToken(owner) {
+		t.Error("got the session of the owner revoked")
+	}
+}
+
+// newTestController returns a controller on a temporary sqlite database, with
+// its config read but none of its services started.
+func newTestController(t *testing.T) *Controller {
+	t.Helper()
+
+	controller := NewController(&Config{AudioDir: "audio", BaseDir: t.TempDir(), DbFile: "test.db", DbType: DbTypeSqlite})
+	t.Cleanup(func() { controller.Database.Sql.Close() })
+
+	db := controller.Database
+
+	for _, read := range []func(*Database) error{
+		controller.Accesses.Read,
+		controller.Alerts.Read,
+		controller.Apikeys.Read,
+		controller.Downstreams.Read,
+		controller.Groups.Read,
+		controller.Options.Read,
+		controller.AdminUsers.Read,
+		controller.AdminSessions.Read,
+		controller.Systems.Read,
+		controller.Tags.Read,
+	} {
+		if err := read(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return controller
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	adminSessionsMax       = 5
+	adminSessionTouchDelay = time.Minute
+)
+
+// AdminSession is a login of an admin user, the token issued to it carrying
+// the session token id as its jwt id.
+type AdminSession struct {
+	Id           any       `json:"_id"`
+	DateCreated  time.Time `json:"dateCreated"`
+	DateExpires  time.Time `json:"dateExpires"`
+	DateLastSeen time.Time `json:"dateLastSeen"`
+	Ip           string    `json:"ip"`
+	TokenId      string    `json:"-"`
+	UserAgent    string    `json:"userAgent"`
+	Username     string    `json:"username"`
+	dateWritten  time.Time
+}
+
+// IsExpired tells if the session is past its expiration date or has been idle
+// for longer than the idle timeout, 0 for no idle timeout.
+func (session *AdminSession) IsExpired(idle time.Duration) bool {
+	now := time.Now().UTC()
+
+	if !now.Before(session.DateExpires) {
+		return true
+	}
+
+	return idle > 0 && now.Sub(session.DateLastSeen) > idle
+}
+
+type AdminSessions struct {
+	List  []*AdminSession
+	mutex sync.Mutex
+}
+
+func NewAdminSessions() *AdminSessions {
+	return &AdminSessions{
+		List:  []*AdminSession{},
+		mutex: sync.Mutex{},
+	}
+}
+
+// Add stores the new session and revokes the oldest sessions of the same admin
+// user over the limit.
+func (sessions *AdminSessions) Add(db *Database, session *AdminSession) error {
+	var (
+		err error
+		id  int64
+		res sql.Result
+	)
+
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("adminsessions.add: %v", err)
+	}
+
+	if len(session.UserAgent) > 255 {
+		session.UserAgent = session.UserAgent[:255]
+	}
+
+	session.dateWritten = session.DateLastSeen
+
+	if res, err = db.Sql.Exec("insert into `freeScannerAdminSessions` (`dateCreated`, `dateExpires`, `dateLastSeen`, `ip`, `tokenId`, `userAgent`, `username`) values (?, ?, ?, ?, ?, ?, ?)", session.DateCreated, session.DateExpires, session.DateLastSeen, session.Ip, session.TokenId, session.UserAgent, session.Username); err != nil {
+		return formatError(err)
+	}
+
+	if id, err = res.LastInsertId(); err == nil {
+		session.Id = uint(id)
+	}
+
+	sessions.List = append(sessions.List, session)
+
+	count := 0
+	for i := len(sessions.List) - 1; i >= 0; i-- {
+		if sessions.List[i].Username == session.Username {
+			if count++; count > adminSessionsMax {
+				if err = sessions.remove(db, sessions.List[i]); err != nil {
+					return formatError(err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (sessions *AdminSessions) GetSession(tokenId string) (*AdminSession, bool) {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	for _, session := range sessions.List {
+		if session.TokenId == tokenId {
+			return session, true
+		}
+	}
+
+	return nil, false
+}
+
+// GetSessions returns the sessions of an admin user, or of all of them if the
+// username is empty, the most recent first.
+func (sessions *AdminSessions) GetSessions(username string) []*AdminSession {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	list := []*AdminSession{}
+
+	for _, session := range sessions.List {
+		if len(username) == 0 || session.Username == username {
+			list = append(list, session)
+		}
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].DateCreated.After(list[j].DateCreated)
+	})
+
+	return list
+}
+
+// Prune removes the sessions that are expired or idle for too long.
+func (sessions *AdminSessions) Prune(db *Database, idle time.Duration) (int, error) {
+	return sessions.Remove(db, func(session *AdminSession) bool {
+		return session.IsExpired(idle)
+	})
+}
+
+func (sessions *AdminSessions) Read(db *Database) error {
+	var (
+		err  error
+		rows *sql.Rows
+	)
+
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	sessions.List = []*AdminSession{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("adminsessions.read: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id`, `dateCreated`, `dateExpires`, `dateLastSeen`, `ip`, `tokenId`, `userAgent`, `username` from `freeScannerAdminSessions` order by `_id`"); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var (
+			dateCreated  any
+			dateExpires  any
+			dateLastSeen any
+			id           uint
+			ip           sql.NullString
+			userAgent    sql.NullString
+		)
+
+		session := &AdminSession{}
+
+		if err = rows.Scan(&id, &dateCreated, &dateExpires, &dateLastSeen, &ip, &session.TokenId, &userAgent, &session.Username); err != nil {
+			break
+		}
+
+		session.Id = id
+		session.Ip = ip.String
+		session.UserAgent = userAgent.String
+
+		if t, err := db.ParseDateTime(dateCreated); err == nil {
+			session.DateCreated = t
+		}
+
+		if t, err := db.ParseDateTime(dateExpires); err == nil {
+			session.DateExpires = t
+		}
+
+		if t, err := db.ParseDateTime(dateLastSeen); err == nil {
+			session.DateLastSeen = t
+			session.dateWritten = t
+		}
+
+		sessions.List = append(sessions.List, session)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+// Remove revokes the matching sessions and returns how many were removed.
+func (sessions *AdminSessions) Remove(db *Database, match func(*AdminSession) bool) (int, error) {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	count := 0
+
+	for _, session := range append([]*AdminSession{}, sessions.List...) {
+		if match(session) {
+			if err := sessions.remove(db, session); err != nil {
+				return count, fmt.Errorf("adminsessions.remove: %v", err)
+			}
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Touch marks the session as seen now, the database being updated at most
+// once a minute.
+func (sessions *AdminSessions) Touch(db *Database, session *AdminSession) error {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	session.DateLastSeen = time.Now().UTC()
+
+	if session.DateLastSeen.Sub(session.dateWritten) < adminSessionTouchDelay {
+		return nil
+	}
+
+	session.dateWritten = session.DateLastSeen
+
+	if _, err := db.Sql.Exec("update `freeScannerAdminSessions` set `dateLastSeen` = ? where `_id` = ?", session.DateLastSeen, session.Id); err != nil {
+		return fmt.Errorf("adminsessions.touch: %v", err)
+	}
+
+	return nil
+}
+
+func (sessions *AdminSessions) remove(db *Database, session *AdminSession) error {
+	if _, err := db.Sql.Exec("delete from `freeScannerAdminSessions` where `_id` = ?", session.Id); err != nil {
+		return err
+	}
+
+	for i, s := range sessions.List {
+		if s == session {
+			sessions.List = append(sessions.List[:i], sessions.List[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
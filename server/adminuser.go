@@ -0,0 +1,398 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AdminRoleEditor    = "editor"
+	AdminRoleLogViewer = "log-viewer"
+	AdminRoleOwner     = "owner"
+	AdminRoleReadOnly  = "read-only"
+)
+
+// AdminUser is an account of the administrative dashboard. Owners manage the
+// admin users, editors change the configuration, read-only users only see it
+// and log viewers only see the logs. The password and the recovery codes are
+// bcrypt hashes.
+type AdminUser struct {
+	Id                 any      `json:"_id"`
+	Password           string   `json:"-"`
+	PasswordNeedChange bool     `json:"passwordNeedChange"`
+	RecoveryCodes      []string `json:"-"`
+	Role               string   `json:"role"`
+	TotpEnabled        bool     `json:"totpEnabled"`
+	TotpSecret         string   `json:"-"`
+	Username           string   `json:"username"`
+	apikey             bool
+	totpStep           int64
+}
+
+func (user *AdminUser) CanEditConfig() bool {
+	return user.Role == AdminRoleOwner || user.Role == AdminRoleEditor
+}
+
+func (user *AdminUser) CanManageUsers() bool {
+	return user.Role == AdminRoleOwner
+}
+
+func (user *AdminUser) CanReadConfig() bool {
+	return user.CanEditConfig() || user.Role == AdminRoleReadOnly
+}
+
+func (user *AdminUser) CanReadLogs() bool {
+	return user.CanReadConfig() || user.Role == AdminRoleLogViewer
+}
+
+func (user *AdminUser) CheckPassword(password string) bool {
+	if len(password) == 0 || len(user.Password) == 0 {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// CheckTotp validates a code of the authenticator, which can't be used twice,
+// or one of the recovery codes, which is then consumed.
+func (user *AdminUser) CheckTotp(code string) (recovery bool, ok bool) {
+	if !user.TotpEnabled {
+		return false, false
+	}
+
+	if step, ok := ValidateTotpCode(user.TotpSecret, code, time.Now()); ok {
+		if step <= user.totpStep {
+			return false, false
+		}
+		user.totpStep = step
+		return false, true
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	for i, hash := range user.RecoveryCodes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true, true
+		}
+	}
+
+	return false, false
+}
+
+// ResetTotp disables the two-factor authentication.
+func (user *AdminUser) ResetTotp() {
+	user.RecoveryCodes = []string{}
+	user.TotpEnabled = false
+	user.TotpSecret = ""
+	user.totpStep = 0
+}
+
+func (user *AdminUser) SetPassword(password string) error {
+	if len(password) == 0 {
+		return errors.New("password is empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user.Password = string(hash)
+	user.PasswordNeedChange = password == defaults.adminPassword
+
+	return nil
+}
+
+// SetRecoveryCodes replaces the recovery codes with the hashes of the given
+// ones.
+func (user *AdminUser) SetRecoveryCodes(codes []string) error {
+	hashes := []string{}
+
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, string(hash))
+	}
+
+	user.RecoveryCodes = hashes
+
+	return nil
+}
+
+func (user *AdminUser) Validate() error {
+	if len(user.Username) == 0 {
+		return errors.New("username is empty")
+	}
+
+	switch user.Role {
+	case AdminRoleEditor, AdminRoleLogViewer, AdminRoleOwner, AdminRoleReadOnly:
+		return nil
+	default:
+		return fmt.Errorf("invalid role %s", user.Role)
+	}
+}
+
+type AdminUsers struct {
+	List  []*AdminUser
+	mutex sync.Mutex
+}
+
+func NewAdminUsers() *AdminUsers {
+	return &AdminUsers{
+		List:  []*AdminUser{},
+		mutex: sync.Mutex{},
+	}
+}
+
+// Add adds the user or replaces the one with the same username.
+func (users *AdminUsers) Add(user *AdminUser) {
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	for i, u := range users.List {
+		if strings.EqualFold(u.Username, user.Username) {
+			user.Id = u.Id
+			users.List[i] = user
+			return
+		}
+	}
+
+	users.List = append(users.List, user)
+}
+
+// CountOwners returns how many owners are left without the excluded username.
+func (users *AdminUsers) CountOwners(exclude string) int {
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	count := 0
+	for _, user := range users.List {
+		if user.Role == AdminRoleOwner && !strings.EqualFold(user.Username, exclude) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (users *AdminUsers) GetUser(username string) (*AdminUser, bool) {
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	for _, user := range users.List {
+		if strings.EqualFold(user.Username, username) {
+			return user, true
+		}
+	}
+
+	return nil, false
+}
+
+// Read reads the admin users, the ones already read being updated in place so
+// that the handlers holding them get the changes made by the command line.
+func (users *AdminUsers) Read(db *Database) error {
+	var (
+		err           error
+		id            sql.NullFloat64
+		recoveryCodes sql.NullString
+		rows          *sql.Rows
+		totpSecret    sql.NullString
+	)
+
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	previous := map[string]*AdminUser{}
+	for _, user := range users.List {
+		previous[user.Username] = user
+	}
+
+	users.List = []*AdminUser{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("adminusers.read: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id`, `password`, `passwordNeedChange`, `recoveryCodes`, `role`, `totpEnabled`, `totpSecret`, `username` from `freeScannerAdminUsers` order by `username`"); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		user := &AdminUser{}
+
+		if err = rows.Scan(&id, &user.Password, &user.PasswordNeedChange, &recoveryCodes, &user.Role, &user.TotpEnabled, &totpSecret, &user.Username); err != nil {
+			break
+		}
+
+		if id.Valid && id.Float64 > 0 {
+			user.Id = uint(id.Float64)
+		}
+
+		if err = json.Unmarshal([]byte(recoveryCodes.String), &user.RecoveryCodes); err != nil {
+			user.RecoveryCodes = []string{}
+			err = nil
+		}
+
+		user.TotpSecret = totpSecret.String
+
+		// the users are updated in place for the handlers holding them
+		if current, ok := previous[user.Username]; ok {
+			user.totpStep = current.totpStep
+			*current = *user
+			user = current
+		}
+
+		users.List = append(users.List, user)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (users *AdminUsers) Remove(username string) (*AdminUser, bool) {
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	for i, user := range users.List {
+		if strings.EqualFold(user.Username, username) {
+			users.List = append(users.List[:i], users.List[i+1:]...)
+			return user, true
+		}
+	}
+
+	return nil, false
+}
+
+// Seed creates the owner account from the former single admin password when
+// there is no admin user yet.
+func (users *AdminUsers) Seed(db *Database, options *Options) error {
+	users.mutex.Lock()
+	empty := len(users.List) == 0
+	users.mutex.Unlock()
+
+	if !empty {
+		return nil
+	}
+
+	users.Add(&AdminUser{
+		Password:           options.adminPassword,
+		PasswordNeedChange: options.adminPasswordNeedChange,
+		Role:               AdminRoleOwner,
+		Username:           defaults.adminUsername,
+	})
+
+	if err := users.Write(db); err != nil {
+		return err
+	}
+
+	return users.Read(db)
+}
+
+func (users *AdminUsers) Write(db *Database) error {
+	var (
+		err    error
+		rows   *sql.Rows
+		rowIds = []uint{}
+	)
+
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("adminusers.write: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id` from `freeScannerAdminUsers`"); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var id uint
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		remove := true
+		for _, user := range users.List {
+			if user.Id == nil || user.Id == id {
+				remove = false
+				break
+			}
+		}
+		if remove {
+			rowIds = append(rowIds, id)
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	if len(rowIds) > 0 {
+		q, args := NewDeleteQuery("freeScannerAdminUsers").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
+		}
+	}
+
+	for _, user := range users.List {
+		var (
+			count         uint
+			recoveryCodes []byte
+		)
+
+		if recoveryCodes, err = json.Marshal(user.RecoveryCodes); err != nil {
+			break
+		}
+
+		if err = db.Sql.QueryRow("select count(*) from `freeScannerAdminUsers` where `_id` = ?", user.Id).Scan(&count); err != nil {
+			break
+		}
+
+		if count == 0 {
+			if _, err = db.Sql.Exec("insert into `freeScannerAdminUsers` (`_id`, `password`, `passwordNeedChange`, `recoveryCodes`, `role`, `totpEnabled`, `totpSecret`, `username`) values (?, ?, ?, ?, ?, ?, ?, ?)", user.Id, user.Password, user.PasswordNeedChange, string(recoveryCodes), user.Role, user.TotpEnabled, user.TotpSecret, user.Username); err != nil {
+				break
+			}
+
+		} else if _, err = db.Sql.Exec("update `freeScannerAdminUsers` set `_id` = ?, `password` = ?, `passwordNeedChange` = ?, `recoveryCodes` = ?, `role` = ?, `totpEnabled` = ?, `totpSecret` = ?, `username` = ? where `_id` = ?", user.Id, user.Password, user.PasswordNeedChange, string(recoveryCodes), user.Role, user.TotpEnabled, user.TotpSecret, user.Username, user.Id); err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
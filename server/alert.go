@@ -0,0 +1,590 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Alert struct {
+	Id        any    `json:"_id"`
+	Disabled  bool   `json:"disabled"`
+	Emergency bool   `json:"emergency"`
+	Groups    any    `json:"groups"`
+	Keywords  string `json:"keywords"`
+	Label     string `json:"label"`
+	Order     any    `json:"order"`
+	Sink      string `json:"sink"`
+	Systems   any    `json:"systems"`
+	Tags      any    `json:"tags"`
+	Target    string `json:"target"`
+	TimeFrom  string `json:"timeFrom"`
+	TimeTo    string `json:"timeTo"`
+	Units     string `json:"units"`
+}
+
+func (alert *Alert) FromMap(m map[string]any) *Alert {
+	switch v := m["_id"].(type) {
+	case float64:
+		alert.Id = uint(v)
+	}
+
+	switch v := m["disabled"].(type) {
+	case bool:
+		alert.Disabled = v
+	}
+
+	switch v := m["emergency"].(type) {
+	case bool:
+		alert.Emergency = v
+	}
+
+	switch v := m["groups"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			alert.Groups = string(b)
+		}
+	case string:
+		alert.Groups = v
+	}
+
+	switch v := m["keywords"].(type) {
+	case string:
+		alert.Keywords = v
+	}
+
+	switch v := m["label"].(type) {
+	case string:
+		alert.Label = v
+	}
+
+	switch v := m["order"].(type) {
+	case float64:
+		alert.Order = uint(v)
+	}
+
+	switch v := m["sink"].(type) {
+	case string:
+		alert.Sink = v
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			alert.Systems = string(b)
+		}
+	case string:
+		alert.Systems = v
+	}
+
+	switch v := m["tags"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			alert.Tags = string(b)
+		}
+	case string:
+		alert.Tags = v
+	}
+
+	switch v := m["target"].(type) {
+	case string:
+		alert.Target = v
+	}
+
+	switch v := m["timeFrom"].(type) {
+	case string:
+		alert.TimeFrom = v
+	}
+
+	switch v := m["timeTo"].(type) {
+	case string:
+		alert.TimeTo = v
+	}
+
+	switch v := m["units"].(type) {
+	case string:
+		alert.Units = v
+	}
+
+	return alert
+}
+
+// GetKeywords returns the comma separated keywords, normalized as the
+// transcripts are when matched.
+func (alert *Alert) GetKeywords() []string {
+	keywords := []string{}
+
+	for _, keyword := range strings.Split(alert.Keywords, ",") {
+		if keyword = normalizeAlertText(keyword); len(keyword) > 0 {
+			keywords = append(keywords, keyword)
+		}
+	}
+
+	return keywords
+}
+
+// Matches tells if the call fulfills every criteria of the alert, along with
+// the keywords found in the transcript.
+func (alert *Alert) Matches(call *Call, talkgroup *Talkgroup) (bool, []string) {
+	matched := []string{}
+
+	if alert.Disabled {
+		return false, matched
+	}
+
+	if alert.Emergency && !call.emergency {
+		return false, matched
+	}
+
+	if !alert.matchesSystems(call) {
+		return false, matched
+	}
+
+	if !matchesAlertIds(alert.Groups, talkgroup.GroupId) || !matchesAlertIds(alert.Tags, talkgroup.TagId) {
+		return false, matched
+	}
+
+	if !alert.matchesUnits(call) || !alert.matchesTime(call.DateTime) {
+		return false, matched
+	}
+
+	if keywords := alert.GetKeywords(); len(keywords) > 0 {
+		var transcript string
+
+		switch v := call.Transcript.(type) {
+		case string:
+			transcript = " " + normalizeAlertText(v) + " "
+		}
+
+		for _, keyword := range keywords {
+			if strings.Contains(transcript, " "+keyword+" ") {
+				matched = append(matched, keyword)
+			}
+		}
+
+		if len(matched) == 0 {
+			return false, matched
+		}
+	}
+
+	return true, matched
+}
+
+func (alert *Alert) matchesSystems(call *Call) bool {
+	switch v := alert.Systems.(type) {
+	case []any:
+		for _, f := range v {
+			switch v := f.(type) {
+			case map[string]any:
+				switch id := v["id"].(type) {
+				case float64:
+					if id == float64(call.System) {
+						switch tg := v["talkgroups"].(type) {
+						case string:
+							if tg == "*" {
+								return true
+							}
+						case []any:
+							for _, f := range tg {
+								switch tg := f.(type) {
+								case float64:
+									if tg == float64(call.Talkgroup) {
+										return true
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+
+	case string:
+		if v == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesTime tells if the call time of day falls in the alert window, which
+// may span midnight. No window means any time.
+func (alert *Alert) matchesTime(t time.Time) bool {
+	from, okFrom := parseAlertTime(alert.TimeFrom)
+	to, okTo := parseAlertTime(alert.TimeTo)
+
+	if !okFrom || !okTo || from == to {
+		return true
+	}
+
+	t = t.Local()
+	minutes := t.Hour()*60 + t.Minute()
+
+	if from < to {
+		return minutes >= from && minutes < to
+	}
+
+	return minutes >= from || minutes < to
+}
+
+func (alert *Alert) matchesUnits(call *Call) bool {
+	units := []uint{}
+
+	for _, s := range strings.Split(alert.Units, ",") {
+		if i, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64); err == nil {
+			units = append(units, uint(i))
+		}
+	}
+
+	if len(units) == 0 {
+		return true
+	}
+
+	sources := []any{call.Source}
+
+	switch v := call.Sources.(type) {
+	case []map[string]any:
+		for _, source := range v {
+			sources = append(sources, source["src"])
+		}
+	}
+
+	for _, source := range sources {
+		if id, ok := toUint(source); ok {
+			for _, unit := range units {
+				if unit == id {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+type Alerts struct {
+	List  []*Alert
+	mutex sync.Mutex
+}
+
+func NewAlerts() *Alerts {
+	return &Alerts{
+		List:  []*Alert{},
+		mutex: sync.Mutex{},
+	}
+}
+
+// Evaluate fires the alerts matching a newly ingested call. Alerts on
+// keywords wait for the transcript when the call has none yet.
+func (alerts *Alerts) Evaluate(controller *Controller, call *Call) {
+	alerts.evaluate(controller, call, false)
+}
+
+// EvaluateTranscript fires the keyword alerts once the call is transcribed.
+func (alerts *Alerts) EvaluateTranscript(controller *Controller, call *Call) {
+	alerts.evaluate(controller, call, true)
+}
+
+func (alerts *Alerts) FromMap(f []any) *Alerts {
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	alerts.List = []*Alert{}
+
+	for _, r := range f {
+		switch m := r.(type) {
+		case map[string]any:
+			alert := &Alert{}
+			alert.FromMap(m)
+			alerts.List = append(alerts.List, alert)
+		}
+	}
+
+	return alerts
+}
+
+func (alerts *Alerts) Read(db *Database) error {
+	var (
+		err      error
+		groups   string
+		id       sql.NullFloat64
+		order    sql.NullFloat64
+		rows     *sql.Rows
+		systems  string
+		tags     string
+		timeFrom sql.NullString
+		timeTo   sql.NullString
+	)
+
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	alerts.List = []*Alert{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("alerts.read: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id`, `disabled`, `emergency`, `groups`, `keywords`, `label`, `order`, `sink`, `systems`, `tags`, `target`, `timeFrom`, `timeTo`, `units` from `freeScannerAlerts`"); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		alert := &Alert{}
+
+		if err = rows.Scan(&id, &alert.Disabled, &alert.Emergency, &groups, &alert.Keywords, &alert.Label, &order, &alert.Sink, &systems, &tags, &alert.Target, &timeFrom, &timeTo, &alert.Units); err != nil {
+			break
+		}
+
+		if id.Valid && id.Float64 > 0 {
+			alert.Id = uint(id.Float64)
+		}
+
+		if err = json.Unmarshal([]byte(groups), &alert.Groups); err != nil {
+			alert.Groups = []any{}
+		}
+
+		if order.Valid && order.Float64 > 0 {
+			alert.Order = uint(order.Float64)
+		}
+
+		if err = json.Unmarshal([]byte(systems), &alert.Systems); err != nil {
+			alert.Systems = []any{}
+		}
+
+		if err = json.Unmarshal([]byte(tags), &alert.Tags); err != nil {
+			alert.Tags = []any{}
+		}
+
+		err = nil
+
+		if timeFrom.Valid {
+			alert.TimeFrom = timeFrom.String
+		}
+
+		if timeTo.Valid {
+			alert.TimeTo = timeTo.String
+		}
+
+		alerts.List = append(alerts.List, alert)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (alerts *Alerts) Write(db *Database) error {
+	var (
+		count   uint
+		err     error
+		groups  any
+		rows    *sql.Rows
+		rowIds  = []uint{}
+		systems any
+		tags    any
+	)
+
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("alerts.write: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id` from `freeScannerAlerts`"); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var rowId uint
+		if err = rows.Scan(&rowId); err != nil {
+			break
+		}
+		remove := true
+		for _, alert := range alerts.List {
+			if alert.Id == nil || alert.Id == rowId {
+				remove = false
+				break
+			}
+		}
+		if remove {
+			rowIds = append(rowIds, rowId)
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	if len(rowIds) > 0 {
+		q, args := NewDeleteQuery("freeScannerAlerts").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
+		}
+	}
+
+	for _, alert := range alerts.List {
+		switch alert.Systems {
+		case "*":
+			systems = `"*"`
+		default:
+			systems = alert.Systems
+		}
+
+		if groups = alert.Groups; groups == nil {
+			groups = "[]"
+		}
+
+		if tags = alert.Tags; tags == nil {
+			tags = "[]"
+		}
+
+		if err = db.Sql.QueryRow("select count(*) from `freeScannerAlerts` where `_id` = ?", alert.Id).Scan(&count); err != nil {
+			break
+		}
+
+		if count == 0 {
+			if _, err = db.Sql.Exec("insert into `freeScannerAlerts` (`_id`, `disabled`, `emergency`, `groups`, `keywords`, `label`, `order`, `sink`, `systems`, `tags`, `target`, `timeFrom`, `timeTo`, `units`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", alert.Id, alert.Disabled, alert.Emergency, groups, alert.Keywords, alert.Label, alert.Order, alert.Sink, systems, tags, alert.Target, alert.TimeFrom, alert.TimeTo, alert.Units); err != nil {
+				break
+			}
+
+		} else if _, err = db.Sql.Exec("update `freeScannerAlerts` set `_id` = ?, `disabled` = ?, `emergency` = ?, `groups` = ?, `keywords` = ?, `label` = ?, `order` = ?, `sink` = ?, `systems` = ?, `tags` = ?, `target` = ?, `timeFrom` = ?, `timeTo` = ?, `units` = ? where `_id` = ?", alert.Id, alert.Disabled, alert.Emergency, groups, alert.Keywords, alert.Label, alert.Order, alert.Sink, systems, tags, alert.Target, alert.TimeFrom, alert.TimeTo, alert.Units, alert.Id); err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (alerts *Alerts) evaluate(controller *Controller, call *Call, transcribed bool) {
+	var (
+		hasTranscript bool
+		talkgroup     *Talkgroup
+	)
+
+	switch v := call.Transcript.(type) {
+	case string:
+		hasTranscript = len(v) > 0
+	}
+
+	if transcribed && !hasTranscript {
+		return
+	}
+
+	if system, ok := controller.Systems.GetSystem(call.System); ok {
+		if talkgroup, ok = system.Talkgroups.GetTalkgroup(call.Talkgroup); !ok {
+			return
+		}
+	} else {
+		return
+	}
+
+	alerts.mutex.Lock()
+	list := make([]*Alert, len(alerts.List))
+	copy(list, alerts.List)
+	alerts.mutex.Unlock()
+
+	for _, alert := range list {
+		hasKeywords := len(alert.GetKeywords()) > 0
+
+		// alerts on keywords are only evaluated once a transcript exists, and
+		// the others were already evaluated at ingest
+		if transcribed && !hasKeywords {
+			continue
+		} else if !transcribed && hasKeywords && !hasTranscript {
+			continue
+		}
+
+		ok, keywords := alert.Matches(call, talkgroup)
+		if !ok {
+			continue
+		}
+
+		go func(alert *Alert, keywords []string) {
+			logEvent := func(logLevel string, message string) {
+				controller.Logs.LogEvent(logLevel, fmt.Sprintf("alert: %s system=%v talkgroup=%v via %s %s", alert.Label, call.System, call.Talkgroup, alert.Sink, message))
+			}
+
+			sink, err := NewAlertSink(alert.Sink, controller.Config)
+			if err == nil {
+				err = sink.Notify(alert, call, keywords)
+			}
+
+			controller.Metrics.AlertSent(alert, err)
+
+			if err == nil {
+				logEvent(LogLevelInfo, "success")
+			} else {
+				logEvent(LogLevelError, err.Error())
+			}
+		}(alert, keywords)
+	}
+}
+
+// matchesAlertIds tells if id is in the json list of ids, an empty list
+// matching any id.
+func matchesAlertIds(f any, id uint) bool {
+	switch v := f.(type) {
+	case []any:
+		if len(v) == 0 {
+			return true
+		}
+		for _, f := range v {
+			switch f := f.(type) {
+			case float64:
+				if f == float64(id) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// normalizeAlertText lowercases the words of s and joins them with single
+// spaces, so that keywords match regardless of punctuation.
+func normalizeAlertText(s string) string {
+	return strings.Join(searchWordRegexp.FindAllString(strings.ToLower(s), -1), " ")
+}
+
+// parseAlertTime returns the minutes since midnight of a hh:mm time.
+func parseAlertTime(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
@@ -0,0 +1,427 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertMatches(t *testing.T) {
+	newCall := func() *Call {
+		return &Call{
+			DateTime:   time.Date(2022, 1, 1, 12, 30, 0, 0, time.Local),
+			Source:     uint(1001),
+			System:     1,
+			Talkgroup:  100,
+			Transcript: "Structure fire, engine 5 respond!",
+		}
+	}
+
+	talkgroup := &Talkgroup{GroupId: 2, TagId: 3}
+
+	tests := []struct {
+		name     string
+		alert    *Alert
+		call     func(call *Call)
+		matches  bool
+		keywords []string
+	}{
+		{
+			name:    "all systems",
+			alert:   &Alert{Systems: "*"},
+			matches: true,
+		},
+		{
+			name:    "talkgroup listed",
+			alert:   &Alert{Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(100)}}}},
+			matches: true,
+		},
+		{
+			name:  "talkgroup not listed",
+			alert: &Alert{Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(101)}}}},
+		},
+		{
+			name:    "every talkgroup of the system",
+			alert:   &Alert{Systems: []any{map[string]any{"id": float64(1), "talkgroups": "*"}}},
+			matches: true,
+		},
+		{
+			name:  "other system",
+			alert: &Alert{Systems: []any{map[string]any{"id": float64(2), "talkgroups": "*"}}},
+		},
+		{
+			name:  "disabled",
+			alert: &Alert{Disabled: true, Systems: "*"},
+		},
+		{
+			name:  "emergency only",
+			alert: &Alert{Emergency: true, Systems: "*"},
+		},
+		{
+			name:    "emergency call",
+			alert:   &Alert{Emergency: true, Systems: "*"},
+			call:    func(call *Call) { call.emergency = true },
+			matches: true,
+		},
+		{
+			name:    "group and tag",
+			alert:   &Alert{Groups: []any{float64(2)}, Systems: "*", Tags: []any{float64(3)}},
+			matches: true,
+		},
+		{
+			name:  "other group",
+			alert: &Alert{Groups: []any{float64(4)}, Systems: "*"},
+		},
+		{
+			name:    "unit as source",
+			alert:   &Alert{Systems: "*", Units: "1000, 1001"},
+			matches: true,
+		},
+		{
+			name:    "unit in sources",
+			alert:   &Alert{Systems: "*", Units: "1002"},
+			call:    func(call *Call) { call.Sources = []map[string]any{{"src": uint(1002)}} },
+			matches: true,
+		},
+		{
+			name:  "other unit",
+			alert: &Alert{Systems: "*", Units: "1003"},
+		},
+		{
+			name:    "inside time window",
+			alert:   &Alert{Systems: "*", TimeFrom: "12:00", TimeTo: "13:00"},
+			matches: true,
+		},
+		{
+			name:  "outside time window",
+			alert: &Alert{Systems: "*", TimeFrom: "13:00", TimeTo: "14:00"},
+		},
+		{
+			name:    "time window over midnight",
+			alert:   &Alert{Systems: "*", TimeFrom: "22:00", TimeTo: "02:00"},
+			call:    func(call *Call) { call.DateTime = time.Date(2022, 1, 1, 23, 0, 0, 0, time.Local) },
+			matches: true,
+		},
+		{
+			name:     "keywords",
+			alert:    &Alert{Keywords: "Fire, ENGINE 5, ambulance", Systems: "*"},
+			matches:  true,
+			keywords: []string{"fire", "engine 5"},
+		},
+		{
+			name:  "keywords are whole words",
+			alert: &Alert{Keywords: "fir, engine", Systems: "*"},
+			call:  func(call *Call) { call.Transcript = "firefighters, engines" },
+		},
+		{
+			name:  "keywords without transcript",
+			alert: &Alert{Keywords: "fire", Systems: "*"},
+			call:  func(call *Call) { call.Transcript = nil },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			call := newCall()
+			if test.call != nil {
+				test.call(call)
+			}
+
+			matches, keywords := test.alert.Matches(call, talkgroup)
+			if matches != test.matches {
+				t.Errorf("got matches %v, want %v", matches, test.matches)
+			}
+
+			if test.keywords == nil {
+				test.keywords = []string{}
+			}
+			if matches && !reflect.DeepEqual(keywords, test.keywords) {
+				t.Errorf("got keywords %v, want %v", keywords, test.keywords)
+			}
+		})
+	}
+}
+
+func TestEmailAlertSink(t *testing.T) {
+	host, port, messages := newTestSmtpServer(t)
+
+	config := &Config{SmtpFrom: "scanner@example.com", SmtpHost: host, SmtpPort: port}
+
+	alert := &Alert{Label: "Feu à Montréal", Target: " jane@example.com, ,john@example.com "}
+	call := &Call{
+		DateTime:       time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+		Source:         uint(1001),
+		System:         1,
+		Talkgroup:      100,
+		Transcript:     "structure fire, engine 5 respond",
+		emergency:      true,
+		systemLabel:    "Metro",
+		talkgroupLabel: "Dispatch",
+		talkgroupName:  "Fire Dispatch",
+	}
+
+	if err := (&EmailAlertSink{config: config}).Notify(alert, call, []string{"fire", "engine 5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var message testSmtpMessage
+
+	select {
+	case message = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no message")
+	}
+
+	if message.from != "scanner@example.com" {
+		t.Errorf("got sender %q", message.from)
+	}
+
+	if !reflect.DeepEqual(message.to, []string{"jane@example.com", "john@example.com"}) {
+		t.Errorf("got recipients %v", message.to)
+	}
+
+	headers, body, _ := strings.Cut(message.data, "\r\n\r\n")
+
+	subject := ""
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Subject: ") {
+			subject = strings.TrimPrefix(line, "Subject: ")
+		}
+	}
+
+	if !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Errorf("got subject %q not q-encoded", subject)
+	}
+
+	if s, err := new(mime.WordDecoder).DecodeHeader(subject); err != nil || s != "Feu à Montréal: Metro Dispatch (Fire Dispatch)" {
+		t.Errorf("got subject %q, %v", s, err)
+	}
+
+	for _, line := range []string{"Unit: 1001", "Emergency: yes", "Keywords: fire, engine 5", "structure fire, engine 5 respond"} {
+		if !strings.Contains(body, line+"\r\n") {
+			t.Errorf("got no %q in body %q", line, body)
+		}
+	}
+
+	if err := (&EmailAlertSink{config: &Config{SmtpFrom: "scanner@example.com"}}).Notify(alert, call, []string{}); err == nil {
+		t.Error("got no error without smtp host")
+	}
+
+	if err := (&EmailAlertSink{config: config}).Notify(&Alert{Target: " , "}, call, []string{}); err == nil {
+		t.Error("got no error without recipient")
+	}
+}
+
+func TestGetAlertExecPath(t *testing.T) {
+	dir := t.TempDir()
+
+	execDir := filepath.Join(dir, "alerts")
+	if err := os.Mkdir(execDir, 0770); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{filepath.Join(execDir, "notify"), filepath.Join(dir, "outside")} {
+		if err := os.WriteFile(p, []byte("#!/bin/sh\n"), 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(execDir, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	config := &Config{AlertExecDir: "alerts", BaseDir: dir}
+
+	tests := []struct {
+		target string
+		ok     bool
+	}{
+		{target: "notify", ok: true},
+		{target: filepath.Join(execDir, "notify"), ok: true},
+		{target: ""},
+		{target: "missing"},
+		{target: "../outside"},
+		{target: filepath.Join(dir, "outside")},
+		{target: "link"},
+	}
+
+	for _, test := range tests {
+		p, err := getAlertExecPath(config, test.target)
+		if test.ok && (err != nil || filepath.Base(p) != "notify") {
+			t.Errorf("target %q: got %q, %v", test.target, p, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("target %q: got %q, want an error", test.target, p)
+		}
+	}
+
+	if _, err := getAlertExecPath(&Config{BaseDir: dir}, "notify"); err == nil {
+		t.Error("exec alerts run without an alert exec directory")
+	}
+}
+
+func TestWebhookAlertSink(t *testing.T) {
+	var (
+		body        map[string]any
+		contentType string
+		method      string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	alert := &Alert{Label: "Fire", Target: server.URL}
+	call := &Call{Id: uint(7), DateTime: time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC), System: 1, Talkgroup: 100, Transcript: "structure fire"}
+
+	if err := (&WebhookAlertSink{}).Notify(alert, call, []string{"fire"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != http.MethodPost || contentType != "application/json" {
+		t.Errorf("got %s with %s", method, contentType)
+	}
+
+	if body["alert"] != "Fire" || !reflect.DeepEqual(body["keywords"], []any{"fire"}) {
+		t.Errorf("got body %v", body)
+	}
+
+	switch c := body["call"].(type) {
+	case map[string]any:
+		if c["id"] != float64(7) || c["system"] != float64(1) || c["talkgroup"] != float64(100) || c["transcript"] != "structure fire" || c["dateTime"] != "2022-01-01T12:30:00Z" {
+			t.Errorf("got call %v", c)
+		}
+	default:
+		t.Errorf("got no call in %v", body)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := (&WebhookAlertSink{}).Notify(&Alert{Target: failing.URL}, call, []string{}); err == nil {
+		t.Error("got no error on a bad status")
+	}
+}
+
+type testSmtpMessage struct {
+	data string
+	from string
+	to   []string
+}
+
+// newTestSmtpServer starts a minimal smtp server, without any extension, that
+// hands over the messages it receives.
+func newTestSmtpServer(t *testing.T) (string, uint, chan testSmtpMessage) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan testSmtpMessage, 8)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		message := testSmtpMessage{}
+		reader := bufio.NewReader(conn)
+
+		reply := func(s string) {
+			conn.Write([]byte(s + "\r\n"))
+		}
+
+		reply("220 localhost ready")
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+
+			switch command := strings.ToUpper(line); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				message.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+				reply("250 ok")
+
+			case strings.HasPrefix(command, "RCPT TO:"):
+				message.to = append(message.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+				reply("250 ok")
+
+			case command == "DATA":
+				reply("354 go ahead")
+
+				data := strings.Builder{}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				message.data = data.String()
+
+				messages <- message
+				message = testSmtpMessage{}
+				reply("250 ok")
+
+			case command == "QUIT":
+				reply("221 bye")
+				return
+
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	return addr.IP.String(), uint(addr.Port), messages
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlertSinkEmail   = "email"
+	AlertSinkExec    = "exec"
+	AlertSinkWebhook = "webhook"
+)
+
+// AlertSink delivers the notification of a matched alert.
+type AlertSink interface {
+	Notify(alert *Alert, call *Call, keywords []string) error
+}
+
+func NewAlertSink(sink string, config *Config) (AlertSink, error) {
+	switch sink {
+	case AlertSinkEmail:
+		return &EmailAlertSink{config: config}, nil
+	case AlertSinkExec:
+		return &ExecAlertSink{config: config}, nil
+	case AlertSinkWebhook:
+		return &WebhookAlertSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert sink %s", sink)
+	}
+}
+
+// EmailAlertSink mails the notification to the comma separated addresses of
+// the alert target through the smtp server of the config.
+type EmailAlertSink struct {
+	config *Config
+}
+
+func (sink *EmailAlertSink) Notify(alert *Alert, call *Call, keywords []string) error {
+	var (
+		auth smtp.Auth
+		body = bytes.Buffer{}
+		from = sink.config.SmtpFrom
+		to   = []string{}
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("emailalertsink.notify: %v", err)
+	}
+
+	if len(sink.config.SmtpHost) == 0 {
+		return formatError(errors.New("no smtp host"))
+	}
+
+	for _, s := range strings.Split(alert.Target, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			to = append(to, s)
+		}
+	}
+
+	if len(to) == 0 {
+		return formatError(errors.New("no recipient"))
+	}
+
+	if len(from) == 0 {
+		from = sink.config.SmtpUsername
+	}
+
+	if len(sink.config.SmtpUsername) > 0 {
+		auth = smtp.PlainAuth("", sink.config.SmtpUsername, sink.config.SmtpPassword, sink.config.SmtpHost)
+	}
+
+	subject := fmt.Sprintf("%s: %s", alert.Label, getAlertCallTitle(call))
+
+	fmt.Fprintf(&body, "From: %s\r\n", from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&body, "\r\n")
+
+	fmt.Fprintf(&body, "%s\r\n\r\n", subject)
+	fmt.Fprintf(&body, "Date: %s\r\n", call.DateTime.Local().Format(time.RFC1123))
+	fmt.Fprintf(&body, "System: %v\r\n", getAlertLabel(call.systemLabel, call.System))
+	fmt.Fprintf(&body, "Talkgroup: %v\r\n", getAlertLabel(call.talkgroupLabel, call.Talkgroup))
+
+	if call.Source != nil {
+		fmt.Fprintf(&body, "Unit: %v\r\n", call.Source)
+	}
+
+	if call.emergency {
+		fmt.Fprintf(&body, "Emergency: yes\r\n")
+	}
+
+	if len(keywords) > 0 {
+		fmt.Fprintf(&body, "Keywords: %s\r\n", strings.Join(keywords, ", "))
+	}
+
+	switch v := call.Transcript.(type) {
+	case string:
+		if len(v) > 0 {
+			fmt.Fprintf(&body, "\r\n%s\r\n", v)
+		}
+	}
+
+	addr := net.JoinHostPort(sink.config.SmtpHost, strconv.Itoa(int(sink.config.SmtpPort)))
+
+	if err := smtp.SendMail(addr, auth, from, to, body.Bytes()); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+// ExecAlertSink runs the alert target with the notification as json on its
+// standard input and the main fields in FREESCANNER_ environment variables.
+// Only the programs of the alert exec directory of the config can be run.
+type ExecAlertSink struct {
+	config *Config
+}
+
+func (sink *ExecAlertSink) Notify(alert *Alert, call *Call, keywords []string) error {
+	const timeout = 30 * time.Second
+
+	var stderr = bytes.Buffer{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("execalertsink.notify: %v", err)
+	}
+
+	command, err := getAlertExecPath(sink.config, alert.Target)
+	if err != nil {
+		return formatError(err)
+	}
+
+	b, err := json.Marshal(getAlertPayload(alert, call, keywords))
+	if err != nil {
+		return formatError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("FREESCANNER_ALERT=%s", alert.Label),
+		fmt.Sprintf("FREESCANNER_CALL_ID=%v", call.Id),
+		fmt.Sprintf("FREESCANNER_EMERGENCY=%v", call.emergency),
+		fmt.Sprintf("FREESCANNER_KEYWORDS=%s", strings.Join(keywords, ",")),
+		fmt.Sprintf("FREESCANNER_SYSTEM=%v", call.System),
+		fmt.Sprintf("FREESCANNER_TALKGROUP=%v", call.Talkgroup),
+	)
+
+	if err = cmd.Run(); err != nil {
+		return formatError(fmt.Errorf("%v, %s", err, strings.TrimSpace(stderr.String())))
+	}
+
+	return nil
+}
+
+// WebhookAlertSink posts the notification as json to the alert target url.
+type WebhookAlertSink struct{}
+
+func (sink *WebhookAlertSink) Notify(alert *Alert, call *Call, keywords []string) error {
+	formatError := func(err error) error {
+		return fmt.Errorf("webhookalertsink.notify: %v", err)
+	}
+
+	b, err := json.Marshal(getAlertPayload(alert, call, keywords))
+	if err != nil {
+		return formatError(err)
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+
+	res, err := c.Post(alert.Target, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return formatError(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return formatError(fmt.Errorf("bad status: %s", res.Status))
+	}
+
+	return nil
+}
+
+func getAlertCallTitle(call *Call) string {
+	title := fmt.Sprintf("%v %v", getAlertLabel(call.systemLabel, call.System), getAlertLabel(call.talkgroupLabel, call.Talkgroup))
+
+	switch v := call.talkgroupName.(type) {
+	case string:
+		if len(v) > 0 {
+			title = fmt.Sprintf("%s (%s)", title, v)
+		}
+	}
+
+	return title
+}
+
+// getAlertExecPath resolves the target of an exec alert to a program of the
+// alert exec directory, symbolic links included, so that the editors of the
+// alerts cannot run anything else.
+func getAlertExecPath(config *Config, target string) (string, error) {
+	dir := config.GetAlertExecDirPath()
+	if len(dir) == 0 {
+		return "", errors.New("exec alerts disabled, no alert exec directory")
+	}
+
+	if len(target) == 0 {
+		return "", errors.New("no command")
+	}
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	p := target
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+
+	if p, err = filepath.EvalSymlinks(p); err != nil {
+		return "", err
+	}
+
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the alert exec directory", target)
+	}
+
+	return p, nil
+}
+
+func getAlertLabel(label any, id uint) any {
+	switch v := label.(type) {
+	case string:
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return id
+}
+
+func getAlertPayload(alert *Alert, call *Call, keywords []string) map[string]any {
+	return map[string]any{
+		"alert": alert.Label,
+		"call": map[string]any{
+			"dateTime":       call.DateTime.Format(time.RFC3339),
+			"emergency":      call.emergency,
+			"id":             call.Id,
+			"source":         call.Source,
+			"system":         call.System,
+			"systemLabel":    call.systemLabel,
+			"talkgroup":      call.Talkgroup,
+			"talkgroupGroup": call.talkgroupGroup,
+			"talkgroupLabel": call.talkgroupLabel,
+			"talkgroupName":  call.talkgroupName,
+			"talkgroupTag":   call.talkgroupTag,
+			"transcript":     call.Transcript,
+		},
+		"keywords": keywords,
+	}
+}
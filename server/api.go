@@ -16,20 +16,59 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type Api struct {
 	Controller *Controller
+	mutex      sync.Mutex
+	uploads    map[string]*apiPendingUpload
+}
+
+// apiPendingUpload is a call of which the metadata were posted and the audio
+// is yet to be put, as with the Broadcastify Calls protocol.
+type apiPendingUpload struct {
+	call    *Call
+	ext     string
+	expires time.Time
 }
 
 func NewApi(controller *Controller) *Api {
-	return &Api{Controller: controller}
+	return &Api{
+		Controller: controller,
+		mutex:      sync.Mutex{},
+		uploads:    map[string]*apiPendingUpload{},
+	}
+}
+
+// BroadcastifyCallUploadHandler speaks the Broadcastify Calls upload protocol.
+// The call metadata are first posted with the API key, and answered with the
+// url where the audio is then put.
+func (api *Api) BroadcastifyCallUploadHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		api.postBroadcastifyCall(w, r)
+
+	case http.MethodPut:
+		api.putBroadcastifyAudio(w, r)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+	}
 }
 
 func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -77,8 +116,9 @@ func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if ok, err := call.IsValid(); ok {
-			api.HandleCall(key, call, w)
+			api.HandleCall(key, call, w, r)
 		} else {
+			api.Controller.Metrics.CallRejected(call, MetricsRejectInvalid)
 			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s\n", err.Error()))
 		}
 
@@ -88,29 +128,193 @@ func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (api *Api) HandleCall(key string, call *Call, w http.ResponseWriter) {
-	msg := []byte(fmt.Sprintf("Invalid API key for system %v talkgroup %v.\n", call.System, call.Talkgroup))
+// CallsHandler serves /api/calls, /api/calls/{id} and /api/calls/{id}/audio.
+func (api *Api) CallsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var id uint
 
-	if apikey, ok := api.Controller.Apikeys.GetApikey(key); ok {
-		if apikey.HasAccess(call) {
-			api.Controller.Ingest <- call
+		client, ok := api.newReadClient(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid access code or API key\n"))
+			return
+		}
+
+		p := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/calls"), "/"), "/")
 
+		if len(p[0]) == 0 {
+			api.searchCalls(client, w, r)
+			return
+		}
+
+		if i, err := strconv.ParseUint(p[0], 10, 64); err == nil && i > 0 {
+			id = uint(i)
 		} else {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write(msg)
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-	} else {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write(msg)
+		call, err := api.Controller.Calls.GetCall(id, api.Controller.Database)
+		if err != nil {
+			api.exitWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if call.System == 0 || (client.Access != nil && !client.Access.HasAccess(call)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case len(p) == 1:
+			if b, err := call.MarshalJSONWithAudioEncoding(AudioEncodingBinary); err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(b)
+			} else {
+				api.exitWithError(w, http.StatusInternalServerError, err.Error())
+			}
+
+		case len(p) == 2 && p[1] == "audio":
+			var audioName string
+
+			switch v := call.AudioName.(type) {
+			case string:
+				audioName = v
+			}
+
+			switch v := call.AudioType.(type) {
+			case string:
+				w.Header().Set("Content-Type", v)
+			}
+
+			http.ServeContent(w, r, audioName, call.DateTime, bytes.NewReader(call.Audio))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+	}
+}
+
+func (api *Api) HandleCall(key string, call *Call, w http.ResponseWriter, r *http.Request) {
+	if !api.authorizeUpload(key, call, w, r) {
 		return
 	}
 
+	api.Controller.Ingest <- call
+
 	w.Write([]byte("Call imported successfully.\n"))
 }
 
+func (api *Api) OP25CallUploadHandler(w http.ResponseWriter, r *http.Request) {
+	api.handleMetaCallUpload(w, r, ParseOP25Meta)
+}
+
+// EventsHandler serves /api/events, a server-sent events feed of the calls and
+// of the config changes, for dashboards that do not speak the websocket protocol.
+func (api *Api) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		client, ok := api.newReadClient(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid access code or API key\n"))
+			return
+		}
+
+		if _, ok := w.(http.Flusher); !ok {
+			api.exitWithError(w, http.StatusInternalServerError, "Streaming unsupported")
+			return
+		}
+
+		query := r.URL.Query()
+
+		selection := query.Get("talkgroups")
+		if v := query.Get("system"); len(v) > 0 {
+			if t := query.Get("talkgroup"); len(t) > 0 {
+				v = fmt.Sprintf("%s:%s", v, t)
+			}
+			if len(selection) > 0 {
+				selection = fmt.Sprintf("%s,%s", selection, v)
+			} else {
+				selection = v
+			}
+		}
+
+		eventsClient := NewEventsClient(api.Controller, client.Access, selection, query.Get("group"), query.Get("tag"))
+
+		api.Controller.Events.Add(eventsClient)
+		defer api.Controller.Events.Remove(eventsClient)
+
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		if err := eventsClient.Run(r.Context(), NewDeadlineWriter(w, r)); err != nil && r.Context().Err() == nil {
+			api.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api.events: %v", err))
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+	}
+}
+
+// StreamHandler serves /api/stream, a continuous audio stream of the calls of
+// a talkgroup selection, for players that cannot run the web app.
+func (api *Api) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		client, ok := api.newReadClient(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid access code or API key\n"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if len(format) == 0 {
+			format = StreamFormatMp3
+		}
+
+		stream, err := NewStream(api.Controller, client.Access, r.URL.Query().Get("talkgroups"), format)
+		if err != nil {
+			api.exitWithError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		if !api.Controller.Streams.AddLimited(stream, api.Controller.Config.StreamsMax) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Too many listeners, try again later\n"))
+			return
+		}
+		defer api.Controller.Streams.Remove(stream)
+
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		w.Header().Set("Content-Type", stream.ContentType())
+		w.Header().Set("Icy-Name", api.Controller.Options.Branding)
+
+		if err = stream.Run(r.Context(), NewDeadlineWriter(w, r)); err != nil {
+			api.Controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+	}
+}
+
 func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Request) {
+	api.handleMetaCallUpload(w, r, ParseTrunkRecorderMeta)
+}
+
+// handleMetaCallUpload handles the upload of a call whose metadata come from
+// a recorder specific meta part, parsed with parseMeta.
+func (api *Api) handleMetaCallUpload(w http.ResponseWriter, r *http.Request, parseMeta func(call *Call, b []byte) error) {
 	switch r.Method {
 	case http.MethodPost:
 		var (
@@ -152,8 +356,8 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 			case "key":
 				key = string(b)
 			case "meta":
-				if err := ParseTrunkRecorderMeta(call, b); err != nil {
-					api.exitWithError(w, http.StatusExpectationFailed, "Invalid call data")
+				if err := parseMeta(call, b); err != nil {
+					api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Invalid call data: %s", err.Error()))
 					return
 				}
 			default:
@@ -166,9 +370,10 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 		}
 
 		if ok, err := call.IsValid(); ok {
-			api.HandleCall(key, call, w)
+			api.HandleCall(key, call, w, r)
 
 		} else {
+			api.Controller.Metrics.CallRejected(call, MetricsRejectInvalid)
 			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s\n", err.Error()))
 		}
 
@@ -178,9 +383,262 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// authorizeUpload checks the API key for the upload of the call and otherwise
+// answers with the reason of the rejection.
+func (api *Api) authorizeUpload(key string, call *Call, w http.ResponseWriter, r *http.Request) bool {
+	controller := api.Controller
+
+	apikey, err := controller.Apikeys.Authorize(controller.Database, key, ApikeyPermissionUpload, call, GetRemoteAddr(r))
+	if apikey != nil {
+		if err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+		return true
+	}
+
+	if errors.Is(err, errApikeyRateLimit) {
+		controller.Metrics.CallRejected(call, MetricsRejectRateLimited)
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else {
+		controller.Metrics.CallRejected(call, MetricsRejectBadKey)
+		controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api: %v for ip %s", err, GetRemoteAddr(r)))
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	w.Write([]byte(fmt.Sprintf("Call rejected: %v.\n", err)))
+
+	return false
+}
+
 func (api *Api) exitWithError(w http.ResponseWriter, status int, message string) {
 	api.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("api: %s", message))
 
 	w.WriteHeader(status)
 	w.Write([]byte(fmt.Sprintf("%s\n", message)))
 }
+
+// newReadClient authenticates a read request with either an access code or an
+// API key allowed to read, and scopes the returned client to what it can see.
+// As with the websocket, no credentials are needed when no access codes are
+// defined.
+func (api *Api) newReadClient(r *http.Request) (*Client, bool) {
+	controller := api.Controller
+
+	client := &Client{Controller: controller, request: r}
+
+	getCredential := func(header string, param string) string {
+		if v := r.Header.Get(header); len(v) > 0 {
+			return v
+		}
+		return r.URL.Query().Get(param)
+	}
+
+	if key := getCredential("X-Api-Key", "key"); len(key) > 0 {
+		apikey, err := controller.Apikeys.Authorize(controller.Database, key, ApikeyPermissionRead, nil, GetRemoteAddr(r))
+		if apikey == nil {
+			controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api: %v for ip %s", err, GetRemoteAddr(r)))
+			return nil, false
+		} else if err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+		client.Access = &Access{Ident: apikey.Ident, Systems: apikey.Systems}
+
+	} else if code := getCredential("X-Access-Code", "code"); len(code) > 0 {
+		access, ok := controller.Accesses.GetAccess(code)
+		if !ok || access.HasExpired() {
+			controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api: invalid access code %s for ip %s", code, GetRemoteAddr(r)))
+			return nil, false
+		}
+		client.Access = access
+
+	} else if controller.Accesses.IsRestricted() {
+		return nil, false
+	}
+
+	client.SystemsMap = controller.Systems.GetScopedSystems(client, controller.Groups, controller.Tags, controller.Options.SortTalkgroups)
+	client.GroupsMap = controller.Groups.GetGroupsMap(&client.SystemsMap)
+	client.TagsMap = controller.Tags.GetTagsMap(&client.SystemsMap)
+
+	return client, true
+}
+
+func (api *Api) postBroadcastifyCall(w http.ResponseWriter, r *http.Request) {
+	const expiration = 10 * time.Minute
+
+	var (
+		call = NewCall()
+		ext  = "m4a"
+		key  string
+	)
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		api.exitWithError(w, http.StatusBadRequest, "Invalid content-type")
+		return
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		api.exitWithError(w, http.StatusBadRequest, "Not a multipart content")
+		return
+	}
+
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("multipart: %s", err.Error()))
+			return
+		}
+
+		b, err := io.ReadAll(p)
+		if err != nil {
+			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("ioread: %s", err.Error()))
+			return
+		}
+
+		switch p.FormName() {
+		case "apiKey":
+			key = string(b)
+
+		case "enc":
+			if s := strings.TrimSpace(string(b)); len(s) > 0 {
+				ext = s
+			}
+
+		case "freq":
+			if i, err := strconv.Atoi(string(b)); err == nil && i > 0 {
+				call.Frequency = uint(i)
+			}
+
+		case "metadata":
+			if err := ParseTrunkRecorderMeta(call, b); err != nil {
+				api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Invalid call data: %s", err.Error()))
+				return
+			}
+
+		case "src":
+			if i, err := strconv.Atoi(string(b)); err == nil && i > 0 {
+				call.Source = uint(i)
+			}
+
+		case "systemId":
+			if i, err := strconv.Atoi(string(b)); err == nil && i > 0 {
+				call.System = uint(i)
+			}
+
+		case "tg":
+			if i, err := strconv.Atoi(string(b)); err == nil && i > 0 {
+				call.Talkgroup = uint(i)
+			}
+
+		case "ts":
+			if i, err := strconv.Atoi(string(b)); err == nil && i > 0 {
+				call.DateTime = time.Unix(int64(i), 0).UTC()
+			}
+		}
+	}
+
+	if call.System == 0 || call.Talkgroup == 0 {
+		api.Controller.Metrics.CallRejected(call, MetricsRejectInvalid)
+		api.exitWithError(w, http.StatusExpectationFailed, "Incomplete call data: no system or talkgroup")
+		return
+	}
+
+	if call.DateTime.IsZero() {
+		call.DateTime = time.Now().UTC()
+	}
+
+	if !api.authorizeUpload(key, call, w, r) {
+		return
+	}
+
+	token := uuid.New().String()
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	api.mutex.Lock()
+	for t, upload := range api.uploads {
+		if time.Now().After(upload.expires) {
+			delete(api.uploads, t)
+		}
+	}
+	api.uploads[token] = &apiPendingUpload{call: call, ext: ext, expires: time.Now().Add(expiration)}
+	api.mutex.Unlock()
+
+	// the client splits the response on the first space, without trimming
+	w.Write([]byte(fmt.Sprintf("0 %s://%s/api/broadcastify-call-upload/%s", scheme, r.Host, token)))
+}
+
+func (api *Api) putBroadcastifyAudio(w http.ResponseWriter, r *http.Request) {
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/broadcastify-call-upload"), "/")
+
+	api.mutex.Lock()
+	upload, ok := api.uploads[token]
+	delete(api.uploads, token)
+	api.mutex.Unlock()
+
+	if !ok || time.Now().After(upload.expires) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("ioread: %s", err.Error()))
+		return
+	}
+
+	call := upload.call
+
+	call.Audio = b
+	call.AudioName = fmt.Sprintf("%v-%v-%v.%s", call.System, call.Talkgroup, call.DateTime.Unix(), upload.ext)
+
+	if t := mime.TypeByExtension("." + upload.ext); len(t) > 0 {
+		call.AudioType = t
+	} else {
+		call.AudioType = r.Header.Get("Content-Type")
+	}
+
+	if ok, err := call.IsValid(); ok {
+		api.Controller.Ingest <- call
+
+	} else {
+		api.Controller.Metrics.CallRejected(call, MetricsRejectInvalid)
+		api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s", err.Error()))
+	}
+}
+
+func (api *Api) searchCalls(client *Client, w http.ResponseWriter, r *http.Request) {
+	m := map[string]any{}
+	q := r.URL.Query()
+
+	for _, k := range []string{"date", "group", "query", "tag"} {
+		if v := q.Get(k); len(v) > 0 {
+			m[k] = v
+		}
+	}
+
+	for _, k := range []string{"limit", "offset", "sort", "system", "talkgroup"} {
+		if v, err := strconv.ParseFloat(q.Get(k), 64); err == nil && (v >= 0 || k == "sort") {
+			m[k] = v
+		}
+	}
+
+	searchOptions := CallsSearchOptions{searchPatchedTalkgroups: api.Controller.Options.SearchPatchedTalkgroups}
+	searchOptions.fromMap(m)
+
+	searchResults, err := api.Controller.Calls.Search(&searchOptions, client)
+	if err != nil {
+		api.exitWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searchResults)
+}
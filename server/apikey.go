@@ -18,20 +18,46 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	ApikeyPermissionAdminRead = "admin-read"
+	ApikeyPermissionRead      = "read"
+	ApikeyPermissionUpload    = "upload"
+
+	apikeyRateWindow = time.Minute
+	apikeyTouchDelay = time.Minute
+)
+
+var errApikeyRateLimit = errors.New("API key upload rate limit exceeded")
+
+// Apikey grants the upload of calls, the read and search of calls, and the
+// read of the administrative configuration and logs, each one a permission of
+// its own. The rate limit is the uploads allowed per minute, 0 or nil for no
+// limit.
 type Apikey struct {
-	Id       any    `json:"_id"`
-	Disabled bool   `json:"disabled"`
-	Ident    string `json:"ident"`
-	Key      string `json:"key"`
-	Order    any    `json:"order"`
-	Systems  any    `json:"systems"`
+	Id          any    `json:"_id"`
+	AdminRead   bool   `json:"adminRead"`
+	Disabled    bool   `json:"disabled"`
+	Expiration  any    `json:"expiration"`
+	Ident       string `json:"ident"`
+	Key         string `json:"key"`
+	LastUsed    any    `json:"lastUsed"`
+	LastUsedIp  string `json:"lastUsedIp"`
+	Order       any    `json:"order"`
+	RateLimit   any    `json:"rateLimit"`
+	Read        bool   `json:"read"`
+	Systems     any    `json:"systems"`
+	Upload      bool   `json:"upload"`
+	dateWritten time.Time
+	rateCount   uint
+	rateWindow  time.Time
 }
 
 func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
@@ -40,11 +66,23 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 		apikey.Id = uint(v)
 	}
 
+	switch v := m["adminRead"].(type) {
+	case bool:
+		apikey.AdminRead = v
+	}
+
 	switch v := m["disabled"].(type) {
 	case bool:
 		apikey.Disabled = v
 	}
 
+	switch v := m["expiration"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			apikey.Expiration = t.UTC()
+		}
+	}
+
 	switch v := m["ident"].(type) {
 	case string:
 		apikey.Ident = v
@@ -60,6 +98,18 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 		apikey.Order = uint(v)
 	}
 
+	switch v := m["rateLimit"].(type) {
+	case float64:
+		if v > 0 {
+			apikey.RateLimit = uint(v)
+		}
+	}
+
+	switch v := m["read"].(type) {
+	case bool:
+		apikey.Read = v
+	}
+
 	switch v := m["systems"].(type) {
 	case []any:
 		if b, err := json.Marshal(v); err == nil {
@@ -69,6 +119,14 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 		apikey.Systems = v
 	}
 
+	// keys saved before the permissions existed were upload keys
+	switch v := m["upload"].(type) {
+	case bool:
+		apikey.Upload = v
+	default:
+		apikey.Upload = true
+	}
+
 	return apikey
 }
 
@@ -110,6 +168,27 @@ func (apikey *Apikey) HasAccess(call *Call) bool {
 	return false
 }
 
+func (apikey *Apikey) HasExpired() bool {
+	switch v := apikey.Expiration.(type) {
+	case time.Time:
+		return v.Before(time.Now())
+	}
+	return false
+}
+
+func (apikey *Apikey) HasPermission(permission string) bool {
+	switch permission {
+	case ApikeyPermissionAdminRead:
+		return apikey.AdminRead
+	case ApikeyPermissionRead:
+		return apikey.Read
+	case ApikeyPermissionUpload:
+		return apikey.Upload
+	default:
+		return false
+	}
+}
+
 type Apikeys struct {
 	List  []*Apikey
 	mutex sync.Mutex
@@ -122,6 +201,69 @@ func NewApikeys() *Apikeys {
 	}
 }
 
+// Authorize checks that the key may be used for the permission, and for the
+// upload of the call if given, and tracks its last use. The error tells why
+// the key was rejected.
+func (apikeys *Apikeys) Authorize(db *Database, key string, permission string, call *Call, ip string) (*Apikey, error) {
+	apikeys.mutex.Lock()
+	defer apikeys.mutex.Unlock()
+
+	var apikey *Apikey
+
+	for _, a := range apikeys.List {
+		if a.Key == key {
+			apikey = a
+			break
+		}
+	}
+
+	switch {
+	case apikey == nil:
+		return nil, errors.New("unknown API key")
+
+	case apikey.Disabled:
+		return nil, fmt.Errorf("API key %s is disabled", apikey.Ident)
+
+	case apikey.HasExpired():
+		return nil, fmt.Errorf("API key %s has expired", apikey.Ident)
+
+	case !apikey.HasPermission(permission):
+		return nil, fmt.Errorf("API key %s has no %s permission", apikey.Ident, permission)
+
+	case call != nil && !apikey.HasAccess(call):
+		return nil, fmt.Errorf("API key %s has no access to system %v talkgroup %v", apikey.Ident, call.System, call.Talkgroup)
+	}
+
+	now := time.Now().UTC()
+
+	if permission == ApikeyPermissionUpload {
+		switch limit := apikey.RateLimit.(type) {
+		case uint:
+			if now.Sub(apikey.rateWindow) >= apikeyRateWindow {
+				apikey.rateCount = 0
+				apikey.rateWindow = now
+			}
+			if apikey.rateCount >= limit {
+				return nil, fmt.Errorf("%w, %d per minute for %s", errApikeyRateLimit, limit, apikey.Ident)
+			}
+			apikey.rateCount++
+		}
+	}
+
+	apikey.LastUsed = now
+	apikey.LastUsedIp = ip
+
+	if now.Sub(apikey.dateWritten) >= apikeyTouchDelay {
+		apikey.dateWritten = now
+
+		if _, err := db.Sql.Exec("update `freeScannerApiKeys` set `lastUsed` = ?, `lastUsedIp` = ? where `_id` = ?", now, ip, apikey.Id); err != nil {
+			return apikey, fmt.Errorf("apikeys.authorize: %v", err)
+		}
+	}
+
+	return apikey, nil
+}
+
 func (apikeys *Apikeys) FromMap(f []any) *Apikeys {
 	apikeys.mutex.Lock()
 	defer apikeys.mutex.Unlock()
@@ -140,47 +282,72 @@ func (apikeys *Apikeys) FromMap(f []any) *Apikeys {
 	return apikeys
 }
 
-func (apikeys *Apikeys) GetApikey(key string) (apikey *Apikey, ok bool) {
-	apikeys.mutex.Lock()
-	defer apikeys.mutex.Unlock()
-
-	for _, apikey := range apikeys.List {
-		if apikey.Key == key && !apikey.Disabled {
-			return apikey, true
-		}
-	}
-	return nil, false
-}
-
 func (apikeys *Apikeys) Read(db *Database) error {
 	var (
-		err     error
-		id      sql.NullFloat64
-		order   sql.NullFloat64
-		rows    *sql.Rows
-		systems string
+		err        error
+		expiration any
+		id         sql.NullFloat64
+		lastUsed   any
+		lastUsedIp sql.NullString
+		order      sql.NullFloat64
+		rateLimit  sql.NullFloat64
+		rows       *sql.Rows
+		systems    string
+		upload     sql.NullBool
 	)
 
 	apikeys.mutex.Lock()
 	defer apikeys.mutex.Unlock()
 
+	previous := map[string]*Apikey{}
+	for _, apikey := range apikeys.List {
+		previous[apikey.Key] = apikey
+	}
+
 	apikeys.List = []*Apikey{}
 
 	formatError := func(err error) error {
 		return fmt.Errorf("apikeys.read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `disabled`, `ident`, `key`, `order`, `systems` from `freeScannerApiKeys`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `adminRead`, `disabled`, `expiration`, `ident`, `key`, `lastUsed`, `lastUsedIp`, `order`, `rateLimit`, `read`, `systems`, `upload` from `freeScannerApiKeys`"); err != nil {
 		return formatError(err)
 	}
 
 	for rows.Next() {
 		apikey := &Apikey{}
 
-		if err = rows.Scan(&id, &apikey.Disabled, &apikey.Ident, &apikey.Key, &order, &systems); err != nil {
+		if err = rows.Scan(&id, &apikey.AdminRead, &apikey.Disabled, &expiration, &apikey.Ident, &apikey.Key, &lastUsed, &lastUsedIp, &order, &rateLimit, &apikey.Read, &systems, &upload); err != nil {
 			break
 		}
 
+		if t, err := db.ParseDateTime(expiration); err == nil {
+			apikey.Expiration = t
+		}
+
+		if t, err := db.ParseDateTime(lastUsed); err == nil {
+			apikey.LastUsed = t
+			apikey.dateWritten = t
+		}
+
+		apikey.LastUsedIp = lastUsedIp.String
+
+		if rateLimit.Valid && rateLimit.Float64 > 0 {
+			apikey.RateLimit = uint(rateLimit.Float64)
+		}
+
+		apikey.Upload = !upload.Valid || upload.Bool
+
+		if p, ok := previous[apikey.Key]; ok {
+			if t, ok := p.LastUsed.(time.Time); ok && t.After(apikey.dateWritten) {
+				apikey.LastUsed = t
+				apikey.LastUsedIp = p.LastUsedIp
+			}
+			apikey.dateWritten = p.dateWritten
+			apikey.rateCount = p.rateCount
+			apikey.rateWindow = p.rateWindow
+		}
+
 		if id.Valid && id.Float64 > 0 {
 			apikey.Id = uint(id.Float64)
 		}
@@ -257,14 +424,9 @@ func (apikeys *Apikeys) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerApikeys` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerApikeys").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -281,11 +443,11 @@ func (apikeys *Apikeys) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerApiKeys` (`_id`, `disabled`, `ident`, `key`, `order`, `systems`) values (?, ?, ?, ?, ?, ?)", apikey.Id, apikey.Disabled, apikey.Ident, apikey.Key, apikey.Order, systems); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerApiKeys` (`_id`, `adminRead`, `disabled`, `expiration`, `ident`, `key`, `order`, `rateLimit`, `read`, `systems`, `upload`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", apikey.Id, apikey.AdminRead, apikey.Disabled, apikey.Expiration, apikey.Ident, apikey.Key, apikey.Order, apikey.RateLimit, apikey.Read, systems, apikey.Upload); err != nil {
 				break
 			}
 
-		} else if _, err = db.Sql.Exec("update `freeScannerApiKeys` set `_id` = ?, `disabled` = ?, `ident` = ?, `key` = ?, `order` = ?, `systems` = ? where `_id` = ?", apikey.Id, apikey.Disabled, apikey.Ident, apikey.Key, apikey.Order, systems, apikey.Id); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerApiKeys` set `_id` = ?, `adminRead` = ?, `disabled` = ?, `expiration` = ?, `ident` = ?, `key` = ?, `order` = ?, `rateLimit` = ?, `read` = ?, `systems` = ?, `upload` = ? where `_id` = ?", apikey.Id, apikey.AdminRead, apikey.Disabled, apikey.Expiration, apikey.Ident, apikey.Key, apikey.Order, apikey.RateLimit, apikey.Read, systems, apikey.Upload, apikey.Id); err != nil {
 			break
 		}
 	}
@@ -0,0 +1,142 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AudioStore keeps the audio of the calls outside of the database. The
+// database only holds the reference returned by Write.
+type AudioStore interface {
+	Delete(ref string) error
+	Read(ref string) ([]byte, error)
+	Write(call *Call) (string, error)
+}
+
+// LocalAudioStore stores audio files in a directory tree laid out as
+// YYYY/MM/DD/<system>/<talkgroup>/<file>.
+type LocalAudioStore struct {
+	Directory string
+}
+
+func NewLocalAudioStore(directory string) *LocalAudioStore {
+	return &LocalAudioStore{Directory: directory}
+}
+
+func (store *LocalAudioStore) Delete(ref string) error {
+	var (
+		err error
+		fp  string
+	)
+
+	if fp, err = store.getPath(ref); err != nil {
+		return fmt.Errorf("audiostore.delete: %v", err)
+	}
+
+	if err = os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("audiostore.delete: %v", err)
+	}
+
+	// remove the now empty talkgroup/system/date folders, os.Remove fails on non empty folders
+	root := filepath.Clean(store.Directory)
+	for dir := filepath.Dir(fp); len(dir) > len(root) && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (store *LocalAudioStore) Read(ref string) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+		fp  string
+	)
+
+	if fp, err = store.getPath(ref); err != nil {
+		return nil, fmt.Errorf("audiostore.read: %v", err)
+	}
+
+	if b, err = os.ReadFile(fp); err != nil {
+		return nil, fmt.Errorf("audiostore.read: %v", err)
+	}
+
+	return b, nil
+}
+
+func (store *LocalAudioStore) Write(call *Call) (string, error) {
+	var (
+		err error
+		ext string
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("audiostore.write: %v", err)
+	}
+
+	switch v := call.AudioName.(type) {
+	case string:
+		ext = path.Ext(v)
+	}
+
+	if len(ext) == 0 {
+		switch v := call.AudioType.(type) {
+		case string:
+			if exts, err := mime.ExtensionsByType(v); err == nil && len(exts) > 0 {
+				ext = exts[0]
+			}
+		}
+	}
+
+	dateTime := call.DateTime.UTC()
+
+	ref := path.Join(
+		dateTime.Format("2006/01/02"),
+		fmt.Sprintf("%v", call.System),
+		fmt.Sprintf("%v", call.Talkgroup),
+		fmt.Sprintf("%s-%s%s", dateTime.Format("150405"), uuid.New().String(), strings.ToLower(ext)),
+	)
+
+	fp := filepath.Join(store.Directory, filepath.FromSlash(ref))
+
+	if err = os.MkdirAll(filepath.Dir(fp), 0770); err != nil {
+		return "", formatError(err)
+	}
+
+	if err = os.WriteFile(fp, call.Audio, 0660); err != nil {
+		return "", formatError(err)
+	}
+
+	return ref, nil
+}
+
+func (store *LocalAudioStore) getPath(ref string) (string, error) {
+	if len(ref) == 0 || path.IsAbs(ref) || strings.HasPrefix(path.Clean(ref), "..") {
+		return "", fmt.Errorf("invalid audio reference %s", ref)
+	}
+
+	return filepath.Join(store.Directory, filepath.FromSlash(path.Clean(ref))), nil
+}
@@ -17,15 +17,22 @@ package main
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"math"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+var searchWordRegexp = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
 type Call struct {
 	Id             any       `json:"id"`
 	Audio          []byte    `json:"audio"`
@@ -39,6 +46,10 @@ type Call struct {
 	Sources        any       `json:"sources"`
 	System         uint      `json:"system"`
 	Talkgroup      uint      `json:"talkgroup"`
+	Transcript     any       `json:"transcript"`
+	audioPath      string
+	emergency      bool
+	ingested       func(err error)
 	systemLabel    any
 	talkgroupGroup any
 	talkgroupLabel any
@@ -82,15 +93,14 @@ func (call *Call) IsValid() (ok bool, err error) {
 }
 
 func (call *Call) MarshalJSON() ([]byte, error) {
-	audio := fmt.Sprintf("%v", call.Audio)
-	audio = strings.ReplaceAll(audio, " ", ",")
+	return call.MarshalJSONWithAudioEncoding(AudioEncodingBuffer)
+}
 
-	return json.Marshal(map[string]any{
-		"id": call.Id,
-		"audio": map[string]any{
-			"data": json.RawMessage(audio),
-			"type": "Buffer",
-		},
+// MarshalJSONWithAudioEncoding leaves the audio out of the json with the
+// binary encoding, only its size is given as the audio is sent on its own.
+func (call *Call) MarshalJSONWithAudioEncoding(encoding string) ([]byte, error) {
+	m := map[string]any{
+		"id":          call.Id,
 		"audioName":   call.AudioName,
 		"audioType":   call.AudioType,
 		"dateTime":    call.DateTime.Format(time.RFC3339),
@@ -101,7 +111,49 @@ func (call *Call) MarshalJSON() ([]byte, error) {
 		"sources":     call.Sources,
 		"system":      call.System,
 		"talkgroup":   call.Talkgroup,
-	})
+		"transcript":  call.Transcript,
+	}
+
+	switch encoding {
+	case AudioEncodingBase64:
+		m["audio"] = base64.StdEncoding.EncodeToString(call.Audio)
+
+	case AudioEncodingBinary:
+		m["audioSize"] = len(call.Audio)
+
+	default:
+		// node.js buffer format, {"type":"Buffer","data":[1,2,3]}
+		b := make([]byte, 0, len(call.Audio)*4+2)
+		b = append(b, '[')
+		for i, v := range call.Audio {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendUint(b, uint64(v), 10)
+		}
+		b = append(b, ']')
+
+		m["audio"] = map[string]any{
+			"data": json.RawMessage(b),
+			"type": "Buffer",
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+func (call *Call) ReadAudio(db *Database) error {
+	if len(call.Audio) > 0 || len(call.audioPath) == 0 {
+		return nil
+	}
+
+	if b, err := db.AudioStore.Read(call.audioPath); err == nil {
+		call.Audio = b
+	} else {
+		return fmt.Errorf("call.readaudio: %v", err)
+	}
+
+	return nil
 }
 
 func (call *Call) ToJson() (string, error) {
@@ -112,6 +164,60 @@ func (call *Call) ToJson() (string, error) {
 	}
 }
 
+func (call *Call) setLabels(system *System, talkgroup *Talkgroup, groups *Groups, tags *Tags) {
+	call.systemLabel = system.Label
+	call.talkgroupLabel = talkgroup.Label
+	call.talkgroupName = talkgroup.Name
+
+	if group, ok := groups.GetGroup(talkgroup.GroupId); ok {
+		call.talkgroupGroup = group.Label
+	}
+
+	if tag, ok := tags.GetTag(talkgroup.TagId); ok {
+		call.talkgroupTag = tag.Label
+	}
+}
+
+// toEvent returns the call metadata without the audio, as published to the
+// event subscribers, with the unit labels of the system.
+func (call *Call) toEvent(systems *Systems) map[string]any {
+	units := []map[string]any{}
+
+	system, _ := systems.GetSystem(call.System)
+
+	for _, s := range getDownstreamList(call.Sources) {
+		unit := map[string]any{"id": s["src"]}
+
+		if system != nil && system.Units != nil {
+			for _, u := range system.Units.List {
+				if fmt.Sprintf("%v", u.Id) == fmt.Sprintf("%v", s["src"]) {
+					unit["label"] = u.Label
+					break
+				}
+			}
+		}
+
+		units = append(units, unit)
+	}
+
+	return map[string]any{
+		"dateTime":       call.DateTime.Format(time.RFC3339),
+		"duration":       getCallDuration(call),
+		"emergency":      call.emergency,
+		"frequency":      call.Frequency,
+		"id":             call.Id,
+		"source":         call.Source,
+		"system":         call.System,
+		"systemLabel":    call.systemLabel,
+		"talkgroup":      call.Talkgroup,
+		"talkgroupGroup": call.talkgroupGroup,
+		"talkgroupLabel": call.talkgroupLabel,
+		"talkgroupName":  call.talkgroupName,
+		"talkgroupTag":   call.talkgroupTag,
+		"units":          units,
+	}
+}
+
 type Calls struct {
 	mutex sync.Mutex
 }
@@ -132,8 +238,13 @@ func (calls *Calls) CheckDuplicate(call *Call, msTimeFrame uint, db *Database) b
 	from := call.DateTime.Add(-d)
 	to := call.DateTime.Add(d)
 
-	query := fmt.Sprintf("select count(*) from `freeScannerCalls` where (`dateTime` between '%v' and '%v') and `system` = %v and `talkgroup` = %v", from, to, call.System, call.Talkgroup)
-	if err := db.Sql.QueryRow(query).Scan(&count); err != nil {
+	where := db.NewWhere().
+		Between("dateTime", from, to).
+		Equal("system", call.System).
+		Equal("talkgroup", call.Talkgroup)
+
+	query, args := NewSelectQuery("freeScannerCalls", "count(*)").Where(where).Build()
+	if err := db.Sql.QueryRow(query, args...).Scan(&count); err != nil {
 		return false
 	}
 
@@ -143,6 +254,7 @@ func (calls *Calls) CheckDuplicate(call *Call, msTimeFrame uint, db *Database) b
 func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 	var (
 		audioName   sql.NullString
+		audioPath   sql.NullString
 		audioType   sql.NullString
 		dateTime    any
 		frequency   sql.NullFloat64
@@ -151,6 +263,7 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		patches     string
 		sources     string
 		t           time.Time
+		transcript  sql.NullString
 	)
 
 	calls.mutex.Lock()
@@ -158,8 +271,10 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 
 	call := Call{Id: id}
 
-	query := fmt.Sprintf("select `audio`, `audioName`, `audioType`, `DateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup` from `freeScannerCalls` where `id` = %v", id)
-	err := db.Sql.QueryRow(query).Scan(&call.Audio, &audioName, &audioType, &dateTime, &frequencies, &frequency, &patches, &source, &sources, &call.System, &call.Talkgroup)
+	query, args := NewSelectQuery("freeScannerCalls", "audioName", "audioPath", "audioType", "dateTime", "frequencies", "frequency", "patches", "source", "sources", "system", "talkgroup", "transcript").
+		Where(db.NewWhere().Equal("id", id)).
+		Build()
+	err := db.Sql.QueryRow(query, args...).Scan(&audioName, &audioPath, &audioType, &dateTime, &frequencies, &frequency, &patches, &source, &sources, &call.System, &call.Talkgroup, &transcript)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("getcall: %v, %v", err, query)
 	}
@@ -168,6 +283,14 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		call.AudioName = audioName.String
 	}
 
+	if audioPath.Valid {
+		call.audioPath = audioPath.String
+
+		if err = call.ReadAudio(db); err != nil {
+			return nil, fmt.Errorf("getcall: %v", err)
+		}
+	}
+
 	if audioType.Valid {
 		call.AudioType = audioType.String
 	}
@@ -204,25 +327,140 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		}
 	}
 
+	if transcript.Valid && len(transcript.String) > 0 {
+		call.Transcript = transcript.String
+	}
+
 	return &call, nil
 }
 
-func (calls *Calls) Prune(db *Database, pruneDays uint) error {
+// Prune removes the calls older than pruneDays matching the where clause,
+// except the ones to keep forever, and returns how many were removed.
+func (calls *Calls) Prune(db *Database, pruneDays uint, filter *Where) (int64, error) {
+	var (
+		audioPath sql.NullString
+		err       error
+		res       sql.Result
+		rows      *sql.Rows
+	)
+
 	calls.mutex.Lock()
 	defer calls.mutex.Unlock()
 
 	date := time.Now().Add(-24 * time.Hour * time.Duration(pruneDays)).Format(db.DateTimeFormat)
-	_, err := db.Sql.Exec("delete from `freeScannerCalls` where `dateTime` < ?", date)
 
-	return err
+	where := db.NewWhere().Add("`dateTime` < ?", date).Equal("keep", false).AddWhere(filter)
+
+	query, args := NewSelectQuery("freeScannerCalls", "audioPath").Where(where).Build()
+	if rows, err = db.Sql.Query(query, args...); err != nil {
+		return 0, err
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(&audioPath); err != nil {
+			break
+		}
+
+		if audioPath.Valid && len(audioPath.String) > 0 {
+			if err = db.AudioStore.Delete(audioPath.String); err != nil {
+				break
+			}
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return 0, err
+	}
+
+	query, args = NewDeleteQuery("freeScannerCalls").Where(where).Build()
+	if res, err = db.Sql.Exec(query, args...); err != nil {
+		return 0, err
+	}
+
+	count, _ := res.RowsAffected()
+
+	if _, err = db.Sql.Exec(fmt.Sprintf("delete from `freeScannerCallsText` where %s not in (select `id` from `freeScannerCalls`)", getCallsTextId(db))); err != nil {
+		return count, err
+	}
+
+	return count, nil
 }
 
-func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*CallsSearchResults, error) {
-	const (
-		ascOrder  = "asc"
-		descOrder = "desc"
+// PruneOldest removes the oldest calls, except the ones to keep forever, until
+// about want bytes are freed or limit calls are removed, and returns how many
+// were removed and the bytes freed.
+func (calls *Calls) PruneOldest(db *Database, limit uint, want int64) (int64, int64, error) {
+	var (
+		audioPath sql.NullString
+		err       error
+		freed     int64
+		id        uint
+		ids       = []uint{}
+		res       sql.Result
+		rows      *sql.Rows
 	)
 
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	query, args := NewSelectQuery("freeScannerCalls", "id", "audioPath").
+		Where(db.NewWhere().Equal("keep", false)).
+		OrderBy("dateTime", QueryOrderAsc).
+		Limit(limit, 0).
+		Build()
+	if rows, err = db.Sql.Query(query, args...); err != nil {
+		return 0, 0, err
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(&id, &audioPath); err != nil {
+			break
+		}
+
+		ids = append(ids, id)
+
+		if audioPath.Valid && len(audioPath.String) > 0 {
+			if store, ok := db.AudioStore.(*LocalAudioStore); ok {
+				if fp, err := store.getPath(audioPath.String); err == nil {
+					if fi, err := os.Stat(fp); err == nil {
+						freed += fi.Size()
+					}
+				}
+			}
+
+			if err = db.AudioStore.Delete(audioPath.String); err != nil {
+				break
+			}
+		}
+
+		if freed >= want {
+			break
+		}
+	}
+
+	rows.Close()
+
+	if err != nil || len(ids) == 0 {
+		return 0, 0, err
+	}
+
+	query, args = NewDeleteQuery("freeScannerCalls").Where(db.NewWhere().In("id", ids)).Build()
+	if res, err = db.Sql.Exec(query, args...); err != nil {
+		return 0, 0, err
+	}
+
+	count, _ := res.RowsAffected()
+
+	if _, err = db.Sql.Exec(fmt.Sprintf("delete from `freeScannerCallsText` where %s not in (select `id` from `freeScannerCalls`)", getCallsTextId(db))); err != nil {
+		return count, freed, err
+	}
+
+	return count, freed, nil
+}
+
+func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*CallsSearchResults, error) {
 	var (
 		dateTime any
 		err      error
@@ -233,7 +471,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		query    string
 		rows     *sql.Rows
 		t        time.Time
-		where    string = "true"
+		terms    []string
 	)
 
 	calls.mutex.Lock()
@@ -250,80 +488,101 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		Results: []CallsSearchResult{},
 	}
 
+	where := db.NewWhere()
+
 	if client.Access != nil {
 		switch v := client.Access.Systems.(type) {
 		case []any:
-			a := []string{}
+			scopes := db.NewWhereOr()
 			for _, scope := range v {
-				var c string
 				switch v := scope.(type) {
 				case map[string]any:
-					switch v["talkgroups"].(type) {
+					systemId, ok := toUint(v["id"])
+					if !ok {
+						continue
+					}
+					switch tg := v["talkgroups"].(type) {
 					case []any:
-						b := strings.ReplaceAll(fmt.Sprintf("%v", v["talkgroups"]), " ", ", ")
-						b = strings.ReplaceAll(b, "[", "(")
-						b = strings.ReplaceAll(b, "]", ")")
-						c = fmt.Sprintf("(`system` = %v and `talkgroup` in %v)", v["id"], b)
+						talkgroupIds := []uint{}
+						for _, f := range tg {
+							if talkgroupId, ok := toUint(f); ok {
+								talkgroupIds = append(talkgroupIds, talkgroupId)
+							}
+						}
+						scopes.AddWhere(db.NewWhere().Equal("system", systemId).In("talkgroup", talkgroupIds))
 					case string:
-						if v["talkgroups"] == "*" {
-							c = fmt.Sprintf("`system` = %v", v["id"])
+						if tg == "*" {
+							scopes.Equal("system", systemId)
 						}
 					}
 				}
-				if len(c) > 0 {
-					a = append(a, c)
-				}
 			}
-			where = fmt.Sprintf("(%s)", strings.Join(a, " or "))
+			where.AddWhere(scopes)
 		}
 	}
 
 	switch v := searchOptions.System.(type) {
 	case uint:
-		a := []string{
-			fmt.Sprintf("`system` = %v", v),
-		}
+		where.Equal("system", v)
 		switch v := searchOptions.Talkgroup.(type) {
 		case uint:
 			if searchOptions.searchPatchedTalkgroups {
-				a = append(a, fmt.Sprintf("`talkgroup` = %v or patches = '%v' or patches like '[%v,%%' or patches like '%%,%v,%%' or patches like '%%,%v]'", v, v, v, v, v))
+				where.AddWhere(db.NewWhereOr().
+					Equal("talkgroup", v).
+					Equal("patches", fmt.Sprintf("[%v]", v)).
+					Like("patches", fmt.Sprintf("[%v,%%", v)).
+					Like("patches", fmt.Sprintf("%%,%v,%%", v)).
+					Like("patches", fmt.Sprintf("%%,%v]", v)))
 			} else {
-				a = append(a, fmt.Sprintf("`talkgroup` = %v", v))
+				where.Equal("talkgroup", v)
 			}
 		}
-		where += fmt.Sprintf(" and (%s)", strings.Join(a, " and "))
 	}
 
 	switch v := searchOptions.Group.(type) {
 	case string:
-		a := []string{}
-		for id, m := range client.GroupsMap[v] {
-			b := strings.ReplaceAll(fmt.Sprintf("%v", m), " ", ", ")
-			b = strings.ReplaceAll(b, "[", "(")
-			b = strings.ReplaceAll(b, "]", ")")
-			a = append(a, fmt.Sprintf("(`system` = %v and `talkgroup` in %v)", id, b))
-		}
-		if len(a) > 0 {
-			where += fmt.Sprintf(" and (%s)", strings.Join(a, " or "))
+		if m, ok := client.GroupsMap[v]; ok {
+			scopes := db.NewWhereOr()
+			for systemId, talkgroupIds := range m {
+				scopes.AddWhere(db.NewWhere().Equal("system", systemId).In("talkgroup", talkgroupIds))
+			}
+			where.AddWhere(scopes)
 		}
 	}
 
 	switch v := searchOptions.Tag.(type) {
 	case string:
-		a := []string{}
-		for id, m := range client.TagsMap[v] {
-			b := strings.ReplaceAll(fmt.Sprintf("%v", m), " ", ", ")
-			b = strings.ReplaceAll(b, "[", "(")
-			b = strings.ReplaceAll(b, "]", ")")
-			a = append(a, fmt.Sprintf("(`system` = %v and `talkgroup` in %v)", id, b))
+		if m, ok := client.TagsMap[v]; ok {
+			scopes := db.NewWhereOr()
+			for systemId, talkgroupIds := range m {
+				scopes.AddWhere(db.NewWhere().Equal("system", systemId).In("talkgroup", talkgroupIds))
+			}
+			where.AddWhere(scopes)
 		}
-		if len(a) > 0 {
-			where += fmt.Sprintf(" and (%s)", strings.Join(a, " or "))
+	}
+
+	switch v := searchOptions.Query.(type) {
+	case string:
+		if terms = getSearchTerms(v); len(terms) > 0 {
+			if db.Config.DbType == DbTypeSqlite {
+				match := make([]string, len(terms))
+				for i, term := range terms {
+					match[i] = fmt.Sprintf(`"%s"*`, term)
+				}
+				where.Add("`id` in (select `rowid` from `freeScannerCallsText` where `freeScannerCallsText` match ?)", strings.Join(match, " "))
+
+			} else {
+				match := make([]string, len(terms))
+				for i, term := range terms {
+					match[i] = fmt.Sprintf("+%s*", term)
+				}
+				where.Add("`id` in (select `callId` from `freeScannerCallsText` where match(`labels`, `transcript`) against (? in boolean mode))", strings.Join(match, " "))
+			}
 		}
 	}
 
-	query = fmt.Sprintf("select `dateTime` from `freeScannerCalls` where %v order by `dateTime` asc", where)
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	query, args := NewSelectQuery("freeScannerCalls", "dateTime").Where(where).OrderBy("dateTime", QueryOrderAsc).Limit(1, 0).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -331,8 +590,8 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		searchResults.DateStart = t
 	}
 
-	query = fmt.Sprintf("select `dateTime` from `freeScannerCalls` where %v order by `dateTime` desc", where)
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerCalls", "dateTime").Where(where).OrderBy("dateTime", QueryOrderDesc).Limit(1, 0).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -345,23 +604,23 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	switch v := searchOptions.Sort.(type) {
 	case int:
 		if v < 0 {
-			order = descOrder
+			order = QueryOrderDesc
 		} else {
-			order = ascOrder
+			order = QueryOrderAsc
 		}
 	default:
-		order = ascOrder
+		order = QueryOrderAsc
 	}
 
 	switch v := searchOptions.Date.(type) {
 	case time.Time:
 		var (
-			df    string = client.Controller.Database.DateTimeFormat
+			df    string = db.DateTimeFormat
 			start time.Time
 			stop  time.Time
 		)
 
-		if order == ascOrder {
+		if order == QueryOrderAsc {
 			start = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), 0, 0, time.UTC)
 			stop = start.Add(time.Hour*24 - time.Millisecond)
 
@@ -370,7 +629,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 			stop = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), 0, 0, time.UTC)
 		}
 
-		where += fmt.Sprintf(" and (`dateTime` between '%v' and '%v')", start.Format(df), stop.Format(df))
+		where.Between("dateTime", start.Format(df), stop.Format(df))
 	}
 
 	switch v := searchOptions.Limit.(type) {
@@ -385,13 +644,13 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		offset = v
 	}
 
-	query = fmt.Sprintf("select count(*) from `freeScannerCalls` where %v", where)
-	if err = db.Sql.QueryRow(query).Scan(&searchResults.Count); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerCalls", "count(*)").Where(where).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&searchResults.Count); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
-	query = fmt.Sprintf("select `id`, `DateTime`, `system`, `talkgroup` from `freeScannerCalls` where %v order by `dateTime` %v limit %v offset %v", where, order, limit, offset)
-	if rows, err = db.Sql.Query(query); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerCalls", "id", "dateTime", "system", "talkgroup").Where(where).OrderBy("dateTime", order).Limit(limit, offset).Build()
+	if rows, err = db.Sql.Query(query, args...); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -421,11 +680,68 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		return nil, formatError(err)
 	}
 
+	if len(terms) > 0 && len(searchResults.Results) > 0 {
+		var (
+			callId     uint
+			ids        = make([]uint, len(searchResults.Results))
+			labels     string
+			snippets   = map[uint]string{}
+			transcript string
+		)
+
+		for i, searchResult := range searchResults.Results {
+			ids[i] = searchResult.Id
+		}
+
+		query, args = NewSelectQuery("freeScannerCallsText", getCallsTextId(db), "labels", "transcript").Where(db.NewWhere().In(getCallsTextId(db), ids)).Build()
+		if rows, err = db.Sql.Query(query, args...); err != nil {
+			return nil, formatError(fmt.Errorf("%v, %v", err, query))
+		}
+
+		for rows.Next() {
+			if err = rows.Scan(&callId, &labels, &transcript); err != nil {
+				break
+			}
+
+			if snippet := getSearchSnippet(transcript, terms); len(snippet) > 0 {
+				snippets[callId] = snippet
+			} else {
+				snippets[callId] = getSearchSnippet(labels, terms)
+			}
+		}
+
+		rows.Close()
+
+		if err != nil {
+			return nil, formatError(err)
+		}
+
+		for i, searchResult := range searchResults.Results {
+			searchResults.Results[i].Snippet = snippets[searchResult.Id]
+		}
+	}
+
 	return searchResults, err
 }
 
+// SetKeep flags the call to be kept forever, whatever the retention rules.
+func (calls *Calls) SetKeep(db *Database, id uint, keep bool) (bool, error) {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	res, err := db.Sql.Exec("update `freeScannerCalls` set `keep` = ? where `id` = ?", keep, id)
+	if err != nil {
+		return false, fmt.Errorf("calls.setkeep: %v", err)
+	}
+
+	count, _ := res.RowsAffected()
+
+	return count > 0, nil
+}
+
 func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 	var (
+		audioPath   string
 		b           []byte
 		err         error
 		frequencies string
@@ -469,10 +785,17 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 		}
 	}
 
-	if res, err = db.Sql.Exec("insert into `freeScannerCalls` (`id`, `audio`, `audioName`, `audioType`, `dateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", call.Id, call.Audio, call.AudioName, call.AudioType, call.DateTime, frequencies, call.Frequency, patches, call.Source, sources, call.System, call.Talkgroup); err != nil {
+	if audioPath, err = db.AudioStore.Write(call); err != nil {
+		return 0, formatError(err)
+	}
+
+	if res, err = db.Sql.Exec("insert into `freeScannerCalls` (`id`, `audioName`, `audioPath`, `audioType`, `dateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup`, `transcript`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", call.Id, call.AudioName, audioPath, call.AudioType, call.DateTime, frequencies, call.Frequency, patches, call.Source, sources, call.System, call.Talkgroup, call.Transcript); err != nil {
+		db.AudioStore.Delete(audioPath)
 		return 0, formatError(err)
 	}
 
+	call.audioPath = audioPath
+
 	if id, err = res.LastInsertId(); err == nil {
 		return uint(id), nil
 	} else {
@@ -480,11 +803,92 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 	}
 }
 
+// WriteText indexes the labels of the call for the full-text search.
+func (calls *Calls) WriteText(call *Call, system *System, db *Database) error {
+	var (
+		labels     = []string{}
+		transcript string
+		unitIds    = []uint{}
+	)
+
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	for _, label := range []any{call.systemLabel, call.talkgroupLabel, call.talkgroupName} {
+		switch v := label.(type) {
+		case string:
+			labels = append(labels, v)
+		}
+	}
+
+	if id, ok := toUint(call.Source); ok {
+		unitIds = append(unitIds, id)
+	}
+
+	switch v := call.Sources.(type) {
+	case []map[string]any:
+		for _, source := range v {
+			if id, ok := toUint(source["src"]); ok {
+				unitIds = append(unitIds, id)
+			}
+		}
+	}
+
+	for _, unit := range system.Units.List {
+		for _, id := range unitIds {
+			if unit.Id == id && len(unit.Label) > 0 {
+				labels = append(labels, unit.Label)
+				break
+			}
+		}
+	}
+
+	switch v := call.Transcript.(type) {
+	case string:
+		transcript = v
+	}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("calls.writetext: %v", err)
+	}
+
+	// a stale row may remain when the id of a deleted call is reused
+	if _, err := db.Sql.Exec(fmt.Sprintf("delete from `freeScannerCallsText` where %s = ?", getCallsTextId(db)), call.Id); err != nil {
+		return formatError(err)
+	}
+
+	if _, err := db.Sql.Exec(fmt.Sprintf("insert into `freeScannerCallsText` (%s, `labels`, `transcript`) values (?, ?, ?)", getCallsTextId(db)), call.Id, strings.Join(labels, " "), transcript); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (calls *Calls) WriteTranscript(call *Call, db *Database) error {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("calls.writetranscript: %v", err)
+	}
+
+	if _, err := db.Sql.Exec("update `freeScannerCalls` set `transcript` = ? where `id` = ?", call.Transcript, call.Id); err != nil {
+		return formatError(err)
+	}
+
+	if _, err := db.Sql.Exec(fmt.Sprintf("update `freeScannerCallsText` set `transcript` = ? where %s = ?", getCallsTextId(db)), call.Transcript, call.Id); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
 type CallsSearchOptions struct {
 	Date                    any `json:"date,omitempty"`
 	Group                   any `json:"group,omitempty"`
 	Limit                   any `json:"limit,omitempty"`
 	Offset                  any `json:"offset,omitempty"`
+	Query                   any `json:"query,omitempty"`
 	Sort                    any `json:"sort,omitempty"`
 	System                  any `json:"system,omitempty"`
 	Tag                     any `json:"tag,omitempty"`
@@ -515,6 +919,13 @@ func (searchOptions *CallsSearchOptions) fromMap(m map[string]any) error {
 		searchOptions.Offset = uint(v)
 	}
 
+	switch v := m["query"].(type) {
+	case string:
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			searchOptions.Query = v
+		}
+	}
+
 	switch v := m["sort"].(type) {
 	case float64:
 		searchOptions.Sort = int(v)
@@ -541,6 +952,7 @@ func (searchOptions *CallsSearchOptions) fromMap(m map[string]any) error {
 type CallsSearchResult struct {
 	Id        uint      `json:"id"`
 	DateTime  time.Time `json:"dateTime"`
+	Snippet   string    `json:"snippet,omitempty"`
 	System    uint      `json:"system"`
 	Talkgroup uint      `json:"talkgroup"`
 }
@@ -552,3 +964,85 @@ type CallsSearchResults struct {
 	Options   *CallsSearchOptions `json:"options"`
 	Results   []CallsSearchResult `json:"results"`
 }
+
+// getCallsTextId returns the column of freeScannerCallsText holding the call
+// id, the sqlite fts5 table uses its rowid.
+func getCallsTextId(db *Database) string {
+	if db.Config.DbType == DbTypeSqlite {
+		return "rowid"
+	}
+	return "callId"
+}
+
+// getSearchSnippet returns an html excerpt of text around the first word
+// matching one of the terms, with the matching words highlighted.
+func getSearchSnippet(text string, terms []string) string {
+	const radius = 8
+
+	var (
+		first   = -1
+		matches = map[int]bool{}
+		sb      = strings.Builder{}
+	)
+
+	words := searchWordRegexp.FindAllStringIndex(text, -1)
+
+	for i, w := range words {
+		word := strings.ToLower(text[w[0]:w[1]])
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matches[i] = true
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+
+	if first < 0 {
+		return ""
+	}
+
+	from := int(math.Max(0, float64(first-radius)))
+	to := int(math.Min(float64(len(words)-1), float64(first+radius)))
+
+	if from > 0 {
+		sb.WriteString("… ")
+	}
+
+	pos := words[from][0]
+	for i := from; i <= to; i++ {
+		w := words[i]
+		sb.WriteString(html.EscapeString(text[pos:w[0]]))
+		if matches[i] {
+			sb.WriteString("<mark>" + html.EscapeString(text[w[0]:w[1]]) + "</mark>")
+		} else {
+			sb.WriteString(html.EscapeString(text[w[0]:w[1]]))
+		}
+		pos = w[1]
+	}
+
+	if to < len(words)-1 {
+		sb.WriteString(" …")
+	}
+
+	return sb.String()
+}
+
+// getSearchTerms splits a free-text query into lowercase words, anything else
+// than letters and digits is dropped so that it cannot alter the match syntax.
+func getSearchTerms(query string) []string {
+	const maxTerms = 8
+
+	terms := []string{}
+
+	for _, term := range searchWordRegexp.FindAllString(strings.ToLower(query), -1) {
+		if len(terms) == maxTerms {
+			break
+		}
+		terms = append(terms, term)
+	}
+
+	return terms
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallsPruneFilter(t *testing.T) {
+	db := newTestDatabase(t)
+	calls := NewCalls()
+
+	ids := newTestCalls(t, db, time.Now().Add(-48*time.Hour))
+
+	if _, err := calls.SetKeep(db, ids[2], true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter *Where
+		count  int64
+	}{
+		{name: "empty or", filter: db.NewWhereOr(), count: 0},
+		{name: "hostile value", filter: db.NewWhere().Equal("system", "1' or '1'='1"), count: 0},
+		{name: "hostile talkgroup", filter: db.NewWhere().Equal("system", uint(1)).Equal("talkgroup", "100 or 1=1"), count: 0},
+		{name: "retention rule", filter: (&RetentionRule{Talkgroups: map[uint][]uint{1: {100}}}).GetWhere(db), count: 1},
+		{name: "default rule", filter: RetentionRules{{Talkgroups: map[uint][]uint{1: {101}}}}.GetDefaultWhere(db), count: 0},
+		{name: "no filter", filter: nil, count: 1},
+	}
+
+	for _, test := range tests {
+		count, err := calls.Prune(db, 1, test.filter)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if count != test.count {
+			t.Errorf("%s: got %d calls removed, want %d", test.name, count, test.count)
+		}
+	}
+
+	var left uint
+	if err := db.Sql.QueryRow("select count(*) from `freeScannerCalls`").Scan(&left); err != nil || left != 1 {
+		t.Errorf("got %d calls left, %v", left, err)
+	}
+}
+
+func TestCallsSearchHostile(t *testing.T) {
+	db := newTestDatabase(t)
+	calls := NewCalls()
+
+	newTestCalls(t, db, time.Now().Add(-time.Hour))
+
+	newClient := func(systems any) *Client {
+		return &Client{
+			Access:     &Access{Systems: systems},
+			Controller: &Controller{Database: db},
+			GroupsMap:  GroupsMap{"Fire": {1: {100, 101}}},
+			TagsMap:    TagsMap{"x' or '1'='1": {1: {100}}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		client  *Client
+		options *CallsSearchOptions
+		count   uint
+	}{
+		{name: "all", client: newClient("*"), options: &CallsSearchOptions{}, count: 3},
+		{name: "scoped", client: newClient([]any{map[string]any{"id": float64(1), "talkgroups": "*"}}), options: &CallsSearchOptions{}, count: 2},
+		{name: "hostile scope id", client: newClient([]any{map[string]any{"id": "1 or 1=1", "talkgroups": "*"}}), options: &CallsSearchOptions{}, count: 0},
+		{name: "hostile scope talkgroups", client: newClient([]any{map[string]any{"id": float64(1), "talkgroups": []any{"100) or (1=1"}}}), options: &CallsSearchOptions{}, count: 0},
+		{name: "hostile scope wildcard", client: newClient([]any{map[string]any{"id": float64(1), "talkgroups": "*' or '1'='1"}}), options: &CallsSearchOptions{}, count: 0},
+		{name: "empty scope", client: newClient([]any{}), options: &CallsSearchOptions{}, count: 0},
+		{name: "hostile tag", client: newClient("*"), options: &CallsSearchOptions{Tag: "x' or '1'='1"}, count: 1},
+		{name: "unknown hostile group", client: newClient("*"), options: &CallsSearchOptions{Group: "Fire' or '1'='1"}, count: 3},
+		{name: "group within scope", client: newClient([]any{map[string]any{"id": float64(1), "talkgroups": []any{float64(101)}}}), options: &CallsSearchOptions{Group: "Fire"}, count: 1},
+		{name: "hostile label", client: newClient("*"), options: &CallsSearchOptions{Query: `dispatch' or '1'='1`}, count: 0},
+		{name: "hostile match syntax", client: newClient("*"), options: &CallsSearchOptions{Query: `fire" OR police* NEAR(`}, count: 0},
+		{name: "label", client: newClient("*"), options: &CallsSearchOptions{Query: "Fire"}, count: 2},
+	}
+
+	for _, test := range tests {
+		results, err := calls.Search(test.options, test.client)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if results.Count != test.count || len(results.Results) != int(test.count) {
+			t.Errorf("%s: got %d calls, want %d", test.name, results.Count, test.count)
+		}
+	}
+}
+
+// newTestCalls writes three calls labeled for the full-text search, two of
+// system 1 and one of system 2, and returns their ids.
+func newTestCalls(t *testing.T, db *Database, dateTime time.Time) []uint {
+	t.Helper()
+
+	calls := NewCalls()
+	ids := []uint{}
+
+	for _, c := range []struct {
+		system    uint
+		talkgroup uint
+		label     string
+	}{
+		{system: 1, talkgroup: 100, label: "Fire Dispatch"},
+		{system: 1, talkgroup: 101, label: "Fire Tactical"},
+		{system: 2, talkgroup: 200, label: "Police Dispatch"},
+	} {
+		call := &Call{
+			Audio:          []byte("audio"),
+			AudioName:      "call.m4a",
+			AudioType:      "audio/mp4",
+			DateTime:       dateTime,
+			System:         c.system,
+			Talkgroup:      c.talkgroup,
+			talkgroupLabel: c.label,
+		}
+
+		id, err := calls.WriteCall(call, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		call.Id = id
+
+		if err = calls.WriteText(call, &System{Units: NewUnits()}, db); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
@@ -16,6 +16,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -26,18 +27,26 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const (
+	AudioEncodingBase64 = "base64"
+	AudioEncodingBinary = "binary"
+	AudioEncodingBuffer = "buffer"
+)
+
 type Client struct {
-	Access     *Access
-	AuthCount  int
-	Controller *Controller
-	Conn       *websocket.Conn
-	Send       chan *Message
-	Systems    []System
-	GroupsMap  GroupsMap
-	TagsMap    TagsMap
-	Livefeed   *Livefeed
-	SystemsMap SystemsMap
-	request    *http.Request
+	Access        *Access
+	AuthCount     int
+	Controller    *Controller
+	Conn          *websocket.Conn
+	Send          chan *Message
+	Systems       []System
+	GroupsMap     GroupsMap
+	TagsMap       TagsMap
+	Livefeed      *Livefeed
+	SystemsMap    SystemsMap
+	audioEncoding string
+	mutex         sync.Mutex
+	request       *http.Request
 }
 
 func (client *Client) Init(controller *Controller, request *http.Request, conn *websocket.Conn) error {
@@ -57,6 +66,7 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 	}
 
 	client.Access = &Access{}
+	client.audioEncoding = AudioEncodingBuffer
 	client.Controller = controller
 	client.Conn = conn
 	client.Livefeed = NewLivefeed()
@@ -143,7 +153,7 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 					}
 				}
 
-				b, err := message.ToJson()
+				b, audio, err := client.encodeMessage(message)
 				if err != nil {
 					log.Println(fmt.Errorf("client.message.tojson: %v", err))
 
@@ -153,6 +163,13 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 					if err = client.Conn.WriteMessage(websocket.TextMessage, b); err != nil {
 						return
 					}
+
+					// the audio frame always follows the call message it belongs to
+					if audio != nil {
+						if err = client.Conn.WriteMessage(websocket.BinaryMessage, audio); err != nil {
+							return
+						}
+					}
 				}
 
 			case <-ticker.C:
@@ -168,6 +185,47 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 	return nil
 }
 
+// encodeMessage returns the json of the message and, with the binary audio
+// encoding, the call audio to send as a binary frame.
+func (client *Client) encodeMessage(message *Message) ([]byte, []byte, error) {
+	switch call := message.Payload.(type) {
+	case *Call:
+		encoding := client.GetAudioEncoding()
+
+		b, err := call.MarshalJSONWithAudioEncoding(encoding)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		m := &Message{Command: message.Command, Payload: json.RawMessage(b), Flag: message.Flag}
+		if b, err = m.ToJson(); err != nil {
+			return nil, nil, err
+		}
+
+		if encoding == AudioEncodingBinary {
+			if call.Audio == nil {
+				return b, []byte{}, nil
+			}
+			return b, call.Audio, nil
+		}
+
+		return b, nil, nil
+
+	default:
+		b, err := message.ToJson()
+		return b, nil, err
+	}
+}
+
+// GetAudioEncoding returns the audio encoding negotiated by the client, which
+// the reader goroutine may change while the writer goroutine encodes calls.
+func (client *Client) GetAudioEncoding() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.audioEncoding
+}
+
 func (client *Client) GetRemoteAddr() string {
 	return GetRemoteAddr(client.request)
 }
@@ -206,6 +264,13 @@ func (client *Client) SendListenersCount(count int) {
 	}
 }
 
+func (client *Client) SetAudioEncoding(encoding string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.audioEncoding = encoding
+}
+
 type Clients struct {
 	Map   map[*Client]bool
 	mutex sync.Mutex
@@ -273,6 +338,16 @@ func (clients *Clients) EmitListenersCount() {
 	}
 }
 
+func (clients *Clients) EmitTranscript(call *Call, restricted bool) {
+	payload := map[string]any{"id": call.Id, "transcript": call.Transcript}
+
+	for c := range clients.Map {
+		if !restricted || c.Access.HasAccess(call) {
+			c.Send <- &Message{Command: MessageCommandTranscript, Payload: payload}
+		}
+	}
+}
+
 func (clients *Clients) Remove(client *Client) {
 	clients.mutex.Lock()
 	defer clients.mutex.Unlock()
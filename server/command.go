@@ -32,25 +32,39 @@ import (
 )
 
 const (
-	COMMAND_ARG            = "cmd"
-	COMMAND_ARG_CODE       = "+code"
-	COMMAND_ARG_EXPIRATION = "+expiration"
-	COMMAND_ARG_IDENT      = "+ident"
-	COMMAND_ARG_IN         = "+in"
-	COMMAND_ARG_LIMIT      = "+limit"
-	COMMAND_ARG_OUT        = "+out"
-	COMMAND_ARG_PASSWORD   = "+password"
-	COMMAND_ARG_SYSTEMS    = "+systems"
-	COMMAND_ARG_TOKEN      = "+token"
-	COMMAND_ARG_URL        = "+url"
-	COMMAND_ADMIN_PASSWORD = "admin-password"
-	COMMAND_CONFIG_GET     = "config-get"
-	COMMAND_CONFIG_SET     = "config-set"
-	COMMAND_HELP           = "help"
-	COMMAND_LOGIN          = "login"
-	COMMAND_LOGOUT         = "logout"
-	COMMAND_USER_ADD       = "user-add"
-	COMMAND_USER_REMOVE    = "user-remove"
+	COMMAND_ARG             = "cmd"
+	COMMAND_ARG_CODE        = "+code"
+	COMMAND_ARG_EXPIRATION  = "+expiration"
+	COMMAND_ARG_IDENT       = "+ident"
+	COMMAND_ARG_IN          = "+in"
+	COMMAND_ARG_LIMIT       = "+limit"
+	COMMAND_ARG_OUT         = "+out"
+	COMMAND_ARG_PASSWORD    = "+password"
+	COMMAND_ARG_ROLE        = "+role"
+	COMMAND_ARG_SESSION     = "+session"
+	COMMAND_ARG_SYSTEMS     = "+systems"
+	COMMAND_ARG_TOKEN       = "+token"
+	COMMAND_ARG_URL         = "+url"
+	COMMAND_ARG_USERNAME    = "+username"
+	COMMAND_ARG_VERSION     = "+version"
+	COMMAND_ADMIN_PASSWORD  = "admin-password"
+	COMMAND_ADMIN_ADD       = "admin-user-add"
+	COMMAND_ADMIN_REMOVE    = "admin-user-remove"
+	COMMAND_ADMIN_REVOKE    = "admin-session-revoke"
+	COMMAND_ADMIN_SESSIONS  = "admin-sessions"
+	COMMAND_ADMIN_TOTP_OFF  = "admin-totp-disable"
+	COMMAND_ADMIN_TOTP_ON   = "admin-totp-enable"
+	COMMAND_ADMIN_TOTP_RST  = "admin-totp-reset"
+	COMMAND_ADMIN_TOTP_SET  = "admin-totp-setup"
+	COMMAND_CONFIG_GET      = "config-get"
+	COMMAND_CONFIG_ROLLBACK = "config-rollback"
+	COMMAND_CONFIG_SET      = "config-set"
+	COMMAND_CONFIG_VERSIONS = "config-versions"
+	COMMAND_HELP            = "help"
+	COMMAND_LOGIN           = "login"
+	COMMAND_LOGOUT          = "logout"
+	COMMAND_USER_ADD        = "user-add"
+	COMMAND_USER_REMOVE     = "user-remove"
 
 	COMMAND_DEF_PASSWORD = "freescanner"
 	COMMAND_DEF_URL      = "http://localhost:3000/"
@@ -60,19 +74,25 @@ type Command struct {
 	app        string
 	code       string
 	command    string
+	config     *Config
 	expiration string
 	ident      string
 	in         string
 	limit      string
 	out        string
 	password   string
+	passwordIn bool
+	role       string
+	session    string
 	systems    string
 	token      string
 	tokenFile  string
 	url        string
+	username   string
+	version    string
 }
 
-func NewCommand(baseDir string) *Command {
+func NewCommand(config *Config) *Command {
 	app, _ := os.Executable()
 	pass := os.Getenv("FREESCANNER_ADMIN_PASSWORD")
 
@@ -83,9 +103,11 @@ func NewCommand(baseDir string) *Command {
 	return &Command{
 		app:       filepath.Base(app),
 		command:   COMMAND_HELP,
+		config:    config,
 		password:  pass,
-		tokenFile: baseDir + filepath.Base(app) + ".token",
+		tokenFile: config.BaseDir + filepath.Base(app) + ".token",
 		url:       COMMAND_DEF_URL,
+		username:  os.Getenv("FREESCANNER_ADMIN_USERNAME"),
 	}
 }
 
@@ -127,6 +149,13 @@ func (command *Command) Do(action string) {
 
 		case COMMAND_ARG_PASSWORD:
 			command.password = readVal()
+			command.passwordIn = true
+
+		case COMMAND_ARG_ROLE:
+			command.role = readVal()
+
+		case COMMAND_ARG_SESSION:
+			command.session = readVal()
 
 		case COMMAND_ARG_SYSTEMS:
 			command.systems = readVal()
@@ -140,6 +169,12 @@ func (command *Command) Do(action string) {
 			if err != nil || !regexp.MustCompile(`^https?://`).Match([]byte(command.url)) {
 				command.exitWithError(errors.New("invalid URL"))
 			}
+
+		case COMMAND_ARG_USERNAME:
+			command.username = readVal()
+
+		case COMMAND_ARG_VERSION:
+			command.version = readVal()
 		}
 
 		i++
@@ -157,9 +192,15 @@ func (command *Command) Do(action string) {
 	case COMMAND_CONFIG_GET:
 		command.configGet()
 
+	case COMMAND_CONFIG_ROLLBACK:
+		command.configRollback()
+
 	case COMMAND_CONFIG_SET:
 		command.configSet()
 
+	case COMMAND_CONFIG_VERSIONS:
+		command.configVersions()
+
 	case COMMAND_LOGIN:
 		command.login()
 
@@ -169,6 +210,30 @@ func (command *Command) Do(action string) {
 	case COMMAND_ADMIN_PASSWORD:
 		command.adminPassword()
 
+	case COMMAND_ADMIN_ADD:
+		command.adminUserAdd()
+
+	case COMMAND_ADMIN_REMOVE:
+		command.adminUserRemove()
+
+	case COMMAND_ADMIN_REVOKE:
+		command.adminSessionRevoke()
+
+	case COMMAND_ADMIN_SESSIONS:
+		command.adminSessions()
+
+	case COMMAND_ADMIN_TOTP_OFF:
+		command.adminTotpDisable()
+
+	case COMMAND_ADMIN_TOTP_ON:
+		command.adminTotpEnable()
+
+	case COMMAND_ADMIN_TOTP_RST:
+		command.adminTotpReset()
+
+	case COMMAND_ADMIN_TOTP_SET:
+		command.adminTotpSetup()
+
 	case COMMAND_USER_ADD:
 		command.userAdd()
 
@@ -195,15 +260,39 @@ func (command *Command) printUsage() {
 	fmt.Printf("\nAvailable Commands:\n\n")
 	fmt.Printf("  %-11s – Change administrator password.\n\n", COMMAND_ADMIN_PASSWORD)
 	fmt.Printf("    %-11s %s%s -%s %s %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_PASSWORD, COMMAND_ARG_PASSWORD)
+	fmt.Printf("  %-11s – List the active administrator sessions.\n\n", COMMAND_ADMIN_SESSIONS)
+	fmt.Printf("    %-11s %s%s -%s %s\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_SESSIONS)
+	fmt.Printf("  %-11s – Revoke an administrator session, or all the sessions of an administrator.\n\n", COMMAND_ADMIN_REVOKE)
+	fmt.Printf("    %-11s %s%s -%s %s %s <id>\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_REVOKE, COMMAND_ARG_SESSION)
+	fmt.Printf("    %-11s %s%s -%s %s %s <username>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_REVOKE, COMMAND_ARG_USERNAME)
+	fmt.Printf("  %-11s – Generate a two-factor authentication secret for the logged in administrator.\n\n", COMMAND_ADMIN_TOTP_SET)
+	fmt.Printf("    %-11s %s%s -%s %s %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_TOTP_SET, COMMAND_ARG_PASSWORD)
+	fmt.Printf("  %-11s – Turn on two-factor authentication with a code of the authenticator app.\n\n", COMMAND_ADMIN_TOTP_ON)
+	fmt.Printf("    %-11s %s%s -%s %s %s <code>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_TOTP_ON, COMMAND_ARG_CODE)
+	fmt.Printf("  %-11s – Turn off two-factor authentication for the logged in administrator.\n\n", COMMAND_ADMIN_TOTP_OFF)
+	fmt.Printf("    %-11s %s%s -%s %s %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_TOTP_OFF, COMMAND_ARG_PASSWORD)
+	fmt.Printf("  %-11s – Turn off two-factor authentication of an administrator, run on the server host.\n\n", COMMAND_ADMIN_TOTP_RST)
+	fmt.Printf("    %-11s %s%s -%s %s %s <username>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_TOTP_RST, COMMAND_ARG_USERNAME)
+	fmt.Printf("  %-11s – Add or update an administrator, owners only.\n\n", COMMAND_ADMIN_ADD)
+	fmt.Printf("    %-11s %s%s -%s %s %s <username> %s <password> %s <role>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_ADD, COMMAND_ARG_USERNAME, COMMAND_ARG_PASSWORD, COMMAND_ARG_ROLE)
+	fmt.Printf("    %-11s Roles are %s, %s, %s and %s.\n\n", "", AdminRoleOwner, AdminRoleEditor, AdminRoleReadOnly, AdminRoleLogViewer)
+	fmt.Printf("  %-11s – Remove an administrator, owners only.\n\n", COMMAND_ADMIN_REMOVE)
+	fmt.Printf("    %-11s %s%s -%s %s %s <username>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_REMOVE, COMMAND_ARG_USERNAME)
 	fmt.Printf("  %-11s – Retrieve server's configuration.\n\n", COMMAND_CONFIG_GET)
 	fmt.Printf("    %-11s %s%s -%s %s %s <file.json>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_GET, COMMAND_ARG_OUT)
+	fmt.Printf("  %-11s – Roll back server's configuration to an earlier version.\n\n", COMMAND_CONFIG_ROLLBACK)
+	fmt.Printf("    %-11s %s%s -%s %s %s <id>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_ROLLBACK, COMMAND_ARG_VERSION)
 	fmt.Printf("  %-11s – Set server's configuration.\n\n", COMMAND_CONFIG_SET)
 	fmt.Printf("    %-11s %s%s -%s %s %s <file.json>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_SET, COMMAND_ARG_IN)
+	fmt.Printf("  %-11s – List server's configuration versions, or show the changes of one.\n\n", COMMAND_CONFIG_VERSIONS)
+	fmt.Printf("    %-11s %s%s -%s %s\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_VERSIONS)
+	fmt.Printf("    %-11s %s%s -%s %s %s <id>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_VERSIONS, COMMAND_ARG_VERSION)
 	fmt.Printf("  %-11s – Login to server.\n\n", COMMAND_LOGIN)
 	if runtime.GOOS != "windows" {
-		fmt.Printf("    %-11s $ FREESCANNER_ADMIN_PASSWORD=<password> ./%s -%s %s\n", "", command.app, COMMAND_ARG, COMMAND_LOGIN)
+		fmt.Printf("    %-11s $ FREESCANNER_ADMIN_USERNAME=<username> FREESCANNER_ADMIN_PASSWORD=<password> ./%s -%s %s\n", "", command.app, COMMAND_ARG, COMMAND_LOGIN)
 	}
-	fmt.Printf("    %-11s %s%s -%s %s %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_LOGIN, COMMAND_ARG_PASSWORD)
+	fmt.Printf("    %-11s %s%s -%s %s %s <username> %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_LOGIN, COMMAND_ARG_USERNAME, COMMAND_ARG_PASSWORD)
+	fmt.Printf("    %-11s The username defaults to %s. Add %s <code> when two-factor authentication is on.\n\n", "", defaults.adminUsername, COMMAND_ARG_CODE)
 	fmt.Printf("  %-11s – Logout from server.\n\n", COMMAND_LOGOUT)
 	fmt.Printf("    %-11s %s%s -%s %s\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_LOGOUT)
 	fmt.Printf("  %-11s – Add a user access.\n\n", COMMAND_USER_ADD)
@@ -237,6 +326,228 @@ func (command *Command) adminPassword() {
 	}
 }
 
+func (command *Command) adminSessionRevoke() {
+	var m map[string]any
+
+	if command.session != "" {
+		if id, err := strconv.Atoi(command.session); err == nil {
+			m = map[string]any{"id": id}
+		} else {
+			command.exitWithError(fmt.Sprintf("Invalid number for %s", COMMAND_ARG_SESSION))
+		}
+	} else if command.username != "" {
+		m = map[string]any{"username": command.username}
+	} else {
+		command.exitWithError(fmt.Sprintf("Missing %s <id> or %s <username> arguments.", COMMAND_ARG_SESSION, COMMAND_ARG_USERNAME))
+	}
+
+	if body, err := command.writeBody(m); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/session-revoke", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				fmt.Println("Session revoked.")
+			} else {
+				command.exitWithError(errors.New(res.Status))
+			}
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+func (command *Command) adminSessions() {
+	if res, err := command.submit(http.MethodGet, "/api/admin/sessions", nil, true); err == nil {
+		if res.StatusCode == http.StatusOK {
+			if data, err := command.readBody(res.Body); err == nil {
+				switch v := data.(type) {
+				case []any:
+					for _, f := range v {
+						switch v := f.(type) {
+						case map[string]any:
+							current := ""
+							if v["current"] == true {
+								current = "*"
+							}
+							fmt.Printf("%6v%1s %-12v %-16v last seen %v  expires %v  %v\n", v["_id"], current, v["username"], v["ip"], v["dateLastSeen"], v["dateExpires"], v["userAgent"])
+						}
+					}
+				default:
+					command.exitWithError(errors.New("invalid response"))
+				}
+			} else {
+				command.exitWithError(err)
+			}
+		} else {
+			command.exitWithError(errors.New(res.Status))
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+func (command *Command) adminTotpDisable() {
+	if !command.passwordIn {
+		command.exitWithError(fmt.Sprintf("Missing %s <password> arguments.", COMMAND_ARG_PASSWORD))
+	}
+
+	if body, err := command.writeBody(map[string]any{"password": command.password}); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/totp-disable", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				fmt.Println("Two-factor authentication disabled.")
+			} else {
+				command.exitWithError(res.Status)
+			}
+		} else {
+			command.exitWithError(err)
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+func (command *Command) adminTotpEnable() {
+	if command.code == "" {
+		command.exitWithError(fmt.Sprintf("Missing %s <code> arguments.", COMMAND_ARG_CODE))
+	}
+
+	if body, err := command.writeBody(map[string]any{"code": command.code}); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/totp-enable", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				if data, err := command.readBody(res.Body); err == nil {
+					fmt.Println("Two-factor authentication enabled. Keep these recovery codes safe, each one can be used once in place of a code:")
+					switch v := data.(type) {
+					case map[string]any:
+						switch codes := v["recoveryCodes"].(type) {
+						case []any:
+							for _, code := range codes {
+								fmt.Printf("  %s\n", command.getString(code))
+							}
+						}
+					}
+				} else {
+					command.exitWithError(err)
+				}
+			} else {
+				command.exitWithError(res.Status)
+			}
+		} else {
+			command.exitWithError(err)
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+// adminTotpReset turns off the two-factor authentication of an admin user
+// straight in the database, for when the authenticator and the recovery codes
+// are lost.
+func (command *Command) adminTotpReset() {
+	if command.username == "" {
+		command.exitWithError(fmt.Sprintf("Missing %s <username> arguments.", COMMAND_ARG_USERNAME))
+	}
+
+	db := NewDatabase(command.config)
+	defer db.Sql.Close()
+
+	users := NewAdminUsers()
+	if err := users.Read(db); err != nil {
+		command.exitWithError(err)
+	}
+
+	user, ok := users.GetUser(command.username)
+	if !ok {
+		command.exitWithError(fmt.Sprintf("No admin user %s.", command.username))
+	}
+
+	user.ResetTotp()
+
+	if err := users.Write(db); err != nil {
+		command.exitWithError(err)
+	}
+
+	logs := NewLogs()
+	logs.setDatabase(db)
+	logs.LogEvent(LogLevelWarn, fmt.Sprintf("two-factor authentication reset for %s from the command line", user.Username))
+
+	fmt.Printf("Two-factor authentication reset for %s.\n", user.Username)
+}
+
+func (command *Command) adminTotpSetup() {
+	if !command.passwordIn {
+		command.exitWithError(fmt.Sprintf("Missing %s <password> arguments.", COMMAND_ARG_PASSWORD))
+	}
+
+	if body, err := command.writeBody(map[string]any{"password": command.password}); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/totp-setup", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				if data, err := command.readBody(res.Body); err == nil {
+					switch v := data.(type) {
+					case map[string]any:
+						fmt.Printf("Secret: %s\n", command.getString(v["secret"]))
+						fmt.Printf("URI:    %s\n", command.getString(v["uri"]))
+						fmt.Printf("Add it to your authenticator app, then run %s with %s <code>.\n", COMMAND_ADMIN_TOTP_ON, COMMAND_ARG_CODE)
+					default:
+						command.exitWithError(errors.New("invalid response"))
+					}
+				} else {
+					command.exitWithError(err)
+				}
+			} else {
+				command.exitWithError(res.Status)
+			}
+		} else {
+			command.exitWithError(err)
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+func (command *Command) adminUserAdd() {
+	if command.username == "" {
+		command.exitWithError(fmt.Sprintf("Missing %s <username> arguments.", COMMAND_ARG_USERNAME))
+	}
+
+	u := map[string]any{"username": command.username}
+
+	if command.passwordIn && command.password != "" {
+		u["password"] = command.password
+	}
+
+	if command.role != "" {
+		u["role"] = command.role
+	}
+
+	if body, err := command.writeBody(u); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/admin-user-add", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				fmt.Printf("Administrator %s saved.\n", command.username)
+			} else {
+				command.exitWithError(errors.New(res.Status))
+			}
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
+func (command *Command) adminUserRemove() {
+	if command.username == "" {
+		command.exitWithError(fmt.Sprintf("Missing %s <username> arguments.", COMMAND_ARG_USERNAME))
+	}
+
+	if body, err := command.writeBody(map[string]any{"username": command.username}); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/admin-user-remove", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				fmt.Printf("Administrator %s removed.\n", command.username)
+			} else {
+				command.exitWithError(errors.New(res.Status))
+			}
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
 func (command *Command) configGet() {
 	if command.out == "" {
 		command.exitWithError(fmt.Sprintf("Missing %s <file.json> arguments.", COMMAND_ARG_OUT))
@@ -270,6 +581,29 @@ func (command *Command) configGet() {
 	}
 }
 
+func (command *Command) configRollback() {
+	if command.version == "" {
+		command.exitWithError(fmt.Sprintf("Missing %s <id> arguments.", COMMAND_ARG_VERSION))
+	}
+
+	id, err := strconv.Atoi(command.version)
+	if err != nil {
+		command.exitWithError(fmt.Sprintf("Invalid number for %s", COMMAND_ARG_VERSION))
+	}
+
+	if body, err := command.writeBody(map[string]any{"id": id}); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/config-rollback", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				fmt.Printf("Server's configuration rolled back to version %d.\n", id)
+			} else {
+				command.exitWithError(errors.New(res.Status))
+			}
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
 func (command *Command) configSet() {
 	if command.in == "" {
 		command.exitWithError(fmt.Sprintf("Missing %s <file.json> arguments.", COMMAND_ARG_IN))
@@ -299,10 +633,68 @@ func (command *Command) configSet() {
 	}
 }
 
+func (command *Command) configVersions() {
+	url := "/api/admin/config-versions"
+	if command.version != "" {
+		url = fmt.Sprintf("%s?id=%s", url, command.version)
+	}
+
+	if res, err := command.submit(http.MethodGet, url, nil, true); err == nil {
+		if res.StatusCode == http.StatusOK {
+			if data, err := command.readBody(res.Body); err == nil {
+				switch v := data.(type) {
+				case []any:
+					for _, f := range v {
+						switch v := f.(type) {
+						case map[string]any:
+							sections := []string{}
+							switch s := v["sections"].(type) {
+							case []any:
+								for _, section := range s {
+									sections = append(sections, fmt.Sprintf("%v", section))
+								}
+							}
+							fmt.Printf("%6v  %v  %-12v %-40s %v\n", v["_id"], v["dateTime"], v["username"], strings.Join(sections, ","), command.getString(v["comment"]))
+						}
+					}
+				case map[string]any:
+					j := json.NewEncoder(os.Stdout)
+					j.SetIndent("", "  ")
+					j.Encode(v["diff"])
+				default:
+					command.exitWithError(errors.New("invalid response"))
+				}
+			} else {
+				command.exitWithError(err)
+			}
+		} else {
+			command.exitWithError(errors.New(res.Status))
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
 func (command *Command) login() {
-	if body, err := command.writeBody(map[string]any{"password": command.password}); err == nil {
+	m := map[string]any{"password": command.password, "username": command.username}
+	if command.code != "" {
+		m["code"] = command.code
+	}
+
+	if body, err := command.writeBody(m); err == nil {
 		if res, err := command.submit(http.MethodPost, "/api/admin/login", body, false); err == nil {
-			if res.StatusCode == http.StatusOK {
+			if res.StatusCode == http.StatusUnauthorized {
+				if data, err := command.readBody(res.Body); err == nil {
+					switch v := data.(type) {
+					case map[string]any:
+						if v["totpRequired"] == true {
+							command.exitWithError(fmt.Sprintf("%s, two-factor authentication code required with %s <code>.", res.Status, COMMAND_ARG_CODE))
+						}
+					}
+				}
+				command.exitWithError(res.Status)
+
+			} else if res.StatusCode == http.StatusOK {
 				if data, err := command.readBody(res.Body); err == nil {
 					switch v := data.(type) {
 					case map[string]any:
@@ -432,6 +824,14 @@ func (command *Command) userRemove() {
 	}
 }
 
+func (c *Command) getString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	}
+	return ""
+}
+
 func (c *Command) readBody(body io.ReadCloser) (data any, err error) {
 	err = json.NewDecoder(body).Decode(&data)
 	return data, err
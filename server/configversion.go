@@ -0,0 +1,373 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ConfigChangeAdded   = "added"
+	ConfigChangeChanged = "changed"
+	ConfigChangeRemoved = "removed"
+
+	configVersionsMax = 100
+)
+
+// configSecrets are the fields of the config sections that hold a secret, left
+// out of what the API keys with the admin-read permission get to see.
+var configSecrets = map[string]string{
+	"access":      "code",
+	"apiKeys":     "key",
+	"downstreams": "apiKey",
+}
+
+// ConfigChange is an item added to, removed from or changed in a section of
+// the configuration, the list items being matched on their _id.
+type ConfigChange struct {
+	Fields map[string]*ConfigFieldChange `json:"fields,omitempty"`
+	Id     any                           `json:"_id,omitempty"`
+	Item   any                           `json:"item,omitempty"`
+	Op     string                        `json:"op"`
+}
+
+type ConfigFieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// ConfigDiff is the list of changes of each section of the configuration.
+type ConfigDiff map[string][]*ConfigChange
+
+func NewConfigDiff(from map[string]any, to map[string]any) ConfigDiff {
+	diff := ConfigDiff{}
+
+	for _, section := range getConfigKeys(from, to) {
+		var changes []*ConfigChange
+
+		switch f := from[section].(type) {
+		case []any:
+			t, _ := to[section].([]any)
+			changes = getConfigListChanges(f, t)
+
+		case map[string]any:
+			t, _ := to[section].(map[string]any)
+			if fields := getConfigFieldChanges(f, t); len(fields) > 0 {
+				changes = []*ConfigChange{{Fields: fields, Op: ConfigChangeChanged}}
+			}
+
+		default:
+			switch t := to[section].(type) {
+			case []any:
+				changes = getConfigListChanges(nil, t)
+			case map[string]any:
+				if fields := getConfigFieldChanges(nil, t); len(fields) > 0 {
+					changes = []*ConfigChange{{Fields: fields, Op: ConfigChangeChanged}}
+				}
+			default:
+				if !reflect.DeepEqual(f, t) {
+					changes = []*ConfigChange{{Fields: map[string]*ConfigFieldChange{section: {From: f, To: t}}, Op: ConfigChangeChanged}}
+				}
+			}
+		}
+
+		if len(changes) > 0 {
+			diff[section] = changes
+		}
+	}
+
+	return diff
+}
+
+// ConfigVersion is a snapshot of the configuration as saved by an admin user,
+// along with what changed from the previous one.
+type ConfigVersion struct {
+	Id       any            `json:"_id"`
+	Comment  string         `json:"comment,omitempty"`
+	Config   map[string]any `json:"config,omitempty"`
+	DateTime time.Time      `json:"dateTime"`
+	Diff     ConfigDiff     `json:"diff,omitempty"`
+	Sections []string       `json:"sections,omitempty"`
+	Username string         `json:"username"`
+}
+
+type ConfigVersions struct {
+	mutex sync.Mutex
+}
+
+func NewConfigVersions() *ConfigVersions {
+	return &ConfigVersions{
+		mutex: sync.Mutex{},
+	}
+}
+
+// Add stores the version, keeping only the most recent ones.
+func (versions *ConfigVersions) Add(db *Database, version *ConfigVersion) error {
+	var (
+		config []byte
+		diff   []byte
+		err    error
+		id     int64
+		res    sql.Result
+	)
+
+	versions.mutex.Lock()
+	defer versions.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("configversions.add: %v", err)
+	}
+
+	if config, err = json.Marshal(version.Config); err != nil {
+		return formatError(err)
+	}
+
+	if diff, err = json.Marshal(version.Diff); err != nil {
+		return formatError(err)
+	}
+
+	if res, err = db.Sql.Exec("insert into `freeScannerConfigVersions` (`comment`, `config`, `dateTime`, `diff`, `username`) values (?, ?, ?, ?, ?)", version.Comment, string(config), version.DateTime, string(diff), version.Username); err != nil {
+		return formatError(err)
+	}
+
+	if id, err = res.LastInsertId(); err == nil {
+		version.Id = uint(id)
+	}
+
+	if err = db.Sql.QueryRow("select `_id` from `freeScannerConfigVersions` order by `_id` desc limit 1 offset ?", configVersionsMax).Scan(&id); err == nil {
+		if _, err = db.Sql.Exec("delete from `freeScannerConfigVersions` where `_id` <= ?", id); err != nil {
+			return formatError(err)
+		}
+
+	} else if err != sql.ErrNoRows {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (versions *ConfigVersions) Count(db *Database) (uint, error) {
+	var count uint
+
+	if err := db.Sql.QueryRow("select count(*) from `freeScannerConfigVersions`").Scan(&count); err != nil {
+		return 0, fmt.Errorf("configversions.count: %v", err)
+	}
+
+	return count, nil
+}
+
+// Get returns the version with its snapshot and diff, nil if not found.
+func (versions *ConfigVersions) Get(db *Database, id uint) (*ConfigVersion, error) {
+	var (
+		config   string
+		dateTime any
+		diff     string
+		err      error
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("configversions.get: %v", err)
+	}
+
+	version := &ConfigVersion{Id: id}
+
+	if err = db.Sql.QueryRow("select `comment`, `config`, `dateTime`, `diff`, `username` from `freeScannerConfigVersions` where `_id` = ?", id).Scan(&version.Comment, &config, &dateTime, &diff, &version.Username); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, formatError(err)
+	}
+
+	if version.DateTime, err = db.ParseDateTime(dateTime); err != nil {
+		return nil, formatError(err)
+	}
+
+	if err = json.Unmarshal([]byte(config), &version.Config); err != nil {
+		return nil, formatError(err)
+	}
+
+	if err = json.Unmarshal([]byte(diff), &version.Diff); err != nil {
+		return nil, formatError(err)
+	}
+
+	version.Sections = version.Diff.getSections()
+
+	return version, nil
+}
+
+// List returns the versions, most recent first, without their snapshot and
+// diff but with the sections that changed.
+func (versions *ConfigVersions) List(db *Database) ([]*ConfigVersion, error) {
+	var (
+		err  error
+		rows *sql.Rows
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("configversions.list: %v", err)
+	}
+
+	list := []*ConfigVersion{}
+
+	if rows, err = db.Sql.Query("select `_id`, `comment`, `dateTime`, `diff`, `username` from `freeScannerConfigVersions` order by `_id` desc"); err != nil {
+		return nil, formatError(err)
+	}
+
+	for rows.Next() {
+		var (
+			dateTime any
+			diff     string
+			id       uint
+		)
+
+		version := &ConfigVersion{}
+
+		if err = rows.Scan(&id, &version.Comment, &dateTime, &diff, &version.Username); err != nil {
+			break
+		}
+
+		version.Id = id
+
+		if t, err := db.ParseDateTime(dateTime); err == nil {
+			version.DateTime = t
+		}
+
+		d := ConfigDiff{}
+		if json.Unmarshal([]byte(diff), &d) == nil {
+			version.Sections = d.getSections()
+		}
+
+		list = append(list, version)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return nil, formatError(err)
+	}
+
+	return list, nil
+}
+
+func (diff ConfigDiff) getSections() []string {
+	sections := []string{}
+	for section := range diff {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	return sections
+}
+
+func (diff ConfigDiff) redactSecrets() {
+	for section, field := range configSecrets {
+		for _, change := range diff[section] {
+			switch item := change.Item.(type) {
+			case map[string]any:
+				delete(item, field)
+			}
+			delete(change.Fields, field)
+		}
+	}
+}
+
+func getConfigFieldChanges(from map[string]any, to map[string]any) map[string]*ConfigFieldChange {
+	fields := map[string]*ConfigFieldChange{}
+
+	for _, key := range getConfigKeys(from, to) {
+		if !reflect.DeepEqual(from[key], to[key]) {
+			fields[key] = &ConfigFieldChange{From: from[key], To: to[key]}
+		}
+	}
+
+	return fields
+}
+
+func getConfigKeys(maps ...map[string]any) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func getConfigListChanges(from []any, to []any) []*ConfigChange {
+	changes := []*ConfigChange{}
+
+	index := func(list []any) (map[string]map[string]any, []string) {
+		items := map[string]map[string]any{}
+		ids := []string{}
+		for _, v := range list {
+			switch item := v.(type) {
+			case map[string]any:
+				id := fmt.Sprintf("%v", item["_id"])
+				items[id] = item
+				ids = append(ids, id)
+			}
+		}
+		return items, ids
+	}
+
+	fromItems, fromIds := index(from)
+	toItems, toIds := index(to)
+
+	for _, id := range fromIds {
+		f := fromItems[id]
+		if t, ok := toItems[id]; !ok {
+			changes = append(changes, &ConfigChange{Id: f["_id"], Item: f, Op: ConfigChangeRemoved})
+		} else if fields := getConfigFieldChanges(f, t); len(fields) > 0 {
+			changes = append(changes, &ConfigChange{Fields: fields, Id: f["_id"], Op: ConfigChangeChanged})
+		}
+	}
+
+	for _, id := range toIds {
+		if _, ok := fromItems[id]; !ok {
+			t := toItems[id]
+			changes = append(changes, &ConfigChange{Id: t["_id"], Item: t, Op: ConfigChangeAdded})
+		}
+	}
+
+	return changes
+}
+
+// redactConfigSecrets removes the secrets from a config normalized through json.
+func redactConfigSecrets(config map[string]any) {
+	for section, field := range configSecrets {
+		switch list := config[section].(type) {
+		case []any:
+			for _, v := range list {
+				switch item := v.(type) {
+				case map[string]any:
+					delete(item, field)
+				}
+			}
+		}
+	}
+}
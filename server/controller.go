@@ -22,57 +22,83 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type Controller struct {
-	Admin       *Admin
-	Api         *Api
-	Calls       *Calls
-	Config      *Config
-	Database    *Database
-	Accesses    *Accesses
-	Apikeys     *Apikeys
-	Dirwatches  *Dirwatches
-	Downstreams *Downstreams
-	FFMpeg      *FFMpeg
-	Groups      *Groups
-	Logs        *Logs
-	Options     *Options
-	Scheduler   *Scheduler
-	Systems     *Systems
-	Tags        *Tags
-	Clients     *Clients
-	Register    chan *Client
-	Unregister  chan *Client
-	Ingest      chan *Call
-	running     bool
+	Admin           *Admin
+	AdminSessions   *AdminSessions
+	AdminUsers      *AdminUsers
+	Alerts          *Alerts
+	Api             *Api
+	Calls           *Calls
+	Config          *Config
+	ConfigVersions  *ConfigVersions
+	Database        *Database
+	Accesses        *Accesses
+	Apikeys         *Apikeys
+	Dirwatches      *Dirwatches
+	Downstreams     *Downstreams
+	DownstreamQueue *DownstreamQueue
+	Events          *Events
+	FFMpeg          *FFMpeg
+	Groups          *Groups
+	Logs            *Logs
+	Metrics         *Metrics
+	Mqtt            *Mqtt
+	Options         *Options
+	Quota           *Quota
+	Scheduler       *Scheduler
+	Streams         *Streams
+	Systems         *Systems
+	Tags            *Tags
+	Transcription   *TranscriptionQueue
+	Clients         *Clients
+	Register        chan *Client
+	Unregister      chan *Client
+	Ingest          chan *Call
+	ingestQueues    []chan *Call
+	populateMutex   sync.Mutex
+	running         bool
 }
 
 func NewController(config *Config) *Controller {
 	controller := &Controller{
-		Config:      config,
-		Accesses:    NewAccesses(),
-		Apikeys:     NewApikeys(),
-		Calls:       NewCalls(),
-		Dirwatches:  NewDirwatches(),
-		Downstreams: NewDownstreams(),
-		FFMpeg:      NewFFMpeg(),
-		Groups:      NewGroups(),
-		Logs:        NewLogs(),
-		Options:     NewOptions(),
-		Systems:     NewSystems(),
-		Tags:        NewTags(),
-		Clients:     NewClients(),
-		Register:    make(chan *Client, 8192),
-		Unregister:  make(chan *Client, 8192),
-		Ingest:      make(chan *Call, 8192),
+		Config:         config,
+		Accesses:       NewAccesses(),
+		AdminSessions:  NewAdminSessions(),
+		AdminUsers:     NewAdminUsers(),
+		Alerts:         NewAlerts(),
+		Apikeys:        NewApikeys(),
+		Calls:          NewCalls(),
+		ConfigVersions: NewConfigVersions(),
+		Dirwatches:     NewDirwatches(),
+		Downstreams:    NewDownstreams(),
+		Events:         NewEvents(),
+		FFMpeg:         NewFFMpeg(),
+		Groups:         NewGroups(),
+		Logs:           NewLogs(),
+		Options:        NewOptions(),
+		Streams:        NewStreams(),
+		Systems:        NewSystems(),
+		Tags:           NewTags(),
+		Clients:        NewClients(),
+		Register:       make(chan *Client, 8192),
+		Unregister:     make(chan *Client, 8192),
+		Ingest:         make(chan *Call, 8192),
 	}
 
 	controller.Admin = NewAdmin(controller)
 	controller.Api = NewApi(controller)
 	controller.Database = NewDatabase(config)
+	controller.DownstreamQueue = NewDownstreamQueue(controller)
+	controller.Metrics = NewMetrics(controller)
+	controller.Mqtt = NewMqtt(controller)
+	controller.Quota = NewQuota(controller)
+	controller.Transcription = NewTranscriptionQueue(controller)
 	controller.Scheduler = NewScheduler(controller)
 
 	controller.Logs.setDaemon(config.daemon)
@@ -82,29 +108,31 @@ func NewController(config *Config) *Controller {
 }
 
 func (controller *Controller) EmitCall(call *Call) {
-	go controller.Downstreams.Send(controller, call)
+	if controller.Transcription.IsEnabled() {
+		controller.Transcription.Add(call)
+	} else {
+		go controller.Downstreams.Send(controller, call)
+	}
 	go controller.Clients.EmitCall(call, controller.Accesses.IsRestricted())
+	go controller.Streams.EmitCall(call)
+	go controller.Mqtt.EmitCall(call)
+	go controller.Events.EmitCall(call)
 }
 
 func (controller *Controller) EmitConfig() {
 	go controller.Clients.EmitConfig(controller.Groups, controller.Options, controller.Systems, controller.Tags, controller.Accesses.IsRestricted())
 	go controller.Admin.BroadcastConfig()
+	go controller.Streams.Refresh(controller)
+	go controller.Events.EmitConfig(controller)
 }
 
 func (controller *Controller) IngestCall(call *Call) {
 	var (
-		err        error
-		group      *Group
-		groupId    uint
-		groupLabel string
-		id         uint
-		ok         bool
-		populated  bool
-		system     *System
-		tag        *Tag
-		tagId      uint
-		tagLabel   string
-		talkgroup  *Talkgroup
+		err       error
+		id        uint
+		ok        bool
+		system    *System
+		talkgroup *Talkgroup
 	)
 
 	logCall := func(call *Call, level string, message string) {
@@ -115,195 +143,63 @@ func (controller *Controller) IngestCall(call *Call) {
 		controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("controller.ingestcall: %v", err.Error()))
 	}
 
-	if system, ok = controller.Systems.GetSystem(call.System); ok {
-		if system.Blacklists.IsBlacklisted(call.Talkgroup) {
-			logCall(call, LogLevelInfo, "blacklisted")
-			return
-		}
-		talkgroup, _ = system.Talkgroups.GetTalkgroup(call.Talkgroup)
-	}
-
-	if controller.Options.AutoPopulate && system == nil {
-		populated = true
-
-		system = NewSystem()
-		system.Id = call.System
-
-		switch v := call.systemLabel.(type) {
-		case string:
-			system.Label = v
-		default:
-			system.Label = fmt.Sprintf("System %v", call.System)
-		}
-
-		controller.Systems.List = append(controller.Systems.List, system)
-	}
-
-	if controller.Options.AutoPopulate || (system != nil && system.AutoPopulate) {
-		if system != nil && talkgroup == nil {
-			populated = true
-
-			switch v := call.talkgroupGroup.(type) {
-			case string:
-				groupLabel = v
-			default:
-				groupLabel = "Unknown"
-			}
-
-			switch v := call.talkgroupTag.(type) {
-			case string:
-				tagLabel = v
-			default:
-				tagLabel = "Untagged"
-			}
-
-			if group, ok = controller.Groups.GetGroup(groupLabel); !ok {
-				group = &Group{Label: groupLabel}
-
-				controller.Groups.List = append(controller.Groups.List, group)
-
-				if err = controller.Groups.Write(controller.Database); err != nil {
-					logError(err)
-					return
-				}
-
-				if err = controller.Groups.Read(controller.Database); err != nil {
-					logError(err)
-					return
-				}
-
-				if group, ok = controller.Groups.GetGroup(groupLabel); !ok {
-					logError(fmt.Errorf("unable to get group %s", groupLabel))
-					return
-				}
-			}
-
-			switch v := group.Id.(type) {
-			case uint:
-				groupId = v
-			default:
-				logError(fmt.Errorf("unable to get group id for group %s", groupLabel))
-				return
-			}
-
-			if tag, ok = controller.Tags.GetTag(tagLabel); !ok {
-				tag = &Tag{Label: tagLabel}
-
-				controller.Tags.List = append(controller.Tags.List, tag)
-
-				if err = controller.Tags.Write(controller.Database); err != nil {
-					logError(err)
-					return
-				}
-
-				if err = controller.Tags.Read(controller.Database); err != nil {
-					logError(err)
-					return
-				}
-
-				if tag, ok = controller.Tags.GetTag(tagLabel); !ok {
-					logError(fmt.Errorf("unable to get tag %s", tagLabel))
-					return
-				}
-			}
-
-			switch v := tag.Id.(type) {
-			case uint:
-				tagId = v
-			default:
-				logError(fmt.Errorf("unable to get tag id for tag %s", tagLabel))
-				return
-			}
-
-			talkgroup = &Talkgroup{
-				GroupId: groupId,
-				Id:      call.Talkgroup,
-				Label:   fmt.Sprintf("%d", call.Talkgroup),
-				TagId:   tagId,
-			}
-
-			system.Talkgroups.List = append(system.Talkgroups.List, talkgroup)
-		}
-
-		switch v := call.talkgroupLabel.(type) {
-		case string:
-			if talkgroup.Label != v {
-				populated = true
-				talkgroup.Label = v
-			}
-		}
-
-		switch v := call.talkgroupName.(type) {
-		case string:
-			if talkgroup.Name != v {
-				populated = true
-				talkgroup.Name = v
-			}
-		default:
-			if len(talkgroup.Name) == 0 {
-				populated = true
-				talkgroup.Name = talkgroup.Label
-			}
+	// report the outcome to the source of the call, ie. a dirwatch
+	defer func() {
+		if call.ingested != nil {
+			call.ingested(err)
 		}
+	}()
 
-		switch v := call.units.(type) {
-		case *Units:
-			if v != nil {
-				populated = system.Units.Merge(v)
-			}
-		}
+	if system, ok = controller.Systems.GetSystem(call.System); ok && system.Blacklists.IsBlacklisted(call.Talkgroup) {
+		err = errors.New("blacklisted")
+		logCall(call, LogLevelInfo, "blacklisted")
+		controller.Metrics.CallRejected(call, MetricsRejectBlacklisted)
+		return
 	}
 
-	if populated {
-		if err = controller.Systems.Write(controller.Database); err != nil {
-			logError(err)
-			return
-		}
-
-		if err = controller.Systems.Read(controller.Database); err != nil {
-			logError(err)
-			return
-		}
-
-		controller.EmitConfig()
+	if system, talkgroup, err = controller.populateSystem(call); err != nil {
+		logError(err)
+		return
 	}
 
 	if system == nil || talkgroup == nil {
+		err = errors.New("no matching system/talkgroup")
 		logCall(call, LogLevelWarn, "no matching system/talkgroup")
+		controller.Metrics.CallRejected(call, MetricsRejectInvalid)
 		return
 	}
 
 	if !controller.Options.DisableDuplicateDetection {
 		if controller.Calls.CheckDuplicate(call, controller.Options.DuplicateDetectionTimeFrame, controller.Database) {
+			err = errors.New("duplicate call")
 			logCall(call, LogLevelWarn, "duplicate call rejected")
+			controller.Metrics.CallRejected(call, MetricsRejectDuplicate)
 			return
 		}
 	}
 
-	if err := controller.FFMpeg.Convert(call, controller.Systems, controller.Tags, controller.Options.AudioConversion); err != nil {
+	start := time.Now()
+	if converted, err := controller.FFMpeg.Convert(call, controller.Systems, controller.Tags, controller.Options.AudioConversion); err != nil {
 		controller.Logs.LogEvent(LogLevelWarn, err.Error())
+	} else if converted {
+		controller.Metrics.CallConverted(call, time.Since(start))
 	}
 
 	if id, err = controller.Calls.WriteCall(call, controller.Database); err == nil {
 		call.Id = id
-		call.systemLabel = system.Label
-		call.talkgroupLabel = talkgroup.Label
-		call.talkgroupName = talkgroup.Name
+		call.setLabels(system, talkgroup, controller.Groups, controller.Tags)
 
-		if group == nil {
-			if group, ok = controller.Groups.GetGroup(talkgroup.GroupId); ok {
-				call.talkgroupGroup = group.Label
-			}
+		if err := controller.Calls.WriteText(call, system, controller.Database); err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
 		}
 
-		if tag == nil {
-			if tag, ok = controller.Tags.GetTag(talkgroup.TagId); ok {
-				call.talkgroupTag = tag.Label
-			}
-		}
+		controller.Alerts.Evaluate(controller, call)
 
 		logCall(call, LogLevelInfo, "success")
 
+		controller.Metrics.CallIngested(call)
+		controller.Quota.CallWritten(call)
+
 		controller.EmitCall(call)
 
 	} else {
@@ -311,13 +207,24 @@ func (controller *Controller) IngestCall(call *Call) {
 	}
 }
 
+// IngestQueueDepth returns the number of calls waiting to be ingested.
+func (controller *Controller) IngestQueueDepth() int {
+	depth := len(controller.Ingest)
+
+	for _, queue := range controller.ingestQueues {
+		depth += len(queue)
+	}
+
+	return depth
+}
+
 func (controller *Controller) LogClientsCount() {
 	controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("listeners count is %v", controller.Clients.Count()))
 }
 
 func (controller *Controller) ProcessMessage(client *Client, message *Message) error {
 	if message.Command == MessageCommandVersion {
-		controller.ProcessMessageCommandVersion(client)
+		controller.ProcessMessageCommandVersion(client, message)
 
 	} else if controller.Accesses.IsRestricted() && client.Access.Systems == nil && message.Command != MessageCommandPin {
 		client.Send <- &Message{Command: MessageCommandPin}
@@ -452,9 +359,22 @@ func (controller *Controller) ProcessMessageCommandPin(client *Client, message *
 	return nil
 }
 
-func (controller *Controller) ProcessMessageCommandVersion(client *Client) {
+func (controller *Controller) ProcessMessageCommandVersion(client *Client, message *Message) {
 	p := map[string]string{"version": Version}
 
+	// clients not asking for an audio encoding get the legacy buffer encoding
+	switch v := message.Payload.(type) {
+	case map[string]any:
+		switch encoding := v["audioEncoding"].(type) {
+		case string:
+			switch encoding {
+			case AudioEncodingBase64, AudioEncodingBinary, AudioEncodingBuffer:
+				client.SetAudioEncoding(encoding)
+				p["audioEncoding"] = encoding
+			}
+		}
+	}
+
 	if len(controller.Options.Branding) > 0 {
 		p["branding"] = controller.Options.Branding
 	}
@@ -484,6 +404,9 @@ func (controller *Controller) Start() error {
 	if err = controller.Accesses.Read(controller.Database); err != nil {
 		return err
 	}
+	if err = controller.Alerts.Read(controller.Database); err != nil {
+		return err
+	}
 	if err = controller.Apikeys.Read(controller.Database); err != nil {
 		return err
 	}
@@ -499,6 +422,15 @@ func (controller *Controller) Start() error {
 	if err = controller.Options.Read(controller.Database); err != nil {
 		return err
 	}
+	if err = controller.AdminUsers.Read(controller.Database); err != nil {
+		return err
+	}
+	if err = controller.AdminUsers.Seed(controller.Database, controller.Options); err != nil {
+		return err
+	}
+	if err = controller.AdminSessions.Read(controller.Database); err != nil {
+		return err
+	}
 	if err = controller.Systems.Read(controller.Database); err != nil {
 		return err
 	}
@@ -512,6 +444,18 @@ func (controller *Controller) Start() error {
 	if err = controller.Scheduler.Start(); err != nil {
 		return err
 	}
+	if err = controller.DownstreamQueue.Start(); err != nil {
+		return err
+	}
+	if err = controller.Transcription.Start(); err != nil {
+		return err
+	}
+	if err = controller.Streams.StartIcecast(controller); err != nil {
+		controller.Logs.LogEvent(LogLevelError, err.Error())
+	}
+	if err = controller.Mqtt.Start(); err != nil {
+		controller.Logs.LogEvent(LogLevelError, err.Error())
+	}
 
 	go func() {
 		c := make(chan os.Signal, 8)
@@ -520,12 +464,7 @@ func (controller *Controller) Start() error {
 		controller.Terminate()
 	}()
 
-	go func() {
-		for {
-			call := <-controller.Ingest
-			controller.IngestCall(call)
-		}
-	}()
+	controller.startIngest()
 
 	go func() {
 		const (
@@ -554,6 +493,8 @@ func (controller *Controller) Start() error {
 
 				controller.LogClientsCount()
 
+				controller.Mqtt.EmitListenersCount(controller.Clients.Count())
+
 				if controller.Options.ShowListenersCount {
 					controller.Clients.EmitListenersCount()
 				}
@@ -580,6 +521,7 @@ func (controller *Controller) Start() error {
 
 func (controller *Controller) Terminate() {
 	controller.Dirwatches.Stop()
+	controller.Mqtt.Stop()
 
 	if err := controller.Database.Sql.Close(); err != nil {
 		log.Println(err)
@@ -589,3 +531,206 @@ func (controller *Controller) Terminate() {
 
 	os.Exit(0)
 }
+
+// populateSystem returns the system and talkgroup of the call, creating them
+// when auto populate is enabled. The ingest workers go through it one at a
+// time so that the same system, talkgroup, group or tag is never created twice.
+func (controller *Controller) populateSystem(call *Call) (system *System, talkgroup *Talkgroup, err error) {
+	var (
+		group      *Group
+		groupId    uint
+		groupLabel string
+		ok         bool
+		populated  bool
+		tag        *Tag
+		tagId      uint
+		tagLabel   string
+	)
+
+	controller.populateMutex.Lock()
+	defer controller.populateMutex.Unlock()
+
+	if system, ok = controller.Systems.GetSystem(call.System); ok {
+		talkgroup, _ = system.Talkgroups.GetTalkgroup(call.Talkgroup)
+	}
+
+	if controller.Options.AutoPopulate && system == nil {
+		populated = true
+
+		system = NewSystem()
+		system.Id = call.System
+
+		switch v := call.systemLabel.(type) {
+		case string:
+			system.Label = v
+		default:
+			system.Label = fmt.Sprintf("System %v", call.System)
+		}
+
+		controller.Systems.Add(system)
+	}
+
+	if controller.Options.AutoPopulate || (system != nil && system.AutoPopulate) {
+		if system != nil && talkgroup == nil {
+			populated = true
+
+			switch v := call.talkgroupGroup.(type) {
+			case string:
+				groupLabel = v
+			default:
+				groupLabel = "Unknown"
+			}
+
+			switch v := call.talkgroupTag.(type) {
+			case string:
+				tagLabel = v
+			default:
+				tagLabel = "Untagged"
+			}
+
+			if group, ok = controller.Groups.GetGroup(groupLabel); !ok {
+				group = &Group{Label: groupLabel}
+
+				controller.Groups.Add(group)
+
+				if err = controller.Groups.Write(controller.Database); err != nil {
+					return nil, nil, err
+				}
+
+				if err = controller.Groups.Read(controller.Database); err != nil {
+					return nil, nil, err
+				}
+
+				if group, ok = controller.Groups.GetGroup(groupLabel); !ok {
+					return nil, nil, fmt.Errorf("unable to get group %s", groupLabel)
+				}
+			}
+
+			switch v := group.Id.(type) {
+			case uint:
+				groupId = v
+			default:
+				return nil, nil, fmt.Errorf("unable to get group id for group %s", groupLabel)
+			}
+
+			if tag, ok = controller.Tags.GetTag(tagLabel); !ok {
+				tag = &Tag{Label: tagLabel}
+
+				controller.Tags.Add(tag)
+
+				if err = controller.Tags.Write(controller.Database); err != nil {
+					return nil, nil, err
+				}
+
+				if err = controller.Tags.Read(controller.Database); err != nil {
+					return nil, nil, err
+				}
+
+				if tag, ok = controller.Tags.GetTag(tagLabel); !ok {
+					return nil, nil, fmt.Errorf("unable to get tag %s", tagLabel)
+				}
+			}
+
+			switch v := tag.Id.(type) {
+			case uint:
+				tagId = v
+			default:
+				return nil, nil, fmt.Errorf("unable to get tag id for tag %s", tagLabel)
+			}
+
+			talkgroup = &Talkgroup{
+				GroupId: groupId,
+				Id:      call.Talkgroup,
+				Label:   fmt.Sprintf("%d", call.Talkgroup),
+				TagId:   tagId,
+			}
+
+			system.Talkgroups.Add(talkgroup)
+		}
+
+		switch v := call.talkgroupLabel.(type) {
+		case string:
+			if talkgroup.Label != v {
+				populated = true
+				talkgroup.Label = v
+			}
+		}
+
+		switch v := call.talkgroupName.(type) {
+		case string:
+			if talkgroup.Name != v {
+				populated = true
+				talkgroup.Name = v
+			}
+		default:
+			if len(talkgroup.Name) == 0 {
+				populated = true
+				talkgroup.Name = talkgroup.Label
+			}
+		}
+
+		switch v := call.units.(type) {
+		case *Units:
+			if v != nil {
+				populated = system.Units.Merge(v)
+			}
+		}
+	}
+
+	if populated {
+		if err = controller.Systems.Write(controller.Database); err != nil {
+			return nil, nil, err
+		}
+
+		if err = controller.Systems.Read(controller.Database); err != nil {
+			return nil, nil, err
+		}
+
+		controller.EmitConfig()
+	}
+
+	return system, talkgroup, nil
+}
+
+// startIngest dispatches the incoming calls to the ingest workers. Calls of the
+// same system and talkgroup always go to the same worker so they are ingested
+// in the order they came in.
+func (controller *Controller) startIngest() {
+	const (
+		queueSize        = 1024
+		queueWarnDepth   = 100
+		queueWarnTimeout = time.Minute
+	)
+
+	workers := int(controller.Config.IngestWorkers)
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	controller.ingestQueues = make([]chan *Call, workers)
+
+	for i := range controller.ingestQueues {
+		queue := make(chan *Call, queueSize)
+
+		controller.ingestQueues[i] = queue
+
+		go func() {
+			for call := range queue {
+				controller.IngestCall(call)
+			}
+		}()
+	}
+
+	go func() {
+		var warned time.Time
+
+		for call := range controller.Ingest {
+			controller.ingestQueues[(call.System*31+call.Talkgroup)%uint(workers)] <- call
+
+			if depth := controller.IngestQueueDepth(); depth >= queueWarnDepth && time.Since(warned) > queueWarnTimeout {
+				warned = time.Now()
+				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("ingest is falling behind, %d calls waiting", depth))
+			}
+		}
+	}()
+}
@@ -20,7 +20,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -28,6 +27,7 @@ import (
 )
 
 type Database struct {
+	AudioStore     AudioStore
 	Config         *Config
 	DateTimeFormat string
 	Sql            *sql.DB
@@ -36,13 +36,17 @@ type Database struct {
 func NewDatabase(config *Config) *Database {
 	var err error
 
-	database := &Database{Config: config}
+	database := &Database{
+		AudioStore: NewLocalAudioStore(config.GetAudioDirPath()),
+		Config:     config,
+	}
 
 	switch config.DbType {
 	case DbTypeSqlite:
 		database.DateTimeFormat = "2006-01-02 15:04:05.000 -07:00"
 
-		dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout%%3d10000", config.GetDbFilePath())
+		// wal journal so that readers and the concurrent ingest writers do not lock each other out
+		dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout%%3d10000&_pragma=journal_mode%%3dwal", config.GetDbFilePath())
 
 		if database.Sql, err = sql.Open("sqlite", dsn); err != nil {
 			log.Fatal(err)
@@ -127,24 +131,74 @@ func (db *Database) migrate() error {
 	if err == nil {
 		err = db.migration20220101070000(verbose)
 	}
+	if err == nil {
+		err = db.migration20261018090000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018100000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018110000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018120000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018130000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018140000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018150000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018160000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018170000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018180000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018190000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018200000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018210000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018220000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018230000(verbose)
+	}
 
 	return err
 }
 
 func (db *Database) migrateWithSchema(name string, schemas []string, verbose bool) error {
+	return db.migrateWithStatements(name, NewStatements(schemas...), verbose)
+}
+
+func (db *Database) migrateWithStatements(name string, statements []Statement, verbose bool) error {
 	var (
-		count int = 0
-		err   error
-		query string
-		tx    *sql.Tx
+		count     int = 0
+		err       error
+		query     string
+		statement Statement
+		tx        *sql.Tx
 	)
 
 	formatError := func(err error, query string) error {
 		return fmt.Errorf("%s while doing %s", err.Error(), query)
 	}
 
-	query = fmt.Sprintf("select count(*) from `freeScannerMeta` where `name` = '%s'", name)
-	if err = db.Sql.QueryRow(query).Scan(&count); err != nil {
+	query = "select count(*) from `freeScannerMeta` where `name` = ?"
+	if err = db.Sql.QueryRow(query, name).Scan(&count); err != nil {
 		return formatError(err, query)
 	}
 
@@ -154,15 +208,15 @@ func (db *Database) migrateWithSchema(name string, schemas []string, verbose boo
 		}
 
 		if tx, err = db.Sql.Begin(); err == nil {
-			for _, query = range schemas {
-				if _, err = tx.Exec(query); err != nil {
+			for _, statement = range statements {
+				if _, err = tx.Exec(statement.Query, statement.Args...); err != nil {
 					tx.Rollback()
-					return formatError(err, query)
+					return formatError(err, statement.Query)
 				}
 			}
 
-			query = fmt.Sprintf("insert into `freeScannerMeta` (`name`) values ('%s')", name)
-			if _, err = tx.Exec(query); err != nil {
+			query = "insert into `freeScannerMeta` (`name`) values (?)"
+			if _, err = tx.Exec(query, name); err != nil {
 				tx.Rollback()
 				return formatError(err, query)
 			}
@@ -379,11 +433,10 @@ func (db *Database) migration20220101070000(verbose bool) error {
 		err        error
 		frequency  any
 		id         uint
-		label      string
 		led        any
-		name       string
 		queries    []string
 		rows       *sql.Rows
+		statements []Statement
 		stra       string
 		strb       string
 		talkgroups []*Talkgroup
@@ -432,23 +485,20 @@ func (db *Database) migration20220101070000(verbose bool) error {
 				case uint:
 					frequency = v
 				default:
-					frequency = "null"
+					frequency = nil
 				}
-				label = strings.ReplaceAll(tg.Label, "'", "''")
 				switch v := tg.Led.(type) {
 				case string:
-					led = fmt.Sprintf("'%v'", strings.ReplaceAll(v, "'", "''"))
+					led = v
 				default:
-					led = "null"
+					led = nil
 				}
-				name = strings.ReplaceAll(tg.Name, "'", "''")
 				tg.Order = uint(i + 1)
-				queries = append(queries, fmt.Sprintf("insert into `freeScannerTalkgroups` (`frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `systemId`, `tagId`) values (%v, %v, %v, '%v', %v, '%v', %v, %v, %v)", frequency, tg.GroupId, tg.Id, label, led, name, tg.Order, id, tg.TagId))
+				statements = append(statements, NewStatement("insert into `freeScannerTalkgroups` (`frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `systemId`, `tagId`) values (?, ?, ?, ?, ?, ?, ?, ?, ?)", frequency, tg.GroupId, tg.Id, tg.Label, led, tg.Name, tg.Order, id, tg.TagId))
 			}
 			for i, unit := range units {
-				label = strings.ReplaceAll(unit.Label, "'", "''")
 				unit.Order = uint(i + 1)
-				queries = append(queries, fmt.Sprintf("insert into `freeScannerUnits` (`id`, `label`, `order`, `systemId`) values (%v, '%v', %v, %v)", unit.Id, label, unit.Order, id))
+				statements = append(statements, NewStatement("insert into `freeScannerUnits` (`id`, `label`, `order`, `systemId`) values (?, ?, ?, ?)", unit.Id, unit.Label, unit.Order, id))
 			}
 		}
 		rows.Close()
@@ -456,7 +506,355 @@ func (db *Database) migration20220101070000(verbose bool) error {
 			return err
 		}
 	}
-	return db.migrateWithSchema("20220101070000-v6.1.0", queries, verbose)
+	return db.migrateWithStatements("20220101070000-v6.1.0", append(NewStatements(queries...), statements...), verbose)
+}
+
+func (db *Database) migration20261018090000(verbose bool) error {
+	const name = "20261018090000-v6.7.0-audio-store"
+
+	var (
+		audio      []byte
+		audioName  sql.NullString
+		audioType  sql.NullString
+		dateTime   any
+		err        error
+		id         uint
+		moved      uint
+		refs       []string
+		rows       *sql.Rows
+		statements []Statement
+	)
+
+	if db.Config.DbType == DbTypeSqlite {
+		statements = NewStatements(
+			"create table `freeScannerCalls2` (`id` integer primary key autoincrement, `audioName` varchar(255), `audioPath` varchar(255), `audioType` varchar(255), `dateTime` datetime not null, `frequencies` text not null, `frequency` integer, `patches` text not null, `source` integer, `sources` text not null, `system` integer not null, `talkgroup` integer not null)",
+			"insert into `freeScannerCalls2` select `id`, `audioName`, null, `audioType`, `dateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup` from `freeScannerCalls`",
+		)
+	} else {
+		statements = NewStatements(
+			"alter table `freeScannerCalls` add column `audioPath` varchar(255)",
+		)
+	}
+
+	// move the audio blobs to the audio store, the audio column no longer exists once the migration is done
+	if rows, err = db.Sql.Query("select `id`, `audio`, `audioName`, `audioType`, `dateTime`, `system`, `talkgroup` from `freeScannerCalls`"); err == nil {
+		if verbose {
+			log.Printf("moving calls audio to %s", db.Config.GetAudioDirPath())
+		}
+
+		for rows.Next() {
+			call := NewCall()
+
+			if err = rows.Scan(&id, &audio, &audioName, &audioType, &dateTime, &call.System, &call.Talkgroup); err != nil {
+				break
+			}
+
+			if len(audio) == 0 {
+				continue
+			}
+
+			call.Audio = audio
+
+			if audioName.Valid {
+				call.AudioName = audioName.String
+			}
+
+			if audioType.Valid {
+				call.AudioType = audioType.String
+			}
+
+			if t, err := db.ParseDateTime(dateTime); err == nil {
+				call.DateTime = t
+			}
+
+			var ref string
+			if ref, err = db.AudioStore.Write(call); err != nil {
+				break
+			}
+
+			refs = append(refs, ref)
+
+			if db.Config.DbType == DbTypeSqlite {
+				statements = append(statements, NewStatement("update `freeScannerCalls2` set `audioPath` = ? where `id` = ?", ref, id))
+			} else {
+				statements = append(statements, NewStatement("update `freeScannerCalls` set `audioPath` = ? where `id` = ?", ref, id))
+			}
+
+			moved++
+		}
+
+		rows.Close()
+
+		if err != nil {
+			for _, ref := range refs {
+				db.AudioStore.Delete(ref)
+			}
+			return err
+		}
+	}
+
+	if db.Config.DbType == DbTypeSqlite {
+		statements = append(statements, NewStatements(
+			"drop table `freeScannerCalls`",
+			"alter table `freeScannerCalls2` rename to `freeScannerCalls`",
+			"create index `free_scanner_calls_date_time_system_talkgroup` on `freeScannerCalls` (`dateTime`, `system`, `talkgroup`)",
+		)...)
+	} else {
+		statements = append(statements, NewStatements(
+			"alter table `freeScannerCalls` drop column `audio`",
+		)...)
+	}
+
+	if err = db.migrateWithStatements(name, statements, verbose); err != nil {
+		for _, ref := range refs {
+			db.AudioStore.Delete(ref)
+		}
+		return err
+	}
+
+	// give back the space used by the audio blobs
+	if moved > 0 && db.Config.DbType == DbTypeSqlite {
+		if verbose {
+			log.Printf("%d calls audio moved, compacting database", moved)
+		}
+
+		if _, err = db.Sql.Exec("vacuum"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) migration20261018100000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `freeScannerDownstreamQueue` (`_id` integer primary key autoincrement, `attempts` integer not null default 0, `callId` integer not null, `createdAt` datetime not null, `downstreamId` integer not null, `lastAttempt` datetime, `lastError` text, `nextAttempt` datetime not null)",
+			"create index `free_scanner_downstream_queue_next_attempt` on `freeScannerDownstreamQueue` (`nextAttempt`)",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerDownstreamQueue` (`_id` integer primary key auto_increment, `attempts` integer not null default 0, `callId` integer not null, `createdAt` datetime not null, `downstreamId` integer not null, `lastAttempt` datetime, `lastError` text, `nextAttempt` datetime not null)",
+			"create index `free_scanner_downstream_queue_next_attempt` on `freeScannerDownstreamQueue` (`nextAttempt`)",
+		}
+	}
+	return db.migrateWithSchema("20261018100000-v6.7.0-downstream-queue", queries, verbose)
+}
+
+func (db *Database) migration20261018110000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerApiKeys` add column `read` tinyint(1) default 0",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerApiKeys` add column `read` tinyint(1) default 0",
+		}
+	}
+	return db.migrateWithSchema("20261018110000-v6.7.0-apikeys-read", queries, verbose)
+}
+
+func (db *Database) migration20261018120000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerCalls` add column `transcript` text",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerCalls` add column `transcript` text",
+		}
+	}
+	return db.migrateWithSchema("20261018120000-v6.7.0-calls-transcript", queries, verbose)
+}
+
+func (db *Database) migration20261018130000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create virtual table `freeScannerCallsText` using fts5(`labels`, `transcript`)",
+			"insert into `freeScannerCallsText` (`rowid`, `labels`, `transcript`) select c.`id`, trim(coalesce(s.`label`, '') || ' ' || coalesce(t.`label`, '') || ' ' || coalesce(t.`name`, '') || ' ' || coalesce(u.`label`, '')), coalesce(c.`transcript`, '') from `freeScannerCalls` c left join `freeScannerSystems` s on s.`id` = c.`system` left join `freeScannerTalkgroups` t on t.`systemId` = c.`system` and t.`id` = c.`talkgroup` left join `freeScannerUnits` u on u.`systemId` = c.`system` and u.`id` = c.`source`",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerCallsText` (`callId` integer primary key, `labels` text not null, `transcript` text not null)",
+			"create fulltext index `free_scanner_calls_text_fulltext` on `freeScannerCallsText` (`labels`, `transcript`)",
+			"insert into `freeScannerCallsText` (`callId`, `labels`, `transcript`) select c.`id`, concat_ws(' ', s.`label`, t.`label`, t.`name`, u.`label`), coalesce(c.`transcript`, '') from `freeScannerCalls` c left join `freeScannerSystems` s on s.`id` = c.`system` left join `freeScannerTalkgroups` t on t.`systemId` = c.`system` and t.`id` = c.`talkgroup` left join `freeScannerUnits` u on u.`systemId` = c.`system` and u.`id` = c.`source`",
+		}
+	}
+	return db.migrateWithSchema("20261018130000-v6.7.0-calls-text", queries, verbose)
+}
+
+func (db *Database) migration20261018140000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `freeScannerAlerts` (`_id` integer primary key autoincrement, `disabled` tinyint(1) default 0, `emergency` tinyint(1) default 0, `groups` text not null, `keywords` text not null, `label` varchar(255) not null, `order` integer, `sink` varchar(255) not null, `systems` text not null, `tags` text not null, `target` text not null, `timeFrom` varchar(5), `timeTo` varchar(5), `units` text not null)",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerAlerts` (`_id` integer primary key auto_increment, `disabled` tinyint(1) default 0, `emergency` tinyint(1) default 0, `groups` text not null, `keywords` text not null, `label` varchar(255) not null, `order` integer, `sink` varchar(255) not null, `systems` text not null, `tags` text not null, `target` text not null, `timeFrom` varchar(5), `timeTo` varchar(5), `units` text not null)",
+		}
+	}
+	return db.migrateWithSchema("20261018140000-v6.7.0-alerts", queries, verbose)
+}
+
+func (db *Database) migration20261018150000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerDirWatches` add column `pollingInterval` integer",
+			"create table `freeScannerDirWatchesSeen` (`_id` integer primary key autoincrement, `dirwatchId` integer not null, `modTime` bigint not null, `path` text not null, `size` bigint not null)",
+			"create index `free_scanner_dir_watches_seen_dirwatch_id` on `freeScannerDirWatchesSeen` (`dirwatchId`)",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerDirWatches` add column `pollingInterval` integer",
+			"create table `freeScannerDirWatchesSeen` (`_id` integer primary key auto_increment, `dirwatchId` integer not null, `modTime` bigint not null, `path` text not null, `size` bigint not null)",
+			"create index `free_scanner_dir_watches_seen_dirwatch_id` on `freeScannerDirWatchesSeen` (`dirwatchId`)",
+		}
+	}
+	return db.migrateWithSchema("20261018150000-v6.7.0-dirwatch-polling", queries, verbose)
+}
+
+func (db *Database) migration20261018160000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerDirWatches` add column `archiveDir` varchar(255)",
+			"alter table `freeScannerDirWatches` add column `quarantineDir` varchar(255)",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerDirWatches` add column `archiveDir` varchar(255)",
+			"alter table `freeScannerDirWatches` add column `quarantineDir` varchar(255)",
+		}
+	}
+	return db.migrateWithSchema("20261018160000-v6.7.0-dirwatch-archive", queries, verbose)
+}
+
+func (db *Database) migration20261018170000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerDownstreams` add column `audioBaseUrl` varchar(255)",
+			"alter table `freeScannerDownstreams` add column `remoteSystem` varchar(255)",
+			"alter table `freeScannerDownstreams` add column `type` varchar(255)",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerDownstreams` add column `audioBaseUrl` varchar(255)",
+			"alter table `freeScannerDownstreams` add column `remoteSystem` varchar(255)",
+			"alter table `freeScannerDownstreams` add column `type` varchar(255)",
+		}
+	}
+	return db.migrateWithSchema("20261018170000-v6.7.0-downstream-types", queries, verbose)
+}
+
+func (db *Database) migration20261018180000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerCalls` add column `keep` tinyint(1) not null default 0",
+			"alter table `freeScannerGroups` add column `pruneDays` integer",
+			"alter table `freeScannerSystems` add column `pruneDays` integer",
+			"alter table `freeScannerTags` add column `pruneDays` integer",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerCalls` add column `keep` tinyint(1) not null default 0",
+			"alter table `freeScannerGroups` add column `pruneDays` integer",
+			"alter table `freeScannerSystems` add column `pruneDays` integer",
+			"alter table `freeScannerTags` add column `pruneDays` integer",
+		}
+	}
+	return db.migrateWithSchema("20261018180000-v6.7.0-retention", queries, verbose)
+}
+
+func (db *Database) migration20261018190000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `freeScannerAdminUsers` (`_id` integer primary key autoincrement, `password` varchar(255) not null, `passwordNeedChange` tinyint(1) not null default 0, `role` varchar(255) not null, `username` varchar(255) not null unique)",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerAdminUsers` (`_id` integer primary key auto_increment, `password` varchar(255) not null, `passwordNeedChange` tinyint(1) not null default 0, `role` varchar(255) not null, `username` varchar(255) not null unique)",
+		}
+	}
+	return db.migrateWithSchema("20261018190000-v6.7.0-admin-users", queries, verbose)
+}
+
+func (db *Database) migration20261018200000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `freeScannerConfigVersions` (`_id` integer primary key autoincrement, `comment` varchar(255), `config` text not null, `dateTime` datetime not null, `diff` text not null, `username` varchar(255) not null)",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerConfigVersions` (`_id` integer primary key auto_increment, `comment` varchar(255), `config` longtext not null, `dateTime` datetime not null, `diff` longtext not null, `username` varchar(255) not null)",
+		}
+	}
+	return db.migrateWithSchema("20261018200000-v6.7.0-config-versions", queries, verbose)
+}
+
+func (db *Database) migration20261018210000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `freeScannerAdminSessions` (`_id` integer primary key autoincrement, `dateCreated` datetime not null, `dateExpires` datetime not null, `dateLastSeen` datetime not null, `ip` varchar(255), `tokenId` varchar(255) not null unique, `userAgent` varchar(255), `username` varchar(255) not null)",
+		}
+	} else {
+		queries = []string{
+			"create table `freeScannerAdminSessions` (`_id` integer primary key auto_increment, `dateCreated` datetime not null, `dateExpires` datetime not null, `dateLastSeen` datetime not null, `ip` varchar(255), `tokenId` varchar(255) not null unique, `userAgent` varchar(255), `username` varchar(255) not null)",
+		}
+	}
+	return db.migrateWithSchema("20261018210000-v6.7.0-admin-sessions", queries, verbose)
+}
+
+func (db *Database) migration20261018220000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerAdminUsers` add column `recoveryCodes` text",
+			"alter table `freeScannerAdminUsers` add column `totpEnabled` tinyint(1) not null default 0",
+			"alter table `freeScannerAdminUsers` add column `totpSecret` varchar(255)",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerAdminUsers` add column `recoveryCodes` text",
+			"alter table `freeScannerAdminUsers` add column `totpEnabled` tinyint(1) not null default 0",
+			"alter table `freeScannerAdminUsers` add column `totpSecret` varchar(255)",
+		}
+	}
+	return db.migrateWithSchema("20261018220000-v6.7.0-admin-totp", queries, verbose)
+}
+
+func (db *Database) migration20261018230000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"alter table `freeScannerApiKeys` add column `adminRead` tinyint(1) default 0",
+			"alter table `freeScannerApiKeys` add column `expiration` datetime",
+			"alter table `freeScannerApiKeys` add column `lastUsed` datetime",
+			"alter table `freeScannerApiKeys` add column `lastUsedIp` varchar(255)",
+			"alter table `freeScannerApiKeys` add column `rateLimit` integer",
+			"alter table `freeScannerApiKeys` add column `upload` tinyint(1) default 1",
+		}
+	} else {
+		queries = []string{
+			"alter table `freeScannerApiKeys` add column `adminRead` tinyint(1) default 0",
+			"alter table `freeScannerApiKeys` add column `expiration` datetime",
+			"alter table `freeScannerApiKeys` add column `lastUsed` datetime",
+			"alter table `freeScannerApiKeys` add column `lastUsedIp` varchar(255)",
+			"alter table `freeScannerApiKeys` add column `rateLimit` integer",
+			"alter table `freeScannerApiKeys` add column `upload` tinyint(1) default 1",
+		}
+	}
+	return db.migrateWithSchema("20261018230000-v6.7.0-apikeys-scopes", queries, verbose)
 }
 
 func (db *Database) prepareMigration() (bool, error) {
@@ -0,0 +1,93 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpWriteTimeout is the time a response has to be written, or for the
+// long-lived responses, each of their writes.
+const httpWriteTimeout = 30 * time.Second
+
+type httpConnKey struct{}
+
+// DeadlineWriter pushes back the write deadline of a long-lived response
+// before each write, so that it only times out when the client stalls.
+type DeadlineWriter struct {
+	http.ResponseWriter
+	request *http.Request
+}
+
+func NewDeadlineWriter(w http.ResponseWriter, r *http.Request) *DeadlineWriter {
+	return &DeadlineWriter{ResponseWriter: w, request: r}
+}
+
+func (w *DeadlineWriter) Flush() {
+	setWriteDeadline(w.request, time.Now().Add(httpWriteTimeout))
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *DeadlineWriter) Write(b []byte) (int, error) {
+	setWriteDeadline(w.request, time.Now().Add(httpWriteTimeout))
+
+	return w.ResponseWriter.Write(b)
+}
+
+// httpConnContext keeps the connection in the context of its requests, for
+// their write deadline to be set per request.
+func httpConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, httpConnKey{}, conn)
+}
+
+// setWriteDeadline sets the write deadline of the connection of the request.
+// HTTP/2 connections are shared by many requests and are left alone.
+func setWriteDeadline(r *http.Request, t time.Time) {
+	if r.ProtoMajor != 1 {
+		return
+	}
+
+	if conn, ok := r.Context().Value(httpConnKey{}).(net.Conn); ok {
+		conn.SetWriteDeadline(t)
+	}
+}
+
+// withWriteTimeout does what the WriteTimeout of the server would, except for
+// the long-lived routes, which have no deadline unless they write through a
+// DeadlineWriter. Go 1.18 offers no other way to lift the deadline of a single
+// request.
+func withWriteTimeout(handler http.Handler, longLived ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline := time.Now().Add(httpWriteTimeout)
+
+		for _, p := range longLived {
+			if r.URL.Path == p {
+				deadline = time.Time{}
+				break
+			}
+		}
+
+		setWriteDeadline(r, deadline)
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -18,6 +18,7 @@ package main
 type Defaults struct {
 	adminPassword           string
 	adminPasswordNeedChange bool
+	adminUsername           string
 	access                  DefaultAccess
 	apikey                  DefaultApikey
 	dirwatch                DefaultDirwatch
@@ -40,9 +41,10 @@ type DefaultApikey struct {
 }
 
 type DefaultDirwatch struct {
-	deleteAfter bool
-	disabled    bool
-	usePolling  bool
+	deleteAfter     bool
+	disabled        bool
+	pollingInterval uint
+	usePolling      bool
 }
 
 type DefaultDownstream struct {
@@ -50,16 +52,22 @@ type DefaultDownstream struct {
 }
 
 type DefaultOptions struct {
+	adminSessionIdle            uint
+	adminSessionMaxAge          uint
 	autoPopulate                bool
 	audioConversion             uint
 	dimmerDelay                 uint
 	disableDuplicateDetection   bool
+	downstreamRetryMaxAge       uint
+	downstreamRetryMaxAttempts  uint
 	duplicateDetectionTimeFrame uint
 	keypadBeeps                 string
 	disableBeeps                bool
 	maxClients                  uint
 	playbackGoesLive            bool
 	pruneDays                   uint
+	pruneMaxSize                uint
+	pruneMinFree                uint
 	searchPatchedTalkgroups     bool
 	showListenersCount          bool
 	sortTalkgroups              bool
@@ -70,6 +78,7 @@ type DefaultOptions struct {
 var defaults Defaults = Defaults{
 	adminPassword:           "freescanner",
 	adminPasswordNeedChange: true,
+	adminUsername:           "admin",
 	access: DefaultAccess{
 		ident:   "Unknown",
 		systems: "*",
@@ -79,9 +88,10 @@ var defaults Defaults = Defaults{
 		systems: "*",
 	},
 	dirwatch: DefaultDirwatch{
-		deleteAfter: true,
-		disabled:    false,
-		usePolling:  false,
+		deleteAfter:     true,
+		disabled:        false,
+		pollingInterval: 5000,
+		usePolling:      false,
 	},
 	downstream: DefaultDownstream{
 		systems: "*",
@@ -96,15 +106,21 @@ var defaults Defaults = Defaults{
 	},
 	keypadBeeps: "uniden",
 	options: DefaultOptions{
+		adminSessionIdle:            60,
+		adminSessionMaxAge:          168,
 		audioConversion:             AUDIO_CONVERSION_ENABLED,
 		autoPopulate:                true,
 		dimmerDelay:                 5000,
 		disableDuplicateDetection:   false,
+		downstreamRetryMaxAge:       24,
+		downstreamRetryMaxAttempts:  10,
 		duplicateDetectionTimeFrame: 500,
 		keypadBeeps:                 "uniden",
 		maxClients:                  200,
 		playbackGoesLive:            false,
 		pruneDays:                   7,
+		pruneMaxSize:                0,
+		pruneMinFree:                0,
 		searchPatchedTalkgroups:     false,
 		showListenersCount:          false,
 		sortTalkgroups:              false,
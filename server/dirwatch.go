@@ -17,7 +17,6 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -39,29 +38,34 @@ import (
 const (
 	DirwatchTypeDefault       = "default"
 	DirwatchTypeDSDPlus       = "dsdplus"
+	DirwatchTypeOP25          = "op25"
 	DirwatchTypeSdrTrunk      = "sdr-trunk"
 	DirwatchTypeTrunkRecorder = "trunk-recorder"
 )
 
 type Dirwatch struct {
-	Id          any    `json:"_id"`
-	Delay       any    `json:"delay"`
-	DeleteAfter bool   `json:"deleteAfter"`
-	Directory   string `json:"directory"`
-	Disabled    bool   `json:"disabled"`
-	Extension   any    `json:"extension"`
-	Frequency   any    `json:"frequency"`
-	Mask        any    `json:"mask"`
-	Order       any    `json:"order"`
-	SystemId    any    `json:"systemId"`
-	TalkgroupId any    `json:"talkgroupId"`
-	Kind        any    `json:"type"`
-	UsePolling  bool   `json:"usePolling"`
-	controller  *Controller
-	dirs        map[string]bool
-	mutex       sync.Mutex
-	timers      map[string]*time.Timer
-	watcher     *fsnotify.Watcher
+	Id              any    `json:"_id"`
+	ArchiveDir      any    `json:"archiveDir"`
+	Delay           any    `json:"delay"`
+	DeleteAfter     bool   `json:"deleteAfter"`
+	Directory       string `json:"directory"`
+	Disabled        bool   `json:"disabled"`
+	Extension       any    `json:"extension"`
+	Frequency       any    `json:"frequency"`
+	Mask            any    `json:"mask"`
+	Order           any    `json:"order"`
+	PollingInterval any    `json:"pollingInterval"`
+	QuarantineDir   any    `json:"quarantineDir"`
+	SystemId        any    `json:"systemId"`
+	TalkgroupId     any    `json:"talkgroupId"`
+	Kind            any    `json:"type"`
+	UsePolling      bool   `json:"usePolling"`
+	controller      *Controller
+	dirs            map[string]bool
+	mutex           sync.Mutex
+	poller          *DirwatchPoller
+	timers          map[string]*time.Timer
+	watcher         *fsnotify.Watcher
 }
 
 func NewDirwatch() *Dirwatch {
@@ -78,6 +82,11 @@ func (dirwatch *Dirwatch) FromMap(m map[string]any) *Dirwatch {
 		dirwatch.Id = uint(v)
 	}
 
+	switch v := m["archiveDir"].(type) {
+	case string:
+		dirwatch.ArchiveDir = v
+	}
+
 	switch v := m["delay"].(type) {
 	case float64:
 		dirwatch.Delay = uint(v)
@@ -118,6 +127,16 @@ func (dirwatch *Dirwatch) FromMap(m map[string]any) *Dirwatch {
 		dirwatch.Order = uint(v)
 	}
 
+	switch v := m["pollingInterval"].(type) {
+	case float64:
+		dirwatch.PollingInterval = uint(v)
+	}
+
+	switch v := m["quarantineDir"].(type) {
+	case string:
+		dirwatch.QuarantineDir = v
+	}
+
 	switch v := m["systemId"].(type) {
 	case float64:
 		dirwatch.SystemId = uint(v)
@@ -142,25 +161,53 @@ func (dirwatch *Dirwatch) FromMap(m map[string]any) *Dirwatch {
 }
 
 func (dirwatch *Dirwatch) Ingest(p string) {
-	var err error
+	var (
+		call  *Call
+		err   error
+		files []string
+	)
+
+	// files already archived or quarantined below the watched directory
+	if dirwatch.isReleased(p) {
+		return
+	}
 
 	switch dirwatch.Kind {
 	case DirwatchTypeDSDPlus:
-		err = dirwatch.ingestDSDPlus(p)
+		call, files, err = dirwatch.ingestDSDPlus(p)
+	case DirwatchTypeOP25:
+		call, files, err = dirwatch.ingestOP25(p)
 	case DirwatchTypeTrunkRecorder:
-		err = dirwatch.ingestTrunkRecorder(p)
+		call, files, err = dirwatch.ingestTrunkRecorder(p)
 	case DirwatchTypeSdrTrunk:
-		err = dirwatch.ingestSdrTrunk(p)
+		call, files, err = dirwatch.ingestSdrTrunk(p)
 	default:
-		err = dirwatch.ingestDefault(p)
+		call, files, err = dirwatch.ingestDefault(p)
 	}
 
 	if err != nil {
 		dirwatch.controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("dirwatch.ingest: %s, %s", err.Error(), p))
+		dirwatch.controller.Metrics.DirwatchEvent(dirwatch, "error")
+
+		if len(files) > 0 && len(dirwatch.getQuarantineDir()) > 0 {
+			dirwatch.quarantine(files, err)
+		}
+
+		return
+	}
+
+	if call == nil {
+		return
+	}
+
+	call.ingested = func(err error) {
+		dirwatch.release(call, files, err)
 	}
+
+	dirwatch.controller.Ingest <- call
 }
 
-func (dirwatch *Dirwatch) ingestDefault(p string) error {
+func (dirwatch *Dirwatch) ingestDefault(p string) (*Call, []string, error) {
 	var (
 		err error
 		ext string
@@ -177,48 +224,41 @@ func (dirwatch *Dirwatch) ingestDefault(p string) error {
 		ext = ".wav"
 	}
 
-	if strings.EqualFold(path.Ext(p), ext) {
-		call := NewCall()
-
-		call.AudioName = filepath.Base(p)
-		call.AudioType = mime.TypeByExtension(path.Ext(p))
-		call.Frequency = dirwatch.Frequency
-		call.DateTime = time.Now().UTC()
+	if !strings.EqualFold(path.Ext(p), ext) {
+		return nil, nil, nil
+	}
 
-		if call.Audio, err = os.ReadFile(p); err != nil {
-			return err
-		}
+	call := NewCall()
 
-		dirwatch.parseMask(call)
+	call.AudioName = filepath.Base(p)
+	call.AudioType = mime.TypeByExtension(path.Ext(p))
+	call.Frequency = dirwatch.Frequency
+	call.DateTime = time.Now().UTC()
 
-		switch v := dirwatch.SystemId.(type) {
-		case uint:
-			call.System = v
-		}
+	if call.Audio, err = os.ReadFile(p); err != nil {
+		return nil, nil, err
+	}
 
-		switch v := dirwatch.TalkgroupId.(type) {
-		case uint:
-			call.Talkgroup = v
-		}
+	dirwatch.parseMask(call)
 
-		if ok, err := call.IsValid(); ok {
-			dirwatch.controller.Ingest <- call
+	switch v := dirwatch.SystemId.(type) {
+	case uint:
+		call.System = v
+	}
 
-			if dirwatch.DeleteAfter {
-				if err = os.Remove(p); err != nil {
-					return err
-				}
-			}
+	switch v := dirwatch.TalkgroupId.(type) {
+	case uint:
+		call.Talkgroup = v
+	}
 
-		} else {
-			return err
-		}
+	if ok, err := call.IsValid(); !ok {
+		return nil, []string{p}, err
 	}
 
-	return err
+	return call, []string{p}, nil
 }
 
-func (dirwatch *Dirwatch) ingestDSDPlus(p string) error {
+func (dirwatch *Dirwatch) ingestDSDPlus(p string) (*Call, []string, error) {
 	var (
 		err error
 		ext string
@@ -236,7 +276,7 @@ func (dirwatch *Dirwatch) ingestDSDPlus(p string) error {
 	}
 
 	if !strings.EqualFold(path.Ext(p), ext) {
-		return nil
+		return nil, nil, nil
 	}
 
 	call := NewCall()
@@ -256,34 +296,81 @@ func (dirwatch *Dirwatch) ingestDSDPlus(p string) error {
 	}
 
 	if call.Audio, err = os.ReadFile(p); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if err = ParseDSDPlusMeta(call, p); err != nil {
-		return err
+		return nil, []string{p}, err
+	}
+
+	if ok, err := call.IsValid(); !ok {
+		return nil, []string{p}, err
 	}
 
-	if ok, err := call.IsValid(); ok {
-		dirwatch.controller.Ingest <- call
+	return call, []string{p}, nil
+}
 
-		if dirwatch.DeleteAfter {
-			if err = os.Remove(p); err != nil {
-				return err
-			}
+func (dirwatch *Dirwatch) ingestOP25(p string) (*Call, []string, error) {
+	var (
+		b   []byte
+		err error
+		ext string
+	)
+
+	if !strings.EqualFold(path.Ext(p), ".json") && !strings.EqualFold(path.Ext(p), ".log") {
+		return nil, nil, nil
+	}
+
+	switch v := dirwatch.Extension.(type) {
+	case string:
+		if len(v) > 0 {
+			ext = fmt.Sprintf(".%s", v)
+		} else {
+			ext = ".wav"
 		}
+	default:
+		ext = ".wav"
+	}
 
-	} else {
-		return err
+	audioName := strings.TrimSuffix(p, path.Ext(p)) + ext
+
+	call := NewCall()
+
+	call.AudioName = filepath.Base(audioName)
+	call.AudioType = mime.TypeByExtension(path.Ext(audioName))
+	call.DateTime = time.Now().UTC()
+	call.Frequency = dirwatch.Frequency
+
+	if call.Audio, err = os.ReadFile(audioName); err != nil {
+		return nil, nil, nil
 	}
 
-	return nil
+	if b, err = os.ReadFile(p); err != nil {
+		return nil, nil, err
+	}
+
+	if err = ParseOP25Meta(call, b); err != nil {
+		return nil, []string{p, audioName}, err
+	}
+
+	// the dirwatch system overrides the op25 sysid
+	switch v := dirwatch.SystemId.(type) {
+	case uint:
+		call.System = v
+	}
+
+	if ok, err := call.IsValid(); !ok {
+		return nil, []string{p, audioName}, err
+	}
+
+	return call, []string{p, audioName}, nil
 }
 
-func (dirwatch *Dirwatch) ingestSdrTrunk(p string) error {
+func (dirwatch *Dirwatch) ingestSdrTrunk(p string) (*Call, []string, error) {
 	var err error
 
 	if !strings.EqualFold(path.Ext(p), ".mp3") {
-		return nil
+		return nil, nil, nil
 	}
 
 	call := NewCall()
@@ -293,30 +380,21 @@ func (dirwatch *Dirwatch) ingestSdrTrunk(p string) error {
 	call.Frequency = dirwatch.Frequency
 
 	if call.Audio, err = os.ReadFile(p); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if err = ParseSdrTrunkMeta(call, dirwatch.controller); err != nil {
-		return err
+		return nil, []string{p}, err
 	}
 
-	if ok, err := call.IsValid(); ok {
-		dirwatch.controller.Ingest <- call
-
-		if dirwatch.DeleteAfter {
-			if err = os.Remove(p); err != nil {
-				return err
-			}
-		}
-
-	} else {
-		return err
+	if ok, err := call.IsValid(); !ok {
+		return nil, []string{p}, err
 	}
 
-	return nil
+	return call, []string{p}, nil
 }
 
-func (dirwatch *Dirwatch) ingestTrunkRecorder(p string) error {
+func (dirwatch *Dirwatch) ingestTrunkRecorder(p string) (*Call, []string, error) {
 	var (
 		b   []byte
 		err error
@@ -324,7 +402,7 @@ func (dirwatch *Dirwatch) ingestTrunkRecorder(p string) error {
 	)
 
 	if !strings.EqualFold(path.Ext(p), ".json") {
-		return nil
+		return nil, nil, nil
 	}
 
 	switch v := dirwatch.Extension.(type) {
@@ -354,34 +432,22 @@ func (dirwatch *Dirwatch) ingestTrunkRecorder(p string) error {
 	}
 
 	if call.Audio, err = os.ReadFile(audioName); err != nil {
-		return nil
+		return nil, nil, nil
 	}
 
 	if b, err = os.ReadFile(p); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if err = ParseTrunkRecorderMeta(call, b); err != nil {
-		return err
-	}
-
-	if ok, err := call.IsValid(); ok {
-		dirwatch.controller.Ingest <- call
-
-	} else {
-		return err
+		return nil, []string{p, audioName}, err
 	}
 
-	if dirwatch.DeleteAfter {
-		if err = os.Remove(p); err != nil {
-			return err
-		}
-		if err = os.Remove(audioName); err != nil {
-			return err
-		}
+	if ok, err := call.IsValid(); !ok {
+		return nil, []string{p, audioName}, err
 	}
 
-	return nil
+	return call, []string{p, audioName}, nil
 }
 
 func (dirwatch *Dirwatch) parseMask(call *Call) {
@@ -594,17 +660,13 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 		return nil
 	}
 
-	if dirwatch.watcher != nil {
+	if dirwatch.watcher != nil || dirwatch.poller != nil {
 		return errors.New("dirwatch.start: already started")
 	}
 
 	dirwatch.controller = controller
 	dirwatch.dirs = map[string]bool{}
 
-	if dirwatch.watcher, err = fsnotify.NewWatcher(); err != nil {
-		return err
-	}
-
 	switch v := dirwatch.Delay.(type) {
 	case uint:
 		delay = time.Duration(math.Max(float64(v), 2000)) * time.Millisecond
@@ -612,6 +674,16 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 		delay = time.Duration(2000) * time.Millisecond
 	}
 
+	if dirwatch.UsePolling {
+		dirwatch.poller = NewDirwatchPoller(dirwatch, delay)
+		dirwatch.poller.Start()
+		return nil
+	}
+
+	if dirwatch.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+
 	go func() {
 		logError := func(err error) {
 			controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.watcher: %v", err.Error()))
@@ -662,6 +734,8 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 
 				switch event.Op {
 				case fsnotify.Create:
+					controller.Metrics.DirwatchEvent(dirwatch, "create")
+
 					if dirwatch.isDir(event.Name) {
 						if err := dirwatch.walkDir(event.Name); err != nil {
 							logError(err)
@@ -677,6 +751,8 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 					}
 
 				case fsnotify.Remove:
+					controller.Metrics.DirwatchEvent(dirwatch, "remove")
+
 					if dirwatch.dirs[event.Name] {
 						if err := dirwatch.watcher.Remove(event.Name); err == nil {
 							delete(dirwatch.dirs, event.Name)
@@ -686,6 +762,8 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 					}
 
 				case fsnotify.Write:
+					controller.Metrics.DirwatchEvent(dirwatch, "write")
+
 					dirwatch.mutex.Lock()
 					if dirwatch.timers[event.Name] != nil {
 						dirwatch.timers[event.Name].Stop()
@@ -717,11 +795,16 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 		if err := fs.WalkDir(os.DirFS(dirwatch.Directory), ".", func(p string, _ fs.DirEntry, err error) error {
 			fp := filepath.Join(dirwatch.Directory, p)
 
-			if dirwatch.isDir(fp) {
+			if dirwatch.isReleased(fp) {
+				if dirwatch.isDir(fp) {
+					return fs.SkipDir
+				}
+
+			} else if dirwatch.isDir(fp) {
 				dirwatch.dirs[fp] = true
 				dirwatch.watcher.Add(fp)
 
-			} else if dirwatch.DeleteAfter {
+			} else if dirwatch.isConsuming() {
 				dirwatch.Ingest(fp)
 			}
 
@@ -735,6 +818,11 @@ func (dirwatch *Dirwatch) Start(controller *Controller) error {
 }
 
 func (dirwatch *Dirwatch) Stop() {
+	if dirwatch.poller != nil {
+		dirwatch.poller.Stop()
+		dirwatch.poller = nil
+	}
+
 	if dirwatch.watcher != nil {
 		w := dirwatch.watcher
 		dirwatch.watcher = nil
@@ -775,17 +863,20 @@ func (dirwatches *Dirwatches) FromMap(f []any) *Dirwatches {
 
 func (dirwatches *Dirwatches) Read(db *Database) error {
 	var (
-		delay       sql.NullFloat64
-		err         error
-		extension   sql.NullString
-		id          sql.NullFloat64
-		frequency   sql.NullFloat64
-		kind        sql.NullString
-		mask        sql.NullString
-		order       sql.NullFloat64
-		rows        *sql.Rows
-		systemId    sql.NullFloat64
-		talkgroupId sql.NullFloat64
+		archiveDir    sql.NullString
+		delay         sql.NullFloat64
+		err           error
+		extension     sql.NullString
+		id            sql.NullFloat64
+		frequency     sql.NullFloat64
+		kind          sql.NullString
+		mask          sql.NullString
+		order         sql.NullFloat64
+		polling       sql.NullFloat64
+		quarantineDir sql.NullString
+		rows          *sql.Rows
+		systemId      sql.NullFloat64
+		talkgroupId   sql.NullFloat64
 	)
 
 	dirwatches.mutex.Lock()
@@ -799,14 +890,14 @@ func (dirwatches *Dirwatches) Read(db *Database) error {
 		return fmt.Errorf("dirwatches.read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `delay`, `deleteAfter`, `directory`, `disabled`, `extension`, `frequency`, `mask`, `order`, `systemId`, `talkgroupId`, `type`, `usePolling` from `freeScannerDirWatches`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `archiveDir`, `delay`, `deleteAfter`, `directory`, `disabled`, `extension`, `frequency`, `mask`, `order`, `pollingInterval`, `quarantineDir`, `systemId`, `talkgroupId`, `type`, `usePolling` from `freeScannerDirWatches`"); err != nil {
 		return formatError(err)
 	}
 
 	for rows.Next() {
 		dirwatch := NewDirwatch()
 
-		if err = rows.Scan(&id, &delay, &dirwatch.DeleteAfter, &dirwatch.Directory, &dirwatch.Disabled, &extension, &frequency, &mask, &order, &systemId, &talkgroupId, &kind, &dirwatch.UsePolling); err != nil {
+		if err = rows.Scan(&id, &archiveDir, &delay, &dirwatch.DeleteAfter, &dirwatch.Directory, &dirwatch.Disabled, &extension, &frequency, &mask, &order, &polling, &quarantineDir, &systemId, &talkgroupId, &kind, &dirwatch.UsePolling); err != nil {
 			break
 		}
 
@@ -814,6 +905,10 @@ func (dirwatches *Dirwatches) Read(db *Database) error {
 			dirwatch.Id = uint(id.Float64)
 		}
 
+		if archiveDir.Valid && len(archiveDir.String) > 0 {
+			dirwatch.ArchiveDir = archiveDir.String
+		}
+
 		if delay.Valid && id.Float64 > 0 {
 			dirwatch.Delay = uint(delay.Float64)
 		}
@@ -834,6 +929,14 @@ func (dirwatches *Dirwatches) Read(db *Database) error {
 			dirwatch.Order = uint(order.Float64)
 		}
 
+		if polling.Valid && polling.Float64 > 0 {
+			dirwatch.PollingInterval = uint(polling.Float64)
+		}
+
+		if quarantineDir.Valid && len(quarantineDir.String) > 0 {
+			dirwatch.QuarantineDir = quarantineDir.String
+		}
+
 		if systemId.Valid && systemId.Float64 > 0 {
 			dirwatch.SystemId = uint(systemId.Float64)
 		}
@@ -916,14 +1019,14 @@ func (dirwatches *Dirwatches) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerDirwatches` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerDirwatches").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
+		}
+
+		q, args = NewDeleteQuery("freeScannerDirWatchesSeen").Where(db.NewWhere().In("dirwatchId", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -933,11 +1036,11 @@ func (dirwatches *Dirwatches) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerDirWatches` (`_id`, `delay`, `deleteAfter`, `directory`, `disabled`, `extension`, `frequency`, `mask`, `order`, `systemId`, `talkgroupId`, `type`, `usePolling`) values (?, ?, ?, ?, ?, ?, ?, ?, ? ,? ,? ,? ,?)", dirwatch.Id, dirwatch.Delay, dirwatch.DeleteAfter, dirwatch.Directory, dirwatch.Disabled, dirwatch.Extension, dirwatch.Frequency, dirwatch.Mask, dirwatch.Order, dirwatch.SystemId, dirwatch.TalkgroupId, dirwatch.Kind, dirwatch.UsePolling); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerDirWatches` (`_id`, `archiveDir`, `delay`, `deleteAfter`, `directory`, `disabled`, `extension`, `frequency`, `mask`, `order`, `pollingInterval`, `quarantineDir`, `systemId`, `talkgroupId`, `type`, `usePolling`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? ,? ,? ,? ,?)", dirwatch.Id, dirwatch.ArchiveDir, dirwatch.Delay, dirwatch.DeleteAfter, dirwatch.Directory, dirwatch.Disabled, dirwatch.Extension, dirwatch.Frequency, dirwatch.Mask, dirwatch.Order, dirwatch.PollingInterval, dirwatch.QuarantineDir, dirwatch.SystemId, dirwatch.TalkgroupId, dirwatch.Kind, dirwatch.UsePolling); err != nil {
 				break
 			}
 
-		} else if _, err = db.Sql.Exec("update `freeScannerDirWatches` set `_id` = ?, `delay` = ?, `deleteAfter` = ?, `directory` = ?, `disabled` = ?, `extension` = ?, `frequency` = ?, `mask` = ?, `order` = ?, `systemId` = ?, `talkgroupId` = ?, `type` = ?, `usePolling` = ? where `_id` = ?", dirwatch.Id, dirwatch.Delay, dirwatch.DeleteAfter, dirwatch.Directory, dirwatch.Disabled, dirwatch.Extension, dirwatch.Frequency, dirwatch.Mask, dirwatch.Order, dirwatch.SystemId, dirwatch.TalkgroupId, dirwatch.Kind, dirwatch.UsePolling, dirwatch.Id); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerDirWatches` set `_id` = ?, `archiveDir` = ?, `delay` = ?, `deleteAfter` = ?, `directory` = ?, `disabled` = ?, `extension` = ?, `frequency` = ?, `mask` = ?, `order` = ?, `pollingInterval` = ?, `quarantineDir` = ?, `systemId` = ?, `talkgroupId` = ?, `type` = ?, `usePolling` = ? where `_id` = ?", dirwatch.Id, dirwatch.ArchiveDir, dirwatch.Delay, dirwatch.DeleteAfter, dirwatch.Directory, dirwatch.Disabled, dirwatch.Extension, dirwatch.Frequency, dirwatch.Mask, dirwatch.Order, dirwatch.PollingInterval, dirwatch.QuarantineDir, dirwatch.SystemId, dirwatch.TalkgroupId, dirwatch.Kind, dirwatch.UsePolling, dirwatch.Id); err != nil {
 			break
 		}
 	}
@@ -949,6 +1052,53 @@ func (dirwatches *Dirwatches) Write(db *Database) error {
 	return nil
 }
 
+// archive moves the files of an ingested call to the archive directory, in a
+// year/month/day tree from the call date.
+func (dirwatch *Dirwatch) archive(call *Call, files []string) {
+	t := call.DateTime.Local()
+
+	d := filepath.Join(dirwatch.getArchiveDir(), t.Format("2006"), t.Format("01"), t.Format("02"))
+
+	if err := os.MkdirAll(d, 0755); err != nil {
+		dirwatch.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.archive: %v", err))
+		return
+	}
+
+	for _, f := range files {
+		if err := moveFile(f, getFreePath(filepath.Join(d, filepath.Base(f)))); err != nil {
+			dirwatch.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.archive: %v", err))
+			continue
+		}
+
+		dirwatch.controller.Metrics.DirwatchEvent(dirwatch, "archive")
+	}
+}
+
+func (dirwatch *Dirwatch) getArchiveDir() string {
+	switch v := dirwatch.ArchiveDir.(type) {
+	case string:
+		return dirwatch.getReleaseDir(v)
+	}
+	return ""
+}
+
+func (dirwatch *Dirwatch) getQuarantineDir() string {
+	switch v := dirwatch.QuarantineDir.(type) {
+	case string:
+		return dirwatch.getReleaseDir(v)
+	}
+	return ""
+}
+
+// getReleaseDir resolves an archive or quarantine directory, which is relative
+// to the watched directory unless absolute.
+func (dirwatch *Dirwatch) getReleaseDir(d string) string {
+	if len(d) == 0 || filepath.IsAbs(d) {
+		return d
+	}
+	return filepath.Join(dirwatch.Directory, d)
+}
+
 func (dirwatch *Dirwatch) isDir(d string) bool {
 	if fi, err := os.Stat(d); err == nil {
 		if fi.IsDir() {
@@ -959,12 +1109,87 @@ func (dirwatch *Dirwatch) isDir(d string) bool {
 	return false
 }
 
+// isReleased tells if a path is within the archive or quarantine directory,
+// which may be below the watched directory.
+func (dirwatch *Dirwatch) isReleased(p string) bool {
+	for _, d := range []string{dirwatch.getArchiveDir(), dirwatch.getQuarantineDir()} {
+		if len(d) == 0 {
+			continue
+		}
+
+		if rel, err := filepath.Rel(d, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isConsuming tells if the files are taken out of the watched directory once
+// ingested, in which case the files found at startup are ingested.
+func (dirwatch *Dirwatch) isConsuming() bool {
+	return dirwatch.DeleteAfter || len(dirwatch.getArchiveDir()) > 0
+}
+
+// quarantine moves the files of a rejected call to the quarantine directory,
+// each with a sidecar file giving the reason.
+func (dirwatch *Dirwatch) quarantine(files []string, reason error) {
+	d := dirwatch.getQuarantineDir()
+
+	if err := os.MkdirAll(d, 0755); err != nil {
+		dirwatch.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.quarantine: %v", err))
+		return
+	}
+
+	for _, f := range files {
+		dest := getFreePath(filepath.Join(d, filepath.Base(f)))
+
+		if err := moveFile(f, dest); err != nil {
+			dirwatch.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.quarantine: %v", err))
+			continue
+		}
+
+		b := []byte(fmt.Sprintf("date: %s\nfile: %s\nreason: %v\n", time.Now().Format(time.RFC3339), f, reason))
+
+		if err := os.WriteFile(dest+".reason.txt", b, 0644); err != nil {
+			dirwatch.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.quarantine: %v", err))
+		}
+
+		dirwatch.controller.Metrics.DirwatchEvent(dirwatch, "quarantine")
+	}
+}
+
+// release disposes of the files of a call once the controller is done with
+// it, archived or deleted if ingested, quarantined or deleted if rejected.
+func (dirwatch *Dirwatch) release(call *Call, files []string, err error) {
+	if err != nil && len(dirwatch.getQuarantineDir()) > 0 {
+		dirwatch.quarantine(files, err)
+		return
+	}
+
+	if err == nil && len(dirwatch.getArchiveDir()) > 0 {
+		dirwatch.archive(call, files)
+		return
+	}
+
+	if dirwatch.DeleteAfter {
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				dirwatch.controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("dirwatch.release: %v", err))
+			}
+		}
+	}
+}
+
 func (dirwatch *Dirwatch) walkDir(d string) error {
 	dfs := os.DirFS(d)
 
 	return fs.WalkDir(dfs, ".", func(p string, _ fs.DirEntry, err error) error {
 		fp := filepath.Join(d, p)
 		if dirwatch.isDir(fp) {
+			if dirwatch.isReleased(fp) {
+				return fs.SkipDir
+			}
 			if !dirwatch.dirs[fp] {
 				dirwatch.dirs[fp] = true
 				dirwatch.watcher.Add(fp)
@@ -973,3 +1198,35 @@ func (dirwatch *Dirwatch) walkDir(d string) error {
 		return err
 	})
 }
+
+// getFreePath appends a counter to the file name if the path already exists.
+func getFreePath(p string) string {
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+
+	for i := 1; ; i++ {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return p
+		}
+		p = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// moveFile renames a file, or copies it then removes the source when renaming
+// across file systems, as with a network share.
+func moveFile(src string, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(dest, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DirwatchPoller stands in for fsnotify on network shares, where no events
+// are raised. The directory is listed at each interval and a file is ingested
+// once its size and modification time did not change for the dirwatch delay.
+// Ingested files are recorded in the database so that they are not ingested
+// again after a restart.
+type DirwatchPoller struct {
+	delay    time.Duration
+	dirwatch *Dirwatch
+	done     chan struct{}
+	interval time.Duration
+	pending  map[string]*dirwatchPolledFile
+	seen     map[string]*dirwatchPolledFile
+}
+
+type dirwatchPolledFile struct {
+	modTime int64
+	since   time.Time
+	size    int64
+}
+
+func NewDirwatchPoller(dirwatch *Dirwatch, delay time.Duration) *DirwatchPoller {
+	var interval time.Duration
+
+	switch v := dirwatch.PollingInterval.(type) {
+	case uint:
+		interval = time.Duration(math.Max(float64(v), 1000)) * time.Millisecond
+	default:
+		interval = time.Duration(defaults.dirwatch.pollingInterval) * time.Millisecond
+	}
+
+	return &DirwatchPoller{
+		delay:    delay,
+		dirwatch: dirwatch,
+		done:     make(chan struct{}),
+		interval: interval,
+		pending:  map[string]*dirwatchPolledFile{},
+		seen:     map[string]*dirwatchPolledFile{},
+	}
+}
+
+func (poller *DirwatchPoller) Start() {
+	controller := poller.dirwatch.controller
+
+	logError := func(err error) {
+		controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("dirwatch.poller: %v", err))
+	}
+
+	go func() {
+		defer func() {
+			switch v := recover().(type) {
+			case error:
+				logError(v)
+			}
+		}()
+
+		if err := poller.readSeen(); err != nil {
+			logError(err)
+			return
+		}
+
+		ticker := time.NewTicker(poller.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := poller.poll(); err != nil {
+				logError(err)
+			}
+
+			select {
+			case <-poller.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (poller *DirwatchPoller) Stop() {
+	close(poller.done)
+}
+
+func (poller *DirwatchPoller) poll() error {
+	var (
+		dirwatch   = poller.dirwatch
+		controller = dirwatch.controller
+		files      = map[string]*dirwatchPolledFile{}
+		now        = time.Now()
+	)
+
+	err := fs.WalkDir(os.DirFS(dirwatch.Directory), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		fp := filepath.Join(dirwatch.Directory, p)
+
+		if dirwatch.isReleased(fp) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if fi, err := d.Info(); err == nil {
+			files[fp] = &dirwatchPolledFile{modTime: fi.ModTime().UnixMilli(), size: fi.Size()}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the directory itself is recorded once listed, so that a first poll,
+	// where the files already there are skipped unless taken out after
+	// ingestion, can be told from a restart
+	if _, ok := poller.seen[dirwatch.Directory]; !ok {
+		if !dirwatch.isConsuming() {
+			for p, f := range files {
+				if err = poller.writeSeen(p, f); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = poller.writeSeen(dirwatch.Directory, &dirwatchPolledFile{}); err != nil {
+			return err
+		}
+	}
+
+	for p, f := range files {
+		if s, ok := poller.seen[p]; ok && s.modTime == f.modTime && s.size == f.size {
+			continue
+		}
+
+		if pending, ok := poller.pending[p]; !ok {
+			controller.Metrics.DirwatchEvent(dirwatch, "create")
+			f.since = now
+			poller.pending[p] = f
+
+		} else if pending.modTime != f.modTime || pending.size != f.size {
+			controller.Metrics.DirwatchEvent(dirwatch, "write")
+			f.since = now
+			poller.pending[p] = f
+
+		} else if now.Sub(pending.since) >= poller.delay {
+			delete(poller.pending, p)
+
+			if err = poller.writeSeen(p, f); err != nil {
+				return err
+			}
+
+			dirwatch.Ingest(p)
+		}
+	}
+
+	for p := range poller.pending {
+		if _, ok := files[p]; !ok {
+			delete(poller.pending, p)
+		}
+	}
+
+	for p := range poller.seen {
+		if _, ok := files[p]; !ok && p != dirwatch.Directory {
+			controller.Metrics.DirwatchEvent(dirwatch, "remove")
+
+			if err = poller.deleteSeen(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (poller *DirwatchPoller) deleteSeen(p string) error {
+	db := poller.dirwatch.controller.Database
+
+	if _, err := db.Sql.Exec("delete from `freeScannerDirWatchesSeen` where `dirwatchId` = ? and `path` = ?", poller.dirwatch.Id, p); err != nil {
+		return fmt.Errorf("dirwatch.deleteseen: %v", err)
+	}
+
+	delete(poller.seen, p)
+
+	return nil
+}
+
+func (poller *DirwatchPoller) readSeen() error {
+	var (
+		err     error
+		modTime int64
+		p       string
+		rows    *sql.Rows
+		size    int64
+	)
+
+	db := poller.dirwatch.controller.Database
+
+	formatError := func(err error) error {
+		return fmt.Errorf("dirwatch.readseen: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `path`, `modTime`, `size` from `freeScannerDirWatchesSeen` where `dirwatchId` = ?", poller.dirwatch.Id); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(&p, &modTime, &size); err != nil {
+			break
+		}
+
+		poller.seen[p] = &dirwatchPolledFile{modTime: modTime, size: size}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (poller *DirwatchPoller) writeSeen(p string, f *dirwatchPolledFile) error {
+	db := poller.dirwatch.controller.Database
+
+	formatError := func(err error) error {
+		return fmt.Errorf("dirwatch.writeseen: %v", err)
+	}
+
+	if _, ok := poller.seen[p]; ok {
+		if _, err := db.Sql.Exec("update `freeScannerDirWatchesSeen` set `modTime` = ?, `size` = ? where `dirwatchId` = ? and `path` = ?", f.modTime, f.size, poller.dirwatch.Id, p); err != nil {
+			return formatError(err)
+		}
+
+	} else if _, err := db.Sql.Exec("insert into `freeScannerDirWatchesSeen` (`dirwatchId`, `modTime`, `path`, `size`) values (?, ?, ?, ?)", poller.dirwatch.Id, f.modTime, p, f.size); err != nil {
+		return formatError(err)
+	}
+
+	poller.seen[p] = f
+
+	return nil
+}
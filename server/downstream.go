@@ -24,20 +24,29 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	DownstreamTypeBroadcastify = "broadcastify"
+	DownstreamTypeFreeScanner  = "freescanner"
+	DownstreamTypeOpenMHz      = "openmhz"
+	DownstreamTypeWebhook      = "webhook"
+)
+
 type Downstream struct {
-	Id       any    `json:"_id"`
-	Apikey   string `json:"apiKey"`
-	Disabled bool   `json:"disabled"`
-	Order    any    `json:"order"`
-	Systems  any    `json:"systems"`
-	Url      string `json:"url"`
+	Id           any    `json:"_id"`
+	Apikey       string `json:"apiKey"`
+	AudioBaseUrl any    `json:"audioBaseUrl"`
+	Disabled     bool   `json:"disabled"`
+	Order        any    `json:"order"`
+	RemoteSystem any    `json:"remoteSystem"`
+	Systems      any    `json:"systems"`
+	Kind         any    `json:"type"`
+	Url          string `json:"url"`
 }
 
 func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
@@ -51,6 +60,11 @@ func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
 		downstream.Apikey = v
 	}
 
+	switch v := m["audioBaseUrl"].(type) {
+	case string:
+		downstream.AudioBaseUrl = v
+	}
+
 	switch v := m["disabled"].(type) {
 	case bool:
 		downstream.Disabled = v
@@ -61,6 +75,11 @@ func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
 		downstream.Order = uint(v)
 	}
 
+	switch v := m["remoteSystem"].(type) {
+	case string:
+		downstream.RemoteSystem = v
+	}
+
 	switch v := m["systems"].(type) {
 	case []any:
 		if b, err := json.Marshal(v); err == nil {
@@ -70,6 +89,11 @@ func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
 		downstream.Systems = v
 	}
 
+	switch v := m["type"].(type) {
+	case string:
+		downstream.Kind = v
+	}
+
 	switch v := m["url"].(type) {
 	case string:
 		downstream.Url = v
@@ -131,6 +155,15 @@ func (downstream *Downstream) Send(call *Call) error {
 		return nil
 	}
 
+	switch downstream.Kind {
+	case DownstreamTypeBroadcastify:
+		return downstream.sendBroadcastify(call)
+	case DownstreamTypeOpenMHz:
+		return downstream.sendOpenMHz(call)
+	case DownstreamTypeWebhook:
+		return downstream.sendWebhook(call)
+	}
+
 	formatError := func(err error) error {
 		return fmt.Errorf("downstream.send: %s", err.Error())
 	}
@@ -180,6 +213,16 @@ func (downstream *Downstream) Send(call *Call) error {
 		return formatError(err)
 	}
 
+	if call.emergency {
+		if w, err := mw.CreateFormField("emergency"); err == nil {
+			if _, err = w.Write([]byte("true")); err != nil {
+				return formatError(err)
+			}
+		} else {
+			return formatError(err)
+		}
+	}
+
 	switch v := call.Frequencies.(type) {
 	case []map[string]any:
 		if w, err := mw.CreateFormField("frequencies"); err == nil {
@@ -326,6 +369,17 @@ func (downstream *Downstream) Send(call *Call) error {
 		}
 	}
 
+	switch v := call.Transcript.(type) {
+	case string:
+		if w, err := mw.CreateFormField("transcript"); err == nil {
+			if _, err = w.Write([]byte(v)); err != nil {
+				return formatError(err)
+			}
+		} else {
+			return formatError(err)
+		}
+	}
+
 	if err := mw.Close(); err != nil {
 		return formatError(err)
 	}
@@ -383,11 +437,14 @@ func (downstreams *Downstreams) FromMap(f []any) *Downstreams {
 
 func (downstreams *Downstreams) Read(db *Database) error {
 	var (
-		err     error
-		id      sql.NullFloat64
-		order   sql.NullFloat64
-		rows    *sql.Rows
-		systems string
+		audioBaseUrl sql.NullString
+		err          error
+		id           sql.NullFloat64
+		kind         sql.NullString
+		order        sql.NullFloat64
+		remoteSystem sql.NullString
+		rows         *sql.Rows
+		systems      string
 	)
 
 	downstreams.mutex.Lock()
@@ -399,14 +456,14 @@ func (downstreams *Downstreams) Read(db *Database) error {
 		return fmt.Errorf("downstreams.read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `apiKey`, `disabled`, `order`, `systems`, `url` from `freeScannerDownstreams`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `apiKey`, `audioBaseUrl`, `disabled`, `order`, `remoteSystem`, `systems`, `type`, `url` from `freeScannerDownstreams`"); err != nil {
 		return formatError(err)
 	}
 
 	for rows.Next() {
 		downstream := &Downstream{}
 
-		if err = rows.Scan(&id, &downstream.Apikey, &downstream.Disabled, &order, &systems, &downstream.Url); err != nil {
+		if err = rows.Scan(&id, &downstream.Apikey, &audioBaseUrl, &downstream.Disabled, &order, &remoteSystem, &systems, &kind, &downstream.Url); err != nil {
 			break
 		}
 
@@ -418,10 +475,22 @@ func (downstreams *Downstreams) Read(db *Database) error {
 			downstream.Apikey = uuid.New().String()
 		}
 
+		if audioBaseUrl.Valid && len(audioBaseUrl.String) > 0 {
+			downstream.AudioBaseUrl = audioBaseUrl.String
+		}
+
 		if order.Valid && order.Float64 > 0 {
 			downstream.Order = uint(order.Float64)
 		}
 
+		if remoteSystem.Valid && len(remoteSystem.String) > 0 {
+			downstream.RemoteSystem = remoteSystem.String
+		}
+
+		if kind.Valid && len(kind.String) > 0 {
+			downstream.Kind = kind.String
+		}
+
 		if err = json.Unmarshal([]byte(systems), &downstream.Systems); err != nil {
 			downstream.Systems = []any{}
 		}
@@ -443,16 +512,29 @@ func (downstreams *Downstreams) Read(db *Database) error {
 }
 
 func (downstreams *Downstreams) Send(controller *Controller, call *Call) {
+	if err := call.ReadAudio(controller.Database); err != nil {
+		controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("downstreams.send: %v", err))
+		return
+	}
+
 	for _, downstream := range downstreams.List {
 		logEvent := func(logLevel string, message string) {
 			controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v %v", call.System, call.Talkgroup, call.AudioName, downstream.Url, message))
 		}
 
 		if downstream.HasAccess(call) {
-			if err := downstream.Send(call); err == nil {
+			err := downstream.Send(call)
+
+			controller.Metrics.DownstreamSent(downstream, err)
+
+			if err == nil {
 				logEvent(LogLevelInfo, "success")
 			} else {
 				logEvent(LogLevelError, err.Error())
+
+				if err = controller.DownstreamQueue.Add(call, downstream, err); err != nil {
+					logEvent(LogLevelError, err.Error())
+				}
 			}
 		}
 	}
@@ -502,14 +584,9 @@ func (downstreams *Downstreams) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerDownstreams` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerDownstreams").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -526,11 +603,11 @@ func (downstreams *Downstreams) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerDownstreams` (`_id`, `apiKey`, `disabled`, `order`, `systems`, `url`) values (?, ?, ?, ?, ?, ?)", downstream.Id, downstream.Apikey, downstream.Disabled, downstream.Order, systems, downstream.Url); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerDownstreams` (`_id`, `apiKey`, `audioBaseUrl`, `disabled`, `order`, `remoteSystem`, `systems`, `type`, `url`) values (?, ?, ?, ?, ?, ?, ?, ?, ?)", downstream.Id, downstream.Apikey, downstream.AudioBaseUrl, downstream.Disabled, downstream.Order, downstream.RemoteSystem, systems, downstream.Kind, downstream.Url); err != nil {
 				break
 			}
 
-		} else if _, err = db.Sql.Exec("update `freeScannerDownstreams` set `_id` = ?, `apiKey` = ?, `disabled` = ?, `order` = ?, `systems` = ?, `url` = ? where `_id` = ?", downstream.Id, downstream.Apikey, downstream.Disabled, downstream.Order, systems, downstream.Url, downstream.Id); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerDownstreams` set `_id` = ?, `apiKey` = ?, `audioBaseUrl` = ?, `disabled` = ?, `order` = ?, `remoteSystem` = ?, `systems` = ?, `type` = ?, `url` = ? where `_id` = ?", downstream.Id, downstream.Apikey, downstream.AudioBaseUrl, downstream.Disabled, downstream.Order, downstream.RemoteSystem, systems, downstream.Kind, downstream.Url, downstream.Id); err != nil {
 			break
 		}
 	}
@@ -0,0 +1,91 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownstreamHasAccess(t *testing.T) {
+	call := &Call{System: 1, Talkgroup: 100}
+
+	tests := []struct {
+		name       string
+		downstream *Downstream
+		access     bool
+	}{
+		{
+			name:       "all systems",
+			downstream: &Downstream{Systems: "*"},
+			access:     true,
+		},
+		{
+			name:       "disabled",
+			downstream: &Downstream{Disabled: true, Systems: "*"},
+		},
+		{
+			name:       "no systems",
+			downstream: &Downstream{Systems: []any{}},
+		},
+		{
+			name:       "every talkgroup of the system",
+			downstream: &Downstream{Systems: []any{map[string]any{"id": float64(1), "talkgroups": "*"}}},
+			access:     true,
+		},
+		{
+			name:       "talkgroup listed",
+			downstream: &Downstream{Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(99), float64(100)}}}},
+			access:     true,
+		},
+		{
+			name:       "talkgroup not listed",
+			downstream: &Downstream{Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(99)}}}},
+		},
+		{
+			name:       "other system",
+			downstream: &Downstream{Systems: []any{map[string]any{"id": float64(2), "talkgroups": "*"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if access := test.downstream.HasAccess(call); access != test.access {
+				t.Errorf("got access %v, want %v", access, test.access)
+			}
+		})
+	}
+}
+
+func TestDownstreamSendDisabled(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	downstream := &Downstream{Disabled: true, Kind: DownstreamTypeWebhook, Systems: "*", Url: server.URL}
+
+	if err := downstream.Send(newTestDownstreamCall()); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests > 0 {
+		t.Errorf("got %d requests to a disabled downstream", requests)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type DownstreamStatus struct {
+	Backlog     uint      `json:"backlog"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// DownstreamQueue keeps the calls that could not be delivered to a
+// downstream in the database and retries them with an exponential backoff.
+type DownstreamQueue struct {
+	Controller *Controller
+	Ticker     *time.Ticker
+	mutex      sync.Mutex
+	started    bool
+}
+
+type downstreamQueueItem struct {
+	attempts     uint
+	callId       uint
+	createdAt    time.Time
+	downstreamId uint
+	id           uint
+}
+
+func NewDownstreamQueue(controller *Controller) *DownstreamQueue {
+	return &DownstreamQueue{
+		Controller: controller,
+		mutex:      sync.Mutex{},
+	}
+}
+
+func (queue *DownstreamQueue) Add(call *Call, downstream *Downstream, sendErr error) error {
+	var (
+		callId       uint
+		downstreamId uint
+	)
+
+	if queue.Controller.Options.DownstreamRetryMaxAttempts < 2 {
+		return nil
+	}
+
+	switch v := call.Id.(type) {
+	case uint:
+		callId = v
+	default:
+		return nil
+	}
+
+	switch v := downstream.Id.(type) {
+	case uint:
+		downstreamId = v
+	default:
+		return nil
+	}
+
+	db := queue.Controller.Database
+	now := time.Now().UTC()
+
+	if _, err := db.Sql.Exec("insert into `freeScannerDownstreamQueue` (`attempts`, `callId`, `createdAt`, `downstreamId`, `lastAttempt`, `lastError`, `nextAttempt`) values (?, ?, ?, ?, ?, ?, ?)", 1, callId, now.Format(db.DateTimeFormat), downstreamId, now.Format(db.DateTimeFormat), sendErr.Error(), now.Add(queue.getBackoff(1)).Format(db.DateTimeFormat)); err != nil {
+		return fmt.Errorf("downstreamqueue.add: %v", err)
+	}
+
+	return nil
+}
+
+func (queue *DownstreamQueue) GetStatus() (map[uint]*DownstreamStatus, error) {
+	var (
+		backlog      uint
+		downstreamId uint
+		err          error
+		lastAttempt  any
+		lastError    sql.NullString
+		rows         *sql.Rows
+		t            time.Time
+	)
+
+	db := queue.Controller.Database
+
+	status := map[uint]*DownstreamStatus{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstreamqueue.getstatus: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `downstreamId`, count(*) from `freeScannerDownstreamQueue` group by `downstreamId`"); err != nil {
+		return nil, formatError(err)
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(&downstreamId, &backlog); err != nil {
+			break
+		}
+
+		status[downstreamId] = &DownstreamStatus{Backlog: backlog}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return nil, formatError(err)
+	}
+
+	if rows, err = db.Sql.Query("select `downstreamId`, `lastAttempt`, `lastError` from `freeScannerDownstreamQueue` where `lastError` is not null order by `lastAttempt` asc"); err != nil {
+		return nil, formatError(err)
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(&downstreamId, &lastAttempt, &lastError); err != nil {
+			break
+		}
+
+		if s, ok := status[downstreamId]; ok && lastError.Valid {
+			s.LastError = lastError.String
+
+			if t, err = db.ParseDateTime(lastAttempt); err == nil {
+				s.LastErrorAt = t
+			}
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return nil, formatError(err)
+	}
+
+	return status, nil
+}
+
+func (queue *DownstreamQueue) Start() error {
+	const interval = time.Minute
+
+	if queue.started {
+		return errors.New("downstream queue already started")
+	} else {
+		queue.started = true
+	}
+
+	queue.Ticker = time.NewTicker(interval)
+
+	go func() {
+		for range queue.Ticker.C {
+			if err := queue.retry(); err != nil {
+				queue.Controller.Logs.LogEvent(LogLevelError, err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (queue *DownstreamQueue) Stop() error {
+	if !queue.started {
+		return errors.New("downstream queue not started")
+	}
+
+	queue.Ticker.Stop()
+	queue.started = false
+
+	return nil
+}
+
+// fail reschedules the item after a failed attempt, or drops it once out of
+// attempts.
+func (queue *DownstreamQueue) fail(item *downstreamQueueItem, downstream *Downstream, now time.Time, sendErr error) error {
+	controller := queue.Controller
+	db := controller.Database
+
+	logEvent := func(logLevel string, message string) {
+		controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: call=%v to %v %v", item.callId, downstream.Url, message))
+	}
+
+	if item.attempts+1 >= controller.Options.DownstreamRetryMaxAttempts {
+		logEvent(LogLevelError, fmt.Sprintf("dropped after %d attempts, %v", item.attempts+1, sendErr))
+		return queue.remove(item.id)
+	}
+
+	item.attempts++
+	next := now.Add(queue.getBackoff(item.attempts))
+	logEvent(LogLevelWarn, fmt.Sprintf("attempt %d failed, next attempt at %v, %v", item.attempts, next.Local().Format(time.RFC3339), sendErr))
+
+	_, err := db.Sql.Exec("update `freeScannerDownstreamQueue` set `attempts` = ?, `lastAttempt` = ?, `lastError` = ?, `nextAttempt` = ? where `_id` = ?", item.attempts, now.Format(db.DateTimeFormat), sendErr.Error(), next.Format(db.DateTimeFormat), item.id)
+
+	return err
+}
+
+// getBackoff doubles the delay between each attempt, starting at one minute
+// and up to six hours.
+func (queue *DownstreamQueue) getBackoff(attempts uint) time.Duration {
+	const (
+		minDelay = time.Minute
+		maxDelay = 6 * time.Hour
+	)
+
+	d := time.Duration(float64(minDelay) * math.Pow(2, float64(attempts-1)))
+	if d > maxDelay || d <= 0 {
+		d = maxDelay
+	}
+
+	return d
+}
+
+func (queue *DownstreamQueue) remove(id uint) error {
+	_, err := queue.Controller.Database.Sql.Exec("delete from `freeScannerDownstreamQueue` where `_id` = ?", id)
+	return err
+}
+
+func (queue *DownstreamQueue) retry() error {
+	const batchSize = 100
+
+	var (
+		createdAt any
+		err       error
+		items     = []*downstreamQueueItem{}
+		rows      *sql.Rows
+	)
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	controller := queue.Controller
+	db := controller.Database
+	now := time.Now().UTC()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstreamqueue.retry: %v", err)
+	}
+
+	if rows, err = db.Sql.Query("select `_id`, `attempts`, `callId`, `createdAt`, `downstreamId` from `freeScannerDownstreamQueue` where `nextAttempt` <= ? order by `nextAttempt` asc limit ?", now.Format(db.DateTimeFormat), batchSize); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		item := &downstreamQueueItem{}
+
+		if err = rows.Scan(&item.id, &item.attempts, &item.callId, &createdAt, &item.downstreamId); err != nil {
+			break
+		}
+
+		if t, err := db.ParseDateTime(createdAt); err == nil {
+			item.createdAt = t
+		}
+
+		items = append(items, item)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	for _, item := range items {
+		var downstream *Downstream
+
+		for _, d := range controller.Downstreams.List {
+			if d.Id == item.downstreamId {
+				downstream = d
+				break
+			}
+		}
+
+		if downstream == nil || downstream.Disabled {
+			if err = queue.remove(item.id); err != nil {
+				return formatError(err)
+			}
+			continue
+		}
+
+		logEvent := func(logLevel string, message string) {
+			controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: call=%v to %v %v", item.callId, downstream.Url, message))
+		}
+
+		maxAge := time.Duration(controller.Options.DownstreamRetryMaxAge) * time.Hour
+		if maxAge > 0 && now.Sub(item.createdAt) > maxAge {
+			logEvent(LogLevelError, fmt.Sprintf("dropped after %d attempts, older than %v", item.attempts, maxAge))
+			if err = queue.remove(item.id); err != nil {
+				return formatError(err)
+			}
+			continue
+		}
+
+		// an unreadable call counts as a failed attempt, so as not to hold up
+		// the items behind it
+		call, err := controller.Calls.GetCall(item.callId, db)
+		if err != nil {
+			if err = queue.fail(item, downstream, now, err); err != nil {
+				return formatError(err)
+			}
+			continue
+		}
+
+		// the call is gone, likely pruned
+		if call.System == 0 {
+			if err = queue.remove(item.id); err != nil {
+				return formatError(err)
+			}
+			continue
+		}
+
+		if system, ok := controller.Systems.GetSystem(call.System); ok {
+			if talkgroup, ok := system.Talkgroups.GetTalkgroup(call.Talkgroup); ok {
+				call.setLabels(system, talkgroup, controller.Groups, controller.Tags)
+			}
+		}
+
+		if !downstream.HasAccess(call) {
+			if err = queue.remove(item.id); err != nil {
+				return formatError(err)
+			}
+			continue
+		}
+
+		sendErr := downstream.Send(call)
+
+		controller.Metrics.DownstreamSent(downstream, sendErr)
+
+		if sendErr == nil {
+			logEvent(LogLevelInfo, fmt.Sprintf("success after %d attempts", item.attempts+1))
+			if err = queue.remove(item.id); err != nil {
+				return formatError(err)
+			}
+
+		} else if err = queue.fail(item, downstream, now, sendErr); err != nil {
+			return formatError(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sendBroadcastify uploads the call with the Broadcastify Calls protocol, the
+// metadata being posted first and the audio then put to the returned url.
+func (downstream *Downstream) sendBroadcastify(call *Call) error {
+	var buf = bytes.Buffer{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendbroadcastify: %v", err)
+	}
+
+	meta, err := json.Marshal(getDownstreamTrunkRecorderMeta(call))
+	if err != nil {
+		return formatError(err)
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	if w, err := mw.CreateFormFile("metadata", "metadata.json"); err == nil {
+		if _, err = w.Write(meta); err != nil {
+			return formatError(err)
+		}
+	} else {
+		return formatError(err)
+	}
+
+	fields := [][2]string{
+		{"apiKey", downstream.Apikey},
+		{"callDuration", fmt.Sprintf("%.2f", getCallDuration(call))},
+		{"enc", strings.TrimPrefix(filepath.Ext(getDownstreamAudioName(call)), ".")},
+		{"freq", fmt.Sprintf("%v", getDownstreamFrequency(call))},
+		{"src", fmt.Sprintf("%v", getDownstreamSource(call))},
+		{"systemId", downstream.getRemoteSystem(call)},
+		{"tg", fmt.Sprintf("%v", call.Talkgroup)},
+		{"ts", fmt.Sprintf("%v", call.DateTime.Unix())},
+	}
+
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return formatError(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return formatError(err)
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+
+	res, err := c.Post(downstream.Url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		return formatError(err)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return formatError(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return formatError(fmt.Errorf("bad status: %s", res.Status))
+	}
+
+	reply := strings.SplitN(strings.TrimSpace(string(b)), " ", 2)
+	if len(reply) != 2 || reply[0] != "0" {
+		return formatError(fmt.Errorf("upload refused: %s", strings.TrimSpace(string(b))))
+	}
+
+	req, err := http.NewRequest(http.MethodPut, reply[1], bytes.NewReader(call.Audio))
+	if err != nil {
+		return formatError(err)
+	}
+
+	switch v := call.AudioType.(type) {
+	case string:
+		req.Header.Set("Content-Type", v)
+	}
+
+	if res, err = c.Do(req); err != nil {
+		return formatError(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return formatError(fmt.Errorf("bad status: %s", res.Status))
+	}
+
+	return nil
+}
+
+// sendOpenMHz uploads the call to <url>/<remote system>/upload as the
+// OpenMHz uploader of Trunk Recorder does.
+func (downstream *Downstream) sendOpenMHz(call *Call) error {
+	var buf = bytes.Buffer{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendopenmhz: %v", err)
+	}
+
+	meta := getDownstreamTrunkRecorderMeta(call)
+
+	freqList, err := json.Marshal(meta["freqList"])
+	if err != nil {
+		return formatError(err)
+	}
+
+	srcList, err := json.Marshal(meta["srcList"])
+	if err != nil {
+		return formatError(err)
+	}
+
+	duration := getCallDuration(call)
+
+	mw := multipart.NewWriter(&buf)
+
+	if w, err := mw.CreateFormFile("call", getDownstreamAudioName(call)); err == nil {
+		if _, err = w.Write(call.Audio); err != nil {
+			return formatError(err)
+		}
+	} else {
+		return formatError(err)
+	}
+
+	fields := [][2]string{
+		{"api_key", downstream.Apikey},
+		{"call_length", fmt.Sprintf("%.0f", math.Ceil(duration))},
+		{"emergency", fmt.Sprintf("%d", map[bool]int{false: 0, true: 1}[call.emergency])},
+		{"error_count", "0"},
+		{"freq", fmt.Sprintf("%v", getDownstreamFrequency(call))},
+		{"freq_list", string(freqList)},
+		{"source_list", string(srcList)},
+		{"spike_count", "0"},
+		{"start_time", fmt.Sprintf("%v", call.DateTime.Unix())},
+		{"stop_time", fmt.Sprintf("%v", call.DateTime.Add(time.Duration(duration*float64(time.Second))).Unix())},
+		{"talkgroup_num", fmt.Sprintf("%v", call.Talkgroup)},
+	}
+
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return formatError(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return formatError(err)
+	}
+
+	u, err := url.Parse(downstream.Url)
+	if err != nil {
+		return formatError(err)
+	}
+
+	u.Path = path.Join(u.Path, downstream.getRemoteSystem(call), "upload")
+
+	c := http.Client{Timeout: 30 * time.Second}
+
+	res, err := c.Post(u.String(), mw.FormDataContentType(), &buf)
+	if err != nil {
+		return formatError(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return formatError(fmt.Errorf("bad status: %s", res.Status))
+	}
+
+	return nil
+}
+
+// sendWebhook posts the call metadata as json, with either the audio in base64
+// or the url of the audio on this instance when an audio base url is set.
+func (downstream *Downstream) sendWebhook(call *Call) error {
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendwebhook: %v", err)
+	}
+
+	m := map[string]any{
+		"audioName":      call.AudioName,
+		"audioType":      call.AudioType,
+		"dateTime":       call.DateTime.Format(time.RFC3339),
+		"emergency":      call.emergency,
+		"frequencies":    call.Frequencies,
+		"frequency":      call.Frequency,
+		"id":             call.Id,
+		"patches":        call.Patches,
+		"source":         call.Source,
+		"sources":        call.Sources,
+		"system":         call.System,
+		"systemLabel":    call.systemLabel,
+		"talkgroup":      call.Talkgroup,
+		"talkgroupGroup": call.talkgroupGroup,
+		"talkgroupLabel": call.talkgroupLabel,
+		"talkgroupName":  call.talkgroupName,
+		"talkgroupTag":   call.talkgroupTag,
+		"transcript":     call.Transcript,
+	}
+
+	switch v := downstream.AudioBaseUrl.(type) {
+	case string:
+		if len(v) > 0 {
+			m["audioUrl"] = fmt.Sprintf("%s/api/calls/%v/audio", strings.TrimSuffix(v, "/"), call.Id)
+		}
+	}
+
+	if m["audioUrl"] == nil {
+		m["audio"] = base64.StdEncoding.EncodeToString(call.Audio)
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return formatError(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, downstream.Url, bytes.NewReader(b))
+	if err != nil {
+		return formatError(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(downstream.Apikey) > 0 {
+		req.Header.Set("X-Api-Key", downstream.Apikey)
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return formatError(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return formatError(fmt.Errorf("bad status: %s", res.Status))
+	}
+
+	return nil
+}
+
+// getRemoteSystem returns the system of the call on the remote side, the
+// Broadcastify system id or the OpenMHz short name, or else the call system.
+func (downstream *Downstream) getRemoteSystem(call *Call) string {
+	switch v := downstream.RemoteSystem.(type) {
+	case string:
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return fmt.Sprintf("%v", call.System)
+}
+
+// getCallDuration returns the call duration in seconds, from the frequency
+// list of the recorder or else from the header of a wav file.
+func getCallDuration(call *Call) float64 {
+	var duration float64
+
+	for _, f := range getDownstreamList(call.Frequencies) {
+		switch v := f["len"].(type) {
+		case float64:
+			duration += v
+		}
+	}
+
+	if duration > 0 {
+		return duration
+	}
+
+	if len(call.Audio) > 44 && string(call.Audio[0:4]) == "RIFF" && string(call.Audio[8:12]) == "WAVE" {
+		if byteRate := binary.LittleEndian.Uint32(call.Audio[28:32]); byteRate > 0 {
+			return float64(len(call.Audio)-44) / float64(byteRate)
+		}
+	}
+
+	return 0
+}
+
+func getDownstreamAudioName(call *Call) string {
+	switch v := call.AudioName.(type) {
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%v-%v-%v.m4a", call.System, call.Talkgroup, call.DateTime.Unix())
+}
+
+func getDownstreamFrequency(call *Call) any {
+	if call.Frequency == nil {
+		return 0
+	}
+	return call.Frequency
+}
+
+// getDownstreamList normalizes the frequencies or sources of a call, which are
+// either freshly parsed or read back from the database.
+func getDownstreamList(v any) []map[string]any {
+	l := []map[string]any{}
+
+	if b, err := json.Marshal(v); err == nil {
+		json.Unmarshal(b, &l)
+	}
+
+	return l
+}
+
+func getDownstreamSource(call *Call) any {
+	if call.Source == nil {
+		return 0
+	}
+	return call.Source
+}
+
+// getDownstreamTrunkRecorderMeta rebuilds the Trunk Recorder json metadata
+// expected by the Broadcastify and OpenMHz upload formats.
+func getDownstreamTrunkRecorderMeta(call *Call) map[string]any {
+	freqList := []map[string]any{}
+	srcList := []map[string]any{}
+
+	for _, f := range getDownstreamList(call.Frequencies) {
+		freqList = append(freqList, map[string]any{
+			"error_count": f["errorCount"],
+			"freq":        f["freq"],
+			"len":         f["len"],
+			"pos":         f["pos"],
+			"spike_count": f["spikeCount"],
+			"time":        call.DateTime.Unix(),
+		})
+	}
+
+	for _, s := range getDownstreamList(call.Sources) {
+		srcList = append(srcList, map[string]any{
+			"emergency": 0,
+			"pos":       s["pos"],
+			"src":       s["src"],
+			"tag":       "",
+			"time":      call.DateTime.Unix(),
+		})
+	}
+
+	meta := map[string]any{
+		"call_length": math.Ceil(getCallDuration(call)),
+		"emergency":   map[bool]int{false: 0, true: 1}[call.emergency],
+		"freq":        getDownstreamFrequency(call),
+		"freqList":    freqList,
+		"srcList":     srcList,
+		"start_time":  call.DateTime.Unix(),
+		"talkgroup":   call.Talkgroup,
+	}
+
+	switch v := call.Patches.(type) {
+	case []uint:
+		if len(v) > 0 {
+			meta["patched_talkgroups"] = v
+		}
+	}
+
+	switch v := call.talkgroupLabel.(type) {
+	case string:
+		meta["talkgroup_tag"] = v
+	}
+
+	return meta
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownstreamSendBroadcastify(t *testing.T) {
+	var (
+		audio       []byte
+		contentType string
+		fields      = map[string]string{}
+		metadata    map[string]any
+	)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/calls", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for k, v := range r.MultipartForm.Value {
+			fields[k] = v[0]
+		}
+
+		if f, _, err := r.FormFile("metadata"); err == nil {
+			json.NewDecoder(f).Decode(&metadata)
+			f.Close()
+		}
+
+		if fields["apiKey"] == "bad" {
+			fmt.Fprint(w, "1 Invalid API key")
+			return
+		}
+
+		fmt.Fprintf(w, "0 %s/upload", server.URL)
+	})
+
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		contentType = r.Header.Get("Content-Type")
+		audio, _ = io.ReadAll(r.Body)
+	})
+
+	call := newTestDownstreamCall()
+	downstream := &Downstream{Apikey: "key", Kind: DownstreamTypeBroadcastify, RemoteSystem: "42", Url: server.URL + "/calls"}
+
+	if err := downstream.Send(call); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"apiKey":       "key",
+		"callDuration": "2.50",
+		"enc":          "m4a",
+		"freq":         "851000000",
+		"src":          "1001",
+		"systemId":     "42",
+		"tg":           "100",
+		"ts":           fmt.Sprintf("%d", call.DateTime.Unix()),
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("got %s %q, want %q", k, fields[k], v)
+		}
+	}
+
+	if metadata["talkgroup"] != float64(100) {
+		t.Errorf("got metadata %v", metadata)
+	}
+
+	if !bytes.Equal(audio, call.Audio) || contentType != "audio/mp4" {
+		t.Errorf("got audio %q of type %q", audio, contentType)
+	}
+
+	downstream.Apikey = "bad"
+	if err := downstream.Send(call); err == nil {
+		t.Error("got no error on a refused upload")
+	}
+}
+
+func TestDownstreamSendOpenMHz(t *testing.T) {
+	var (
+		audio    []byte
+		fields   = map[string]string{}
+		filename string
+		path     string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for k, v := range r.MultipartForm.Value {
+			fields[k] = v[0]
+		}
+
+		if f, h, err := r.FormFile("call"); err == nil {
+			filename = h.Filename
+			audio, _ = io.ReadAll(f)
+			f.Close()
+		}
+	}))
+	defer server.Close()
+
+	call := newTestDownstreamCall()
+	call.emergency = true
+
+	downstream := &Downstream{Apikey: "key", Kind: DownstreamTypeOpenMHz, RemoteSystem: "metro", Url: server.URL + "/api"}
+
+	if err := downstream.Send(call); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/api/metro/upload" {
+		t.Errorf("got path %q", path)
+	}
+
+	want := map[string]string{
+		"api_key":       "key",
+		"call_length":   "3",
+		"emergency":     "1",
+		"freq":          "851000000",
+		"start_time":    fmt.Sprintf("%d", call.DateTime.Unix()),
+		"stop_time":     fmt.Sprintf("%d", call.DateTime.Unix()+2),
+		"talkgroup_num": "100",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("got %s %q, want %q", k, fields[k], v)
+		}
+	}
+
+	var srcList []map[string]any
+	if err := json.Unmarshal([]byte(fields["source_list"]), &srcList); err != nil || len(srcList) != 1 || srcList[0]["src"] != float64(1001) {
+		t.Errorf("got source list %q", fields["source_list"])
+	}
+
+	if filename != "call.m4a" || !bytes.Equal(audio, call.Audio) {
+		t.Errorf("got audio %q named %q", audio, filename)
+	}
+}
+
+func TestDownstreamSendWebhook(t *testing.T) {
+	var (
+		apikey string
+		body   map[string]any
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apikey = r.Header.Get("X-Api-Key")
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	call := newTestDownstreamCall()
+	downstream := &Downstream{Apikey: "key", Kind: DownstreamTypeWebhook, Url: server.URL}
+
+	if err := downstream.Send(call); err != nil {
+		t.Fatal(err)
+	}
+
+	if apikey != "key" {
+		t.Errorf("got api key %q", apikey)
+	}
+
+	if body["audio"] != base64.StdEncoding.EncodeToString(call.Audio) || body["audioUrl"] != nil {
+		t.Errorf("got audio %v, audio url %v", body["audio"], body["audioUrl"])
+	}
+
+	if body["id"] != float64(7) || body["system"] != float64(1) || body["talkgroup"] != float64(100) || body["dateTime"] != "2022-01-01T12:30:00Z" {
+		t.Errorf("got body %v", body)
+	}
+
+	downstream.AudioBaseUrl = "https://scanner.example.com/"
+
+	if err := downstream.Send(call); err != nil {
+		t.Fatal(err)
+	}
+
+	if body["audio"] != nil || body["audioUrl"] != "https://scanner.example.com/api/calls/7/audio" {
+		t.Errorf("got audio %v, audio url %v", body["audio"], body["audioUrl"])
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	downstream.Url = failing.URL
+	if err := downstream.Send(call); err == nil {
+		t.Error("got no error on a bad status")
+	}
+}
+
+func newTestDownstreamCall() *Call {
+	return &Call{
+		Id:          uint(7),
+		Audio:       []byte("audio"),
+		AudioName:   "call.m4a",
+		AudioType:   "audio/mp4",
+		DateTime:    time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+		Frequencies: []map[string]any{{"freq": uint(851000000), "len": 2.5, "pos": 0.0}},
+		Frequency:   uint(851000000),
+		Source:      uint(1001),
+		Sources:     []map[string]any{{"pos": 0.0, "src": uint(1001)}},
+		System:      1,
+		Talkgroup:   100,
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	EventTypeCall   = "call"
+	EventTypeConfig = "config"
+)
+
+type Event struct {
+	Id      any
+	Payload any
+	Type    string
+}
+
+// EventsClient is a subscriber of the server-sent events feed, narrowed to a
+// talkgroup selection and optionally to a group and a tag label.
+type EventsClient struct {
+	access     *Access
+	controller *Controller
+	group      string
+	livefeed   *Livefeed
+	selection  string
+	send       chan *Event
+	tag        string
+}
+
+func NewEventsClient(controller *Controller, access *Access, selection string, group string, tag string) *EventsClient {
+	return &EventsClient{
+		access:     access,
+		controller: controller,
+		group:      group,
+		livefeed:   newStreamLivefeed(controller, access, selection),
+		selection:  selection,
+		send:       make(chan *Event, 64),
+		tag:        tag,
+	}
+}
+
+func (client *EventsClient) IsEnabled(call *Call) bool {
+	if client.access != nil && !client.access.HasAccess(call) {
+		return false
+	}
+
+	if !client.livefeed.IsEnabled(call) {
+		return false
+	}
+
+	if len(client.group) > 0 && fmt.Sprintf("%v", call.talkgroupGroup) != client.group {
+		return false
+	}
+
+	if len(client.tag) > 0 && fmt.Sprintf("%v", call.talkgroupTag) != client.tag {
+		return false
+	}
+
+	return true
+}
+
+// Run writes the events to w as they come, with a comment line every now and
+// then to keep the connection open through proxies.
+func (client *EventsClient) Run(ctx context.Context, w io.Writer) error {
+	// well within the write timeout, for the connection to stay open when
+	// there is nothing to send
+	const keepAlive = httpWriteTimeout / 2
+
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	if err := client.write(w, client.getConfigEvent()); err != nil {
+		return err
+	}
+	flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+
+		case event := <-client.send:
+			if err := client.write(w, event); err != nil {
+				return err
+			}
+		}
+
+		flush()
+	}
+}
+
+// Send queues the event, which is dropped if the client is too far behind.
+func (client *EventsClient) Send(event *Event) {
+	select {
+	case client.send <- event:
+	default:
+	}
+}
+
+func (client *EventsClient) getConfigEvent() *Event {
+	controller := client.controller
+
+	systemsMap := controller.Systems.GetScopedSystems(&Client{Access: client.access}, controller.Groups, controller.Tags, controller.Options.SortTalkgroups)
+
+	return &Event{
+		Type: EventTypeConfig,
+		Payload: map[string]any{
+			"groups":  controller.Groups.GetGroupsMap(&systemsMap),
+			"systems": systemsMap,
+			"tags":    controller.Tags.GetTagsMap(&systemsMap),
+		},
+	}
+}
+
+func (client *EventsClient) write(w io.Writer, event *Event) error {
+	b, err := json.Marshal(event.Payload)
+	if err != nil {
+		return fmt.Errorf("eventsclient.write: %v", err)
+	}
+
+	if event.Id != nil {
+		if _, err = fmt.Fprintf(w, "id: %v\n", event.Id); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+
+	return err
+}
+
+type Events struct {
+	List  map[*EventsClient]bool
+	mutex sync.Mutex
+}
+
+func NewEvents() *Events {
+	return &Events{
+		List:  map[*EventsClient]bool{},
+		mutex: sync.Mutex{},
+	}
+}
+
+func (events *Events) Add(client *EventsClient) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	events.List[client] = true
+}
+
+func (events *Events) Count() int {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	return len(events.List)
+}
+
+func (events *Events) EmitCall(call *Call) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	if len(events.List) == 0 {
+		return
+	}
+
+	event := &Event{Id: call.Id, Type: EventTypeCall}
+
+	for client := range events.List {
+		if client.IsEnabled(call) {
+			if event.Payload == nil {
+				event.Payload = call.toEvent(client.controller.Systems)
+			}
+			client.Send(event)
+		}
+	}
+}
+
+// EmitConfig sends the systems, groups and tags to the clients once the config
+// changed, their talkgroup selection being rebuilt as well.
+func (events *Events) EmitConfig(controller *Controller) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	for client := range events.List {
+		client.livefeed = newStreamLivefeed(controller, client.access, client.selection)
+		client.Send(client.getConfigEvent())
+	}
+}
+
+func (events *Events) Remove(client *EventsClient) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	delete(events.List, client)
+}
@@ -61,23 +61,24 @@ func NewFFMpeg() *FFMpeg {
 	return ffmpeg
 }
 
-func (ffmpeg *FFMpeg) Convert(call *Call, systems *Systems, tags *Tags, mode uint) error {
+// Convert reports whether the call audio was actually converted.
+func (ffmpeg *FFMpeg) Convert(call *Call, systems *Systems, tags *Tags, mode uint) (bool, error) {
 	var (
 		args = []string{"-i", "-"}
 		err  error
 	)
 
 	if mode == AUDIO_CONVERSION_DISABLED {
-		return nil
+		return false, nil
 	}
 
 	if !ffmpeg.available {
 		if !ffmpeg.warned {
 			ffmpeg.warned = true
 
-			return errors.New("ffmpeg is not available, no audio conversion will be performed")
+			return false, errors.New("ffmpeg is not available, no audio conversion will be performed")
 		}
-		return nil
+		return false, nil
 	}
 
 	if system, ok := systems.GetSystem(call.System); ok {
@@ -122,9 +123,11 @@ func (ffmpeg *FFMpeg) Convert(call *Call, systems *Systems, tags *Tags, mode uin
 			call.AudioName = fmt.Sprintf("%v.m4a", strings.TrimSuffix(v, path.Ext((v))))
 		}
 
+		return true, nil
+
 	} else {
 		fmt.Println(stderr.String())
 	}
 
-	return nil
+	return false, nil
 }
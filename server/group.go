@@ -17,15 +17,14 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
 )
 
 type Group struct {
-	Id    any    `json:"_id"`
-	Label string `json:"label"`
+	Id        any    `json:"_id"`
+	Label     string `json:"label"`
+	PruneDays any    `json:"pruneDays"`
 }
 
 func (group *Group) FromMap(m map[string]any) *Group {
@@ -39,6 +38,11 @@ func (group *Group) FromMap(m map[string]any) *Group {
 		group.Label = v
 	}
 
+	switch v := m["pruneDays"].(type) {
+	case float64:
+		group.PruneDays = uint(v)
+	}
+
 	return group
 }
 
@@ -54,6 +58,26 @@ func NewGroups() *Groups {
 	}
 }
 
+func (groups *Groups) Add(group *Group) (*Groups, bool) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+
+	added := true
+
+	for _, a := range groups.List {
+		if a.Label == group.Label {
+			added = false
+			break
+		}
+	}
+
+	if added {
+		groups.List = append(groups.List, group)
+	}
+
+	return groups, added
+}
+
 func (groups *Groups) FromMap(f []any) *Groups {
 	groups.mutex.Lock()
 	defer groups.mutex.Unlock()
@@ -164,9 +188,10 @@ func (groups *Groups) GetGroupsMap(systemsMap *SystemsMap) GroupsMap {
 
 func (groups *Groups) Read(db *Database) error {
 	var (
-		err  error
-		id   sql.NullFloat64
-		rows *sql.Rows
+		err       error
+		id        sql.NullFloat64
+		pruneDays sql.NullFloat64
+		rows      *sql.Rows
 	)
 
 	groups.mutex.Lock()
@@ -178,14 +203,14 @@ func (groups *Groups) Read(db *Database) error {
 		return fmt.Errorf("groups.read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `label` from `freeScannerGroups`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `label`, `pruneDays` from `freeScannerGroups`"); err != nil {
 		return formatError(err)
 	}
 
 	for rows.Next() {
 		group := &Group{}
 
-		if err = rows.Scan(&id, &group.Label); err != nil {
+		if err = rows.Scan(&id, &group.Label, &pruneDays); err != nil {
 			break
 		}
 
@@ -193,6 +218,10 @@ func (groups *Groups) Read(db *Database) error {
 			group.Id = uint(id.Float64)
 		}
 
+		if pruneDays.Valid {
+			group.PruneDays = uint(pruneDays.Float64)
+		}
+
 		if len(group.Label) == 0 {
 			continue
 		}
@@ -252,14 +281,9 @@ func (groups *Groups) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerGroups` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerGroups").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -269,11 +293,11 @@ func (groups *Groups) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerGroups` (`_id`, `label`) values (?, ?)", group.Id, group.Label); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerGroups` (`_id`, `label`, `pruneDays`) values (?, ?, ?)", group.Id, group.Label, group.PruneDays); err != nil {
 				break
 			}
 
-		} else if _, err = db.Sql.Exec("update `freeScannerGroups` set `_id` = ?, `label` = ? where `_id` = ?", group.Id, group.Label, group.Id); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerGroups` set `_id` = ?, `label` = ?, `pruneDays` = ? where `_id` = ?", group.Id, group.Label, group.PruneDays, group.Id); err != nil {
 			break
 		}
 	}
@@ -87,18 +87,16 @@ func (logs *Logs) Prune(db *Database, pruneDays uint) error {
 	defer logs.mutex.Unlock()
 
 	date := time.Now().Add(-24 * time.Hour * time.Duration(pruneDays)).Format(db.DateTimeFormat)
-	_, err := db.Sql.Exec("delete from `freeScannerLogs` where `dateTime` < ?", date)
+
+	query, args := NewDeleteQuery("freeScannerLogs").Where(db.NewWhere().Add("`dateTime` < ?", date)).Build()
+	_, err := db.Sql.Exec(query, args...)
 
 	return err
 }
 
 func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsSearchResults, error) {
-	const (
-		ascOrder  = "asc"
-		descOrder = "desc"
-	)
-
 	var (
+		args     []any
 		dateTime any
 		err      error
 		id       sql.NullFloat64
@@ -107,7 +105,6 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 		order    string
 		query    string
 		rows     *sql.Rows
-		where    string = "true"
 	)
 
 	logs.mutex.Lock()
@@ -122,20 +119,22 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 		Logs:    []Log{},
 	}
 
+	where := db.NewWhere()
+
 	switch v := searchOptions.Level.(type) {
 	case string:
-		where += fmt.Sprintf(" and `level` = '%v'", v)
+		where.Equal("level", v)
 	}
 
 	switch v := searchOptions.Sort.(type) {
 	case int:
 		if v < 0 {
-			order = descOrder
+			order = QueryOrderDesc
 		} else {
-			order = ascOrder
+			order = QueryOrderAsc
 		}
 	default:
-		order = ascOrder
+		order = QueryOrderAsc
 	}
 
 	switch v := searchOptions.Date.(type) {
@@ -146,7 +145,7 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 			stop  time.Time
 		)
 
-		if order == ascOrder {
+		if order == QueryOrderAsc {
 			start = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), 0, 0, time.UTC)
 			stop = start.Add(time.Hour*24 - time.Millisecond)
 
@@ -155,7 +154,7 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 			stop = start.Add(time.Hour*24 - time.Millisecond - time.Duration(v.Hour())).Add(time.Minute * time.Duration(-v.Minute()))
 		}
 
-		where += fmt.Sprintf(" and (`dateTime` between '%v' and '%v')", start.Format(df), stop.Format(df))
+		where.Between("dateTime", start.Format(df), stop.Format(df))
 	}
 
 	switch v := searchOptions.Limit.(type) {
@@ -170,8 +169,8 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 		offset = v
 	}
 
-	query = fmt.Sprintf("select `dateTime` from `freeScannerLogs` where %v order by `dateTime` asc", where)
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerLogs", "dateTime").Where(where).OrderBy("dateTime", QueryOrderAsc).Limit(1, 0).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -179,8 +178,8 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 		logResults.DateStart = t
 	}
 
-	query = fmt.Sprintf("select `dateTime` from `freeScannerLogs` where %v order by `dateTime` asc", where)
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerLogs", "dateTime").Where(where).OrderBy("dateTime", QueryOrderDesc).Limit(1, 0).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -188,13 +187,13 @@ func (logs *Logs) Search(searchOptions *LogsSearchOptions, db *Database) (*LogsS
 		logResults.DateStop = t
 	}
 
-	query = fmt.Sprintf("select count(*) from `freeScannerLogs` where %v", where)
-	if err = db.Sql.QueryRow(query).Scan(&logResults.Count); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerLogs", "count(*)").Where(where).Build()
+	if err = db.Sql.QueryRow(query, args...).Scan(&logResults.Count); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
-	query = fmt.Sprintf("select `_id`, `DateTime`, `level`, `message` from `freeScannerLogs` where %v order by `dateTime` %v limit %v offset %v", where, order, limit, offset)
-	if rows, err = db.Sql.Query(query); err != nil && err != sql.ErrNoRows {
+	query, args = NewSelectQuery("freeScannerLogs", "_id", "dateTime", "level", "message").Where(where).OrderBy("dateTime", order).Limit(limit, offset).Build()
+	if rows, err = db.Sql.Query(query, args...); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -0,0 +1,86 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogsSearchDates(t *testing.T) {
+	db := newTestDatabase(t)
+
+	first := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	last := time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	for _, d := range []time.Time{last, first, first.Add(time.Hour)} {
+		if _, err := db.Sql.Exec("insert into `freeScannerLogs` (`dateTime`, `level`, `message`) values (?, ?, ?)", d.Format(db.DateTimeFormat), LogLevelInfo, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := NewLogs().Search(NewLogSearchOptions(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !results.DateStart.Equal(first) {
+		t.Errorf("got date start %v, want %v", results.DateStart, first)
+	}
+	if !results.DateStop.Equal(last) {
+		t.Errorf("got date stop %v, want %v", results.DateStop, last)
+	}
+	if results.Count != 3 {
+		t.Errorf("got count %v, want 3", results.Count)
+	}
+}
+
+func TestLogsSearchHostile(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if _, err := db.Sql.Exec("insert into `freeScannerLogs` (`dateTime`, `level`, `message`) values (?, ?, ?)", time.Now().UTC().Format(db.DateTimeFormat), LogLevelInfo, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []string{"x' or '1'='1", "info' --", "info\"; drop table `freeScannerLogs`; --"} {
+		results, err := NewLogs().Search(&LogsSearchOptions{Level: level}, db)
+		if err != nil {
+			t.Errorf("level %q: %v", level, err)
+			continue
+		}
+
+		if results.Count != 0 || len(results.Logs) != 0 {
+			t.Errorf("level %q: got %d logs", level, results.Count)
+		}
+	}
+
+	results, err := NewLogs().Search(&LogsSearchOptions{Level: LogLevelInfo}, db)
+	if err != nil || results.Count != 1 {
+		t.Errorf("got %v logs after the hostile searches, %v", results, err)
+	}
+}
+
+// newTestDatabase opens a migrated sqlite database in a temporary directory.
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	db := NewDatabase(&Config{AudioDir: "audio", BaseDir: dir, DbFile: "test.db", DbType: DbTypeSqlite})
+	t.Cleanup(func() { db.Sql.Close() })
+
+	return db
+}
@@ -30,7 +30,6 @@ import (
 
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -49,25 +48,39 @@ func main() {
 	controller := NewController(config)
 
 	if config.newAdminPassword != "" {
-		if hash, err := bcrypt.GenerateFromPassword([]byte(config.newAdminPassword), bcrypt.DefaultCost); err == nil {
-			if err := controller.Options.Read(controller.Database); err != nil {
-				log.Fatal(err)
-			}
+		if err := controller.Options.Read(controller.Database); err != nil {
+			log.Fatal(err)
+		}
 
-			controller.Options.adminPassword = string(hash)
-			controller.Options.adminPasswordNeedChange = config.newAdminPassword == defaults.adminPassword
+		if err := controller.AdminUsers.Read(controller.Database); err != nil {
+			log.Fatal(err)
+		}
 
-			if err := controller.Options.Write(controller.Database); err != nil {
-				log.Fatal(err)
-			}
+		if err := controller.AdminUsers.Seed(controller.Database, controller.Options); err != nil {
+			log.Fatal(err)
+		}
 
-			controller.Logs.LogEvent(LogLevelInfo, "admin password changed.")
+		user, ok := controller.AdminUsers.GetUser(config.newAdminUsername)
+		if !ok {
+			user = &AdminUser{Role: AdminRoleOwner, Username: config.newAdminUsername}
+			controller.AdminUsers.Add(user)
+		}
 
-			os.Exit(0)
+		if err := user.Validate(); err != nil {
+			log.Fatal(err)
+		}
 
-		} else {
+		if err := user.SetPassword(config.newAdminPassword); err != nil {
 			log.Fatal(err)
 		}
+
+		if err := controller.AdminUsers.Write(controller.Database); err != nil {
+			log.Fatal(err)
+		}
+
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("admin password changed for %s.", user.Username))
+
+		os.Exit(0)
 	}
 
 	fmt.Printf("\nFreeScanner v%s\n", Version)
@@ -105,8 +118,20 @@ func main() {
 		sslAddr = defaultAddr
 	}
 
+	http.HandleFunc("/api/admin/admin-user-add", controller.Admin.AdminUserAddHandler)
+
+	http.HandleFunc("/api/admin/admin-user-remove", controller.Admin.AdminUserRemoveHandler)
+
+	http.HandleFunc("/api/admin/admin-users", controller.Admin.AdminUsersHandler)
+
+	http.HandleFunc("/api/admin/call-keep", controller.Admin.CallKeepHandler)
+
 	http.HandleFunc("/api/admin/config", controller.Admin.ConfigHandler)
 
+	http.HandleFunc("/api/admin/config-rollback", controller.Admin.ConfigRollbackHandler)
+
+	http.HandleFunc("/api/admin/config-versions", controller.Admin.ConfigVersionsHandler)
+
 	http.HandleFunc("/api/admin/login", controller.Admin.LoginHandler)
 
 	http.HandleFunc("/api/admin/logout", controller.Admin.LogoutHandler)
@@ -115,14 +140,40 @@ func main() {
 
 	http.HandleFunc("/api/admin/password", controller.Admin.PasswordHandler)
 
+	http.HandleFunc("/api/admin/session-revoke", controller.Admin.SessionRevokeHandler)
+
+	http.HandleFunc("/api/admin/sessions", controller.Admin.SessionsHandler)
+
+	http.HandleFunc("/api/admin/totp-disable", controller.Admin.TotpDisableHandler)
+
+	http.HandleFunc("/api/admin/totp-enable", controller.Admin.TotpEnableHandler)
+
+	http.HandleFunc("/api/admin/totp-setup", controller.Admin.TotpSetupHandler)
+
 	http.HandleFunc("/api/admin/user-add", controller.Admin.UserAddHandler)
 
 	http.HandleFunc("/api/admin/user-remove", controller.Admin.UserRemoveHandler)
 
+	http.HandleFunc("/api/broadcastify-call-upload", controller.Api.BroadcastifyCallUploadHandler)
+
+	http.HandleFunc("/api/broadcastify-call-upload/", controller.Api.BroadcastifyCallUploadHandler)
+
 	http.HandleFunc("/api/call-upload", controller.Api.CallUploadHandler)
 
+	http.HandleFunc("/api/calls", controller.Api.CallsHandler)
+
+	http.HandleFunc("/api/calls/", controller.Api.CallsHandler)
+
+	http.HandleFunc("/api/op25-call-upload", controller.Api.OP25CallUploadHandler)
+
+	http.HandleFunc("/api/events", controller.Api.EventsHandler)
+
+	http.HandleFunc("/api/stream", controller.Api.StreamHandler)
+
 	http.HandleFunc("/api/trunk-recorder-call-upload", controller.Api.TrunkRecorderCallUploadHandler)
 
+	http.HandleFunc("/metrics", controller.Metrics.MetricsHandler)
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		url := r.URL.Path[1:]
 
@@ -193,12 +244,15 @@ func main() {
 	}
 
 	newServer := func(addr string, tlsConfig *tls.Config) *http.Server {
+		// no write timeout on the server, which would cut the audio streams
+		// and the event feeds, the handler setting it per request instead
 		s := &http.Server{
-			Addr:         addr,
-			TLSConfig:    tlsConfig,
-			ReadTimeout:  30 * time.Second,
-			WriteTimeout: 30 * time.Second,
-			ErrorLog:     log.New(io.Discard, "", 0),
+			Addr:        addr,
+			ConnContext: httpConnContext,
+			Handler:     withWriteTimeout(http.DefaultServeMux, "/api/events", "/api/stream"),
+			TLSConfig:   tlsConfig,
+			ReadTimeout: 30 * time.Second,
+			ErrorLog:    log.New(io.Discard, "", 0),
 		}
 
 		s.SetKeepAlivesEnabled(true)
@@ -31,6 +31,7 @@ const (
 	MessageCommandPin            = "PIN"
 	MessageCommandPushId         = "PID"
 	MessageCommandServer         = "SRV"
+	MessageCommandTranscript     = "TRN"
 	MessageCommandVersion        = "VER"
 )
 
@@ -0,0 +1,230 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MetricsRejectBadKey      = "bad_key"
+	MetricsRejectBlacklisted = "blacklisted"
+	MetricsRejectDuplicate   = "duplicate"
+	MetricsRejectInvalid     = "invalid"
+	MetricsRejectRateLimited = "rate_limited"
+)
+
+var metricsConversionBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics collects the counters exposed in the prometheus text format on
+// /metrics. Counters are keyed by their rendered label set.
+type Metrics struct {
+	Controller         *Controller
+	alertsSent         map[string]uint64
+	callsConverted     map[string]uint64
+	callsIngested      map[string]uint64
+	callsRejected      map[string]uint64
+	conversionBuckets  []uint64
+	conversionCount    uint64
+	conversionSum      float64
+	dirwatchEvents     map[string]uint64
+	downstreamRequests map[string]uint64
+	mutex              sync.Mutex
+	quotaPrunedBytes   uint64
+	quotaPrunedCalls   uint64
+}
+
+func NewMetrics(controller *Controller) *Metrics {
+	return &Metrics{
+		Controller:         controller,
+		alertsSent:         map[string]uint64{},
+		callsConverted:     map[string]uint64{},
+		callsIngested:      map[string]uint64{},
+		callsRejected:      map[string]uint64{},
+		conversionBuckets:  make([]uint64, len(metricsConversionBuckets)),
+		dirwatchEvents:     map[string]uint64{},
+		downstreamRequests: map[string]uint64{},
+		mutex:              sync.Mutex{},
+	}
+}
+
+func (metrics *Metrics) AlertSent(alert *Alert, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.alertsSent[metricsLabels("sink", alert.Sink, "status", status)]++
+}
+
+func (metrics *Metrics) CallConverted(call *Call, duration time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.callsConverted[metricsLabels("system", fmt.Sprint(call.System))]++
+
+	seconds := duration.Seconds()
+
+	for i, le := range metricsConversionBuckets {
+		if seconds <= le {
+			metrics.conversionBuckets[i]++
+		}
+	}
+
+	metrics.conversionCount++
+	metrics.conversionSum += seconds
+}
+
+func (metrics *Metrics) CallIngested(call *Call) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.callsIngested[metricsLabels("system", fmt.Sprint(call.System))]++
+}
+
+func (metrics *Metrics) CallRejected(call *Call, reason string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.callsRejected[metricsLabels("reason", reason, "system", fmt.Sprint(call.System))]++
+}
+
+func (metrics *Metrics) DirwatchEvent(dirwatch *Dirwatch, event string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.dirwatchEvents[metricsLabels("directory", dirwatch.Directory, "event", event)]++
+}
+
+func (metrics *Metrics) DownstreamSent(downstream *Downstream, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.downstreamRequests[metricsLabels("status", status, "url", downstream.Url)]++
+}
+
+func (metrics *Metrics) QuotaPruned(calls int64, bytes int64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.quotaPrunedBytes += uint64(bytes)
+	metrics.quotaPrunedCalls += uint64(calls)
+}
+
+func (metrics *Metrics) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if token := metrics.Controller.Config.MetricsToken; len(token) > 0 {
+		b := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(b), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		buf := bytes.Buffer{}
+
+		metrics.write(&buf)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (metrics *Metrics) write(w io.Writer) {
+	writeHeader := func(name string, kind string, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	writeCounter := func(name string, help string, values map[string]uint64) {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeHeader(name, "counter", help)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, k, values[k])
+		}
+	}
+
+	writeGauge := func(name string, help string, value int) {
+		writeHeader(name, "gauge", help)
+		fmt.Fprintf(w, "%s %d\n", name, value)
+	}
+
+	metrics.mutex.Lock()
+
+	writeCounter("freescanner_calls_ingested_total", "Calls written to the database.", metrics.callsIngested)
+	writeCounter("freescanner_calls_rejected_total", "Calls rejected before being written to the database.", metrics.callsRejected)
+	writeCounter("freescanner_calls_converted_total", "Calls converted by ffmpeg.", metrics.callsConverted)
+
+	writeHeader("freescanner_conversion_duration_seconds", "histogram", "Time spent converting calls with ffmpeg.")
+	for i, le := range metricsConversionBuckets {
+		fmt.Fprintf(w, "freescanner_conversion_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), metrics.conversionBuckets[i])
+	}
+	fmt.Fprintf(w, "freescanner_conversion_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.conversionCount)
+	fmt.Fprintf(w, "freescanner_conversion_duration_seconds_sum %s\n", strconv.FormatFloat(metrics.conversionSum, 'g', -1, 64))
+	fmt.Fprintf(w, "freescanner_conversion_duration_seconds_count %d\n", metrics.conversionCount)
+
+	writeCounter("freescanner_downstream_requests_total", "Calls sent to downstream instances.", metrics.downstreamRequests)
+	writeCounter("freescanner_dirwatch_events_total", "Filesystem events seen by the directory watchers.", metrics.dirwatchEvents)
+	writeCounter("freescanner_alerts_sent_total", "Alert notifications sent.", metrics.alertsSent)
+
+	writeHeader("freescanner_quota_pruned_calls_total", "counter", "Calls removed to stay within the disk quota.")
+	fmt.Fprintf(w, "freescanner_quota_pruned_calls_total %d\n", metrics.quotaPrunedCalls)
+	writeHeader("freescanner_quota_pruned_bytes_total", "counter", "Bytes freed to stay within the disk quota.")
+	fmt.Fprintf(w, "freescanner_quota_pruned_bytes_total %d\n", metrics.quotaPrunedBytes)
+
+	metrics.mutex.Unlock()
+
+	writeGauge("freescanner_ingest_queue_depth", "Calls waiting to be ingested.", metrics.Controller.IngestQueueDepth())
+	writeGauge("freescanner_listeners", "Connected listeners.", metrics.Controller.Clients.Count())
+}
+
+// metricsLabels renders label pairs, which must be given sorted by name.
+func metricsLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(pairs)/2)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+
+	return strings.Join(labels, ",")
+}
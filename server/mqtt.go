@@ -0,0 +1,178 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	MqttStatusOffline = "offline"
+	MqttStatusOnline  = "online"
+)
+
+// Mqtt publishes the call events, the listeners count and the server status
+// to an mqtt broker. Topics are relative to the base topic of the config,
+// <base>/status being retained and set to offline by the broker when the
+// connection is lost.
+type Mqtt struct {
+	client     mqtt.Client
+	controller *Controller
+}
+
+func NewMqtt(controller *Controller) *Mqtt {
+	return &Mqtt{controller: controller}
+}
+
+func (m *Mqtt) IsEnabled() bool {
+	return m.client != nil
+}
+
+// EmitCall publishes the call on the call topic of the config, like
+// <base>/calls/{system}/{talkgroup}.
+func (m *Mqtt) EmitCall(call *Call) {
+	if !m.IsEnabled() {
+		return
+	}
+
+	m.publish(m.getCallTopic(call), false, call.toEvent(m.controller.Systems))
+}
+
+func (m *Mqtt) EmitListenersCount(count int) {
+	if !m.IsEnabled() {
+		return
+	}
+
+	m.publish(m.getTopic("listeners"), true, map[string]any{
+		"count":    count,
+		"dateTime": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (m *Mqtt) Start() error {
+	config := m.controller.Config
+
+	if len(config.MqttBroker) == 0 {
+		return nil
+	}
+
+	status, err := m.getStatus(MqttStatusOffline)
+	if err != nil {
+		return fmt.Errorf("mqtt.start: %v", err)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.MqttBroker).
+		SetAutoReconnect(true).
+		SetClientID(config.MqttClientId).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetPassword(config.MqttPassword).
+		SetUsername(config.MqttUsername).
+		SetWill(m.getTopic("status"), string(status), 1, true)
+
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		m.controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("mqtt: connection lost, %v", err))
+	})
+
+	opts.SetOnConnectHandler(func(_ mqtt.Client) {
+		m.publishStatus(MqttStatusOnline)
+	})
+
+	m.client = mqtt.NewClient(opts)
+	m.client.Connect()
+
+	return nil
+}
+
+// Stop publishes the offline status and disconnects from the broker.
+func (m *Mqtt) Stop() {
+	if !m.IsEnabled() {
+		return
+	}
+
+	if m.client.IsConnectionOpen() {
+		m.publishStatus(MqttStatusOffline)
+	}
+
+	m.client.Disconnect(1000)
+}
+
+// getCallTopic fills the placeholders of the call topic, the labels being
+// stripped of the characters that mqtt reserves.
+func (m *Mqtt) getCallTopic(call *Call) string {
+	label := func(v any) string {
+		if v == nil {
+			return ""
+		}
+		return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(fmt.Sprintf("%v", v))
+	}
+
+	return m.getTopic(strings.NewReplacer(
+		"{group}", label(call.talkgroupGroup),
+		"{system}", fmt.Sprintf("%v", call.System),
+		"{systemLabel}", label(call.systemLabel),
+		"{tag}", label(call.talkgroupTag),
+		"{talkgroup}", fmt.Sprintf("%v", call.Talkgroup),
+		"{talkgroupLabel}", label(call.talkgroupLabel),
+	).Replace(m.controller.Config.MqttCallTopic))
+}
+
+func (m *Mqtt) getStatus(status string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"dateTime": time.Now().UTC().Format(time.RFC3339),
+		"status":   status,
+		"version":  Version,
+	})
+}
+
+func (m *Mqtt) getTopic(topic string) string {
+	if base := strings.TrimSuffix(m.controller.Config.MqttTopic, "/"); len(base) > 0 {
+		return fmt.Sprintf("%s/%s", base, topic)
+	}
+	return topic
+}
+
+func (m *Mqtt) publish(topic string, retained bool, payload any) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		m.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("mqtt.publish: %v", err))
+		return
+	}
+
+	token := m.client.Publish(topic, 1, retained, b)
+
+	go func() {
+		if token.WaitTimeout(30*time.Second) && token.Error() != nil {
+			m.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("mqtt.publish: %s, %v", topic, token.Error()))
+		}
+	}()
+}
+
+func (m *Mqtt) publishStatus(status string) {
+	b, err := m.getStatus(status)
+	if err != nil {
+		m.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("mqtt.publishstatus: %v", err))
+		return
+	}
+
+	m.client.Publish(m.getTopic("status"), 1, true, b).WaitTimeout(5 * time.Second)
+}
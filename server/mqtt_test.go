@@ -0,0 +1,349 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMqttBroker(t *testing.T) {
+	broker := newTestMqttBroker(t)
+
+	controller := &Controller{
+		Config: &Config{
+			MqttBroker:    "tcp://" + broker.addr,
+			MqttCallTopic: "calls/{system}/{talkgroup}",
+			MqttClientId:  "freescanner-test",
+			MqttTopic:     "freescanner",
+		},
+		Logs:    NewLogs(),
+		Systems: NewSystems(),
+	}
+
+	m := NewMqtt(controller)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	getStatus := func(message testMqttMessage) string {
+		status := map[string]any{}
+		if err := json.Unmarshal(message.payload, &status); err != nil {
+			t.Fatalf("got status %q, %v", message.payload, err)
+		}
+		if status["version"] != Version {
+			t.Errorf("got status %s without version", message.payload)
+		}
+		s, _ := status["status"].(string)
+		return s
+	}
+
+	online := broker.wait(t, "freescanner/status")
+	if status := getStatus(online); status != MqttStatusOnline || !online.retain {
+		t.Errorf("got status %q retained %v on connect", status, online.retain)
+	}
+
+	will := broker.getWill()
+	if will.topic != "freescanner/status" || !will.retain || getStatus(will) != MqttStatusOffline {
+		t.Errorf("got will %q %s retained %v", will.topic, will.payload, will.retain)
+	}
+
+	m.EmitCall(&Call{Id: uint(7), DateTime: time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC), System: 1, Talkgroup: 100})
+
+	call := broker.wait(t, "freescanner/calls/1/100")
+	if call.retain {
+		t.Error("got a retained call event")
+	}
+
+	event := map[string]any{}
+	if err := json.Unmarshal(call.payload, &event); err != nil || event["id"] != float64(7) || event["talkgroup"] != float64(100) {
+		t.Errorf("got call event %s, %v", call.payload, err)
+	}
+
+	m.EmitListenersCount(3)
+
+	listeners := broker.wait(t, "freescanner/listeners")
+	count := map[string]any{}
+	if err := json.Unmarshal(listeners.payload, &count); err != nil || count["count"] != float64(3) || !listeners.retain {
+		t.Errorf("got listeners %s retained %v, %v", listeners.payload, listeners.retain, err)
+	}
+
+	m.Stop()
+
+	offline := broker.wait(t, "freescanner/status")
+	if status := getStatus(offline); status != MqttStatusOffline || !offline.retain {
+		t.Errorf("got status %q retained %v on stop", status, offline.retain)
+	}
+
+	select {
+	case <-broker.disconnected:
+	case <-time.After(5 * time.Second):
+		t.Error("got no disconnect on stop")
+	}
+}
+
+func TestMqttGetCallTopic(t *testing.T) {
+	call := &Call{
+		System:         1,
+		Talkgroup:      100,
+		systemLabel:    "Metro/East",
+		talkgroupGroup: "Fire",
+		talkgroupLabel: "Dispatch #1+",
+		talkgroupTag:   "Operations",
+	}
+
+	tests := []struct {
+		base  string
+		topic string
+		want  string
+	}{
+		{base: "freescanner", topic: "calls/{system}/{talkgroup}", want: "freescanner/calls/1/100"},
+		{base: "freescanner/", topic: "calls/{system}/{talkgroup}", want: "freescanner/calls/1/100"},
+		{base: "", topic: "calls/{system}/{talkgroup}", want: "calls/1/100"},
+		{base: "scanner", topic: "{systemLabel}/{talkgroupLabel}", want: "scanner/Metro_East/Dispatch _1_"},
+		{base: "scanner", topic: "{group}/{tag}/{talkgroup}", want: "scanner/Fire/Operations/100"},
+	}
+
+	for _, test := range tests {
+		m := NewMqtt(&Controller{Config: &Config{MqttCallTopic: test.topic, MqttTopic: test.base}})
+
+		if topic := m.getCallTopic(call); topic != test.want {
+			t.Errorf("base %q topic %q: got %q, want %q", test.base, test.topic, topic, test.want)
+		}
+	}
+
+	m := NewMqtt(&Controller{Config: &Config{MqttCallTopic: "{systemLabel}/{talkgroup}", MqttTopic: "scanner"}})
+	if topic := m.getCallTopic(&Call{System: 2, Talkgroup: 200}); topic != "scanner//200" {
+		t.Errorf("got %q for a call without labels", topic)
+	}
+}
+
+func TestCallToEvent(t *testing.T) {
+	systems := NewSystems()
+	systems.List = append(systems.List, &System{Id: 1, Units: &Units{List: []*Unit{{Id: 1001, Label: "Engine 5"}}}})
+
+	call := &Call{
+		Id:             uint(7),
+		Audio:          []byte("audio"),
+		DateTime:       time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+		Frequencies:    []map[string]any{{"freq": uint(851000000), "len": 2.5}},
+		Frequency:      uint(851000000),
+		Source:         uint(1001),
+		Sources:        []map[string]any{{"pos": 0.0, "src": uint(1001)}, {"pos": 1.0, "src": uint(1002)}},
+		System:         1,
+		Talkgroup:      100,
+		Transcript:     "structure fire",
+		emergency:      true,
+		systemLabel:    "Metro",
+		talkgroupGroup: "Fire",
+		talkgroupLabel: "Dispatch",
+		talkgroupName:  "Fire Dispatch",
+		talkgroupTag:   "Operations",
+	}
+
+	b, err := json.Marshal(call.toEvent(systems))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]any{}
+	if err = json.Unmarshal(b, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"dateTime":       "2022-01-01T12:30:00Z",
+		"duration":       2.5,
+		"emergency":      true,
+		"frequency":      float64(851000000),
+		"id":             float64(7),
+		"source":         float64(1001),
+		"system":         float64(1),
+		"systemLabel":    "Metro",
+		"talkgroup":      float64(100),
+		"talkgroupGroup": "Fire",
+		"talkgroupLabel": "Dispatch",
+		"talkgroupName":  "Fire Dispatch",
+		"talkgroupTag":   "Operations",
+		"units": []any{
+			map[string]any{"id": float64(1001), "label": "Engine 5"},
+			map[string]any{"id": float64(1002)},
+		},
+	}
+
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("got event %s", b)
+	}
+}
+
+type testMqttBroker struct {
+	addr         string
+	disconnected chan bool
+	messages     chan testMqttMessage
+	will         chan testMqttMessage
+}
+
+type testMqttMessage struct {
+	payload []byte
+	retain  bool
+	topic   string
+}
+
+// newTestMqttBroker starts a minimal mqtt 3.1.1 broker that accepts a single
+// client and hands over its will and the messages it publishes, the test being
+// skipped when no broker can listen.
+func newTestMqttBroker(t *testing.T) *testMqttBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no mqtt broker, %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	broker := &testMqttBroker{
+		addr:         listener.Addr().String(),
+		disconnected: make(chan bool, 1),
+		messages:     make(chan testMqttMessage, 64),
+		will:         make(chan testMqttMessage, 1),
+	}
+
+	readString := func(b []byte) (string, []byte) {
+		if len(b) < 2 {
+			return "", nil
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			return "", nil
+		}
+		return string(b[2 : 2+n]), b[2+n:]
+	}
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			header, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+
+			length, multiplier := 0, 1
+			for {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return
+				}
+				length += int(b&127) * multiplier
+				multiplier *= 128
+				if b&128 == 0 {
+					break
+				}
+			}
+
+			packet := make([]byte, length)
+			if _, err = io.ReadFull(reader, packet); err != nil {
+				return
+			}
+
+			switch header >> 4 {
+			case 1: // connect
+				_, rest := readString(packet)
+				if len(rest) < 4 {
+					return
+				}
+				flags := rest[1]
+				_, rest = readString(rest[4:])
+
+				if flags&0x04 != 0 {
+					will := testMqttMessage{retain: flags&0x20 != 0}
+					var payload string
+					will.topic, rest = readString(rest)
+					payload, _ = readString(rest)
+					will.payload = []byte(payload)
+					broker.will <- will
+				}
+
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+
+			case 3: // publish
+				message := testMqttMessage{retain: header&0x01 != 0}
+				rest := packet
+				message.topic, rest = readString(rest)
+				if qos := (header >> 1) & 0x03; qos > 0 && len(rest) >= 2 {
+					conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+					rest = rest[2:]
+				}
+				message.payload = rest
+				broker.messages <- message
+
+			case 12: // pingreq
+				conn.Write([]byte{0xd0, 0x00})
+
+			case 14: // disconnect
+				broker.disconnected <- true
+				return
+			}
+		}
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		serve(conn)
+	}()
+
+	return broker
+}
+
+func (broker *testMqttBroker) getWill() testMqttMessage {
+	select {
+	case will := <-broker.will:
+		return will
+	default:
+		return testMqttMessage{}
+	}
+}
+
+// wait returns the next message published to the topic, the messages of other
+// topics being skipped.
+func (broker *testMqttBroker) wait(t *testing.T, topic string) testMqttMessage {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case message := <-broker.messages:
+			if message.topic == topic {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("got no message on %s", topic)
+			return testMqttMessage{}
+		}
+	}
+}
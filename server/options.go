@@ -25,12 +25,16 @@ import (
 )
 
 type Options struct {
+	AdminSessionIdle            uint   `json:"adminSessionIdle"`
+	AdminSessionMaxAge          uint   `json:"adminSessionMaxAge"`
 	AfsSystems                  string `json:"afsSystems"`
 	AudioConversion             uint   `json:"audioConversion"`
 	AutoPopulate                bool   `json:"autoPopulate"`
 	Branding                    string `json:"branding"`
 	DimmerDelay                 uint   `json:"dimmerDelay"`
 	DisableDuplicateDetection   bool   `json:"disableDuplicateDetection"`
+	DownstreamRetryMaxAge       uint   `json:"downstreamRetryMaxAge"`
+	DownstreamRetryMaxAttempts  uint   `json:"downstreamRetryMaxAttempts"`
 	DuplicateDetectionTimeFrame uint   `json:"duplicateDetectionTimeFrame"`
 	Email                       string `json:"email"`
 	DisableBeeps                bool   `json:"disableBeeps"`
@@ -38,6 +42,8 @@ type Options struct {
 	MaxClients                  uint   `json:"maxClients"`
 	PlaybackGoesLive            bool   `json:"playbackGoesLive"`
 	PruneDays                   uint   `json:"pruneDays"`
+	PruneMaxSize                uint   `json:"pruneMaxSize"`
+	PruneMinFree                uint   `json:"pruneMinFree"`
 	SearchPatchedTalkgroups     bool   `json:"searchPatchedTalkgroups"`
 	ShowListenersCount          bool   `json:"showListenersCount"`
 	SortTalkgroups              bool   `json:"sortTalkgroups"`
@@ -66,6 +72,20 @@ func (options *Options) FromMap(m map[string]any) *Options {
 	options.mutex.Lock()
 	defer options.mutex.Unlock()
 
+	switch v := m["adminSessionIdle"].(type) {
+	case float64:
+		options.AdminSessionIdle = uint(v)
+	default:
+		options.AdminSessionIdle = defaults.options.adminSessionIdle
+	}
+
+	switch v := m["adminSessionMaxAge"].(type) {
+	case float64:
+		options.AdminSessionMaxAge = uint(v)
+	default:
+		options.AdminSessionMaxAge = defaults.options.adminSessionMaxAge
+	}
+
 	switch v := m["afsSystems"].(type) {
 	case string:
 		options.AfsSystems = v
@@ -113,6 +133,20 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.DisableDuplicateDetection = defaults.options.disableDuplicateDetection
 	}
 
+	switch v := m["downstreamRetryMaxAge"].(type) {
+	case float64:
+		options.DownstreamRetryMaxAge = uint(v)
+	default:
+		options.DownstreamRetryMaxAge = defaults.options.downstreamRetryMaxAge
+	}
+
+	switch v := m["downstreamRetryMaxAttempts"].(type) {
+	case float64:
+		options.DownstreamRetryMaxAttempts = uint(v)
+	default:
+		options.DownstreamRetryMaxAttempts = defaults.options.downstreamRetryMaxAttempts
+	}
+
 	switch v := m["duplicateDetectionTimeFrame"].(type) {
 	case float64:
 		options.DuplicateDetectionTimeFrame = uint(v)
@@ -158,6 +192,20 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.PruneDays = defaults.options.pruneDays
 	}
 
+	switch v := m["pruneMaxSize"].(type) {
+	case float64:
+		options.PruneMaxSize = uint(v)
+	default:
+		options.PruneMaxSize = defaults.options.pruneMaxSize
+	}
+
+	switch v := m["pruneMinFree"].(type) {
+	case float64:
+		options.PruneMinFree = uint(v)
+	default:
+		options.PruneMinFree = defaults.options.pruneMinFree
+	}
+
 	switch v := m["searchPatchedTalkgroups"].(type) {
 	case bool:
 		options.SearchPatchedTalkgroups = v
@@ -210,16 +258,22 @@ func (options *Options) Read(db *Database) error {
 
 	options.adminPassword = string(defaultPassword)
 	options.adminPasswordNeedChange = defaults.adminPasswordNeedChange
+	options.AdminSessionIdle = defaults.options.adminSessionIdle
+	options.AdminSessionMaxAge = defaults.options.adminSessionMaxAge
 	options.AudioConversion = defaults.options.audioConversion
 	options.AutoPopulate = defaults.options.autoPopulate
 	options.DimmerDelay = defaults.options.dimmerDelay
 	options.DisableDuplicateDetection = defaults.options.disableDuplicateDetection
+	options.DownstreamRetryMaxAge = defaults.options.downstreamRetryMaxAge
+	options.DownstreamRetryMaxAttempts = defaults.options.downstreamRetryMaxAttempts
 	options.DuplicateDetectionTimeFrame = defaults.options.duplicateDetectionTimeFrame
 	options.KeypadBeeps = defaults.options.keypadBeeps
 	options.DisableBeeps = defaults.options.disableBeeps
 	options.MaxClients = defaults.options.maxClients
 	options.PlaybackGoesLive = defaults.options.playbackGoesLive
 	options.PruneDays = defaults.options.pruneDays
+	options.PruneMaxSize = defaults.options.pruneMaxSize
+	options.PruneMinFree = defaults.options.pruneMinFree
 	options.SearchPatchedTalkgroups = defaults.options.searchPatchedTalkgroups
 	options.ShowListenersCount = defaults.options.showListenersCount
 	options.SortTalkgroups = defaults.options.sortTalkgroups
@@ -245,6 +299,16 @@ func (options *Options) Read(db *Database) error {
 		var m map[string]any
 
 		if err = json.Unmarshal([]byte(s), &m); err == nil {
+			switch v := m["adminSessionIdle"].(type) {
+			case float64:
+				options.AdminSessionIdle = uint(v)
+			}
+
+			switch v := m["adminSessionMaxAge"].(type) {
+			case float64:
+				options.AdminSessionMaxAge = uint(v)
+			}
+
 			switch v := m["afsSystems"].(type) {
 			case string:
 				options.AfsSystems = v
@@ -275,6 +339,16 @@ func (options *Options) Read(db *Database) error {
 				options.DisableDuplicateDetection = v
 			}
 
+			switch v := m["downstreamRetryMaxAge"].(type) {
+			case float64:
+				options.DownstreamRetryMaxAge = uint(v)
+			}
+
+			switch v := m["downstreamRetryMaxAttempts"].(type) {
+			case float64:
+				options.DownstreamRetryMaxAttempts = uint(v)
+			}
+
 			switch v := m["duplicateDetectionTimeFrame"].(type) {
 			case float64:
 				options.DuplicateDetectionTimeFrame = uint(v)
@@ -310,6 +384,16 @@ func (options *Options) Read(db *Database) error {
 				options.PruneDays = uint(v)
 			}
 
+			switch v := m["pruneMaxSize"].(type) {
+			case float64:
+				options.PruneMaxSize = uint(v)
+			}
+
+			switch v := m["pruneMinFree"].(type) {
+			case float64:
+				options.PruneMinFree = uint(v)
+			}
+
 			switch v := m["searchPatchedTalkgroups"].(type) {
 			case bool:
 				options.SearchPatchedTalkgroups = v
@@ -387,18 +471,24 @@ func (options *Options) Write(db *Database) error {
 	}
 
 	if b, err = json.Marshal(map[string]any{
+		"adminSessionIdle":            options.AdminSessionIdle,
+		"adminSessionMaxAge":          options.AdminSessionMaxAge,
 		"afsSystems":                  options.AfsSystems,
 		"audioConversion":             options.AudioConversion,
 		"autoPopulate":                options.AutoPopulate,
 		"branding":                    options.Branding,
 		"dimmerDelay":                 options.DimmerDelay,
 		"disableDuplicateDetection":   options.DisableDuplicateDetection,
+		"downstreamRetryMaxAge":       options.DownstreamRetryMaxAge,
+		"downstreamRetryMaxAttempts":  options.DownstreamRetryMaxAttempts,
 		"duplicateDetectionTimeFrame": options.DuplicateDetectionTimeFrame,
 		"email":                       options.Email,
 		"keypadBeeps":                 options.KeypadBeeps,
 		"maxClients":                  options.MaxClients,
 		"playbackGoesLive":            options.PlaybackGoesLive,
 		"pruneDays":                   options.PruneDays,
+		"pruneMaxSize":                options.PruneMaxSize,
+		"pruneMinFree":                options.PruneMinFree,
 		"searchPatchedTalkgroups":     options.SearchPatchedTalkgroups,
 		"showListenersCount":          options.ShowListenersCount,
 		"sortTalkgroups":              options.SortTalkgroups,
@@ -18,7 +18,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"mime"
 	"mime/multipart"
 	"path"
@@ -254,6 +256,9 @@ func ParseMultipartContent(call *Call, p *multipart.Part, b []byte) {
 			call.DateTime = call.DateTime.UTC()
 		}
 
+	case "emergency":
+		call.emergency, _ = strconv.ParseBool(string(b))
+
 	case "frequencies":
 		var f any
 		if err := json.Unmarshal(b, &f); err == nil {
@@ -406,9 +411,110 @@ func ParseMultipartContent(call *Call, p *multipart.Part, b []byte) {
 		if s := string(b); len(s) > 0 && s != "-" {
 			call.talkgroupTag = s
 		}
+
+	case "transcript":
+		if s := strings.TrimSpace(string(b)); len(s) > 0 {
+			call.Transcript = s
+		}
 	}
 }
 
+// ParseOP25Meta maps the metadata of an OP25 call, either as a json object or
+// as key=value pairs of a log line, with the wacn and sysid in hexadecimal.
+func ParseOP25Meta(call *Call, b []byte) error {
+	m := map[string]any{}
+
+	if b = bytes.TrimSpace(b); bytes.HasPrefix(b, []byte("{")) {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+
+	} else {
+		for _, kv := range regexp.MustCompile(`([a-z_]+)=("[^"]*"|[^\s,]+)`).FindAllStringSubmatch(string(b), -1) {
+			m[kv[1]] = strings.Trim(kv[2], `"`)
+		}
+
+		if len(m) == 0 {
+			return errors.New("no op25 metadata")
+		}
+	}
+
+	switch v := m["emergency"].(type) {
+	case bool:
+		call.emergency = v
+	case float64:
+		call.emergency = v != 0
+	case string:
+		call.emergency = v == "1" || strings.EqualFold(v, "true")
+	}
+
+	if f, ok := getOP25Number(m["freq"], false); ok && f > 0 {
+		// op25 logs the frequency in megahertz
+		if f < 1e5 {
+			f *= 1e6
+		}
+		call.Frequency = uint(math.Round(f))
+	}
+
+	if s, ok := getOP25Number(m["srcaddr"], false); ok && s > 0 {
+		call.Source = uint(s)
+		call.Sources = []map[string]any{{"pos": uint(0), "src": uint(s)}}
+
+		switch t := m["srctag"].(type) {
+		case string:
+			if len(t) > 0 {
+				units := NewUnits()
+				units.Add(uint(s), t)
+				call.units = units
+			}
+		}
+	}
+
+	if t, ok := getOP25Number(m["time"], false); ok && t > 0 {
+		call.DateTime = time.UnixMilli(int64(t * 1000)).UTC()
+	} else if t, ok := getOP25Number(m["start_time"], false); ok && t > 0 {
+		call.DateTime = time.UnixMilli(int64(t * 1000)).UTC()
+	}
+
+	if tg, ok := getOP25Number(m["tgid"], false); ok && tg > 0 {
+		call.Talkgroup = uint(tg)
+	}
+
+	switch v := m["tag"].(type) {
+	case string:
+		if len(v) > 0 && v != "-" {
+			call.talkgroupLabel = v
+		}
+	}
+
+	if sysid, ok := getOP25Number(m["sysid"], true); ok && sysid > 0 {
+		call.System = uint(sysid)
+
+		if wacn, ok := getOP25Number(m["wacn"], true); ok && wacn > 0 {
+			call.systemLabel = fmt.Sprintf("WACN %X SYSID %X", uint(wacn), uint(sysid))
+		} else {
+			call.systemLabel = fmt.Sprintf("SYSID %X", uint(sysid))
+		}
+	}
+
+	switch v := m["encrypted"].(type) {
+	case bool:
+		if v {
+			return errors.New("encrypted call")
+		}
+	case float64:
+		if v != 0 {
+			return errors.New("encrypted call")
+		}
+	case string:
+		if v == "1" || strings.EqualFold(v, "true") {
+			return errors.New("encrypted call")
+		}
+	}
+
+	return nil
+}
+
 func ParseTrunkRecorderMeta(call *Call, b []byte) error {
 	m := map[string]any{}
 
@@ -416,6 +522,13 @@ func ParseTrunkRecorderMeta(call *Call, b []byte) error {
 		return err
 	}
 
+	switch v := m["emergency"].(type) {
+	case bool:
+		call.emergency = v
+	case float64:
+		call.emergency = v != 0
+	}
+
 	switch v := m["freq"].(type) {
 	case float64:
 		if v > 0 {
@@ -548,3 +661,19 @@ func ParseTrunkRecorderMeta(call *Call, b []byte) error {
 
 	return nil
 }
+
+func getOP25Number(v any, hex bool) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		if hex {
+			if i, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(v), "0x"), 16, 64); err == nil {
+				return float64(i), true
+			}
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
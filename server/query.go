@@ -0,0 +1,247 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	QueryOrderAsc  = "asc"
+	QueryOrderDesc = "desc"
+
+	whereAnd = "and"
+	whereOr  = "or"
+)
+
+var queryIdentifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Statement is a sql query along with the arguments bound to its placeholders.
+type Statement struct {
+	Query string
+	Args  []any
+}
+
+func NewStatement(query string, args ...any) Statement {
+	return Statement{Query: query, Args: args}
+}
+
+func NewStatements(queries ...string) []Statement {
+	statements := make([]Statement, len(queries))
+	for i, query := range queries {
+		statements[i] = Statement{Query: query, Args: []any{}}
+	}
+	return statements
+}
+
+// Where builds a boolean expression out of clauses joined by the same
+// operator. Values never end up in the sql text, they are kept as arguments
+// for the placeholders of the clauses.
+type Where struct {
+	args     []any
+	clauses  []string
+	dbType   string
+	operator string
+}
+
+func (db *Database) NewWhere() *Where {
+	return &Where{args: []any{}, clauses: []string{}, dbType: db.Config.DbType, operator: whereAnd}
+}
+
+func (db *Database) NewWhereOr() *Where {
+	return &Where{args: []any{}, clauses: []string{}, dbType: db.Config.DbType, operator: whereOr}
+}
+
+func (where *Where) Add(clause string, args ...any) *Where {
+	where.clauses = append(where.clauses, clause)
+	where.args = append(where.args, args...)
+	return where
+}
+
+// AddWhere adds a nested expression, an empty one keeping its meaning so that
+// an empty or-expression never widens the match.
+func (where *Where) AddWhere(w *Where) *Where {
+	if w != nil {
+		clause, args := w.Build()
+		where.Add(fmt.Sprintf("(%s)", clause), args...)
+	}
+	return where
+}
+
+func (where *Where) Between(column string, from any, to any) *Where {
+	return where.Add(fmt.Sprintf("%s between ? and ?", QuoteIdentifier(column)), from, to)
+}
+
+func (where *Where) Equal(column string, value any) *Where {
+	return where.Add(fmt.Sprintf("%s = ?", QuoteIdentifier(column)), value)
+}
+
+func (where *Where) In(column string, values []uint) *Where {
+	if len(values) == 0 {
+		return where.Add("false")
+	}
+
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+
+	return where.Add(fmt.Sprintf("%s in (%s)", QuoteIdentifier(column), placeholders(len(values))), args...)
+}
+
+// Like matches column against a pattern where % and _ are wildcards. Use
+// EscapeLike on user supplied fragments of the pattern.
+func (where *Where) Like(column string, pattern string) *Where {
+	if where.dbType == DbTypeSqlite {
+		return where.Add(fmt.Sprintf("%s like ? escape '\\'", QuoteIdentifier(column)), pattern)
+	}
+	return where.Add(fmt.Sprintf("%s like ? escape '\\\\'", QuoteIdentifier(column)), pattern)
+}
+
+func (where *Where) IsEmpty() bool {
+	return len(where.clauses) == 0
+}
+
+// Build returns the expression and its arguments. An empty and-expression is
+// always true while an empty or-expression is always false.
+func (where *Where) Build() (string, []any) {
+	if len(where.clauses) == 0 {
+		if where.operator == whereOr {
+			return "false", []any{}
+		}
+		return "true", []any{}
+	}
+
+	return strings.Join(where.clauses, fmt.Sprintf(" %s ", where.operator)), where.args
+}
+
+type SelectQuery struct {
+	columns []string
+	limit   any
+	offset  any
+	order   []string
+	table   string
+	where   *Where
+}
+
+func NewSelectQuery(table string, columns ...string) *SelectQuery {
+	return &SelectQuery{columns: columns, order: []string{}, table: table}
+}
+
+func (query *SelectQuery) Limit(limit uint, offset uint) *SelectQuery {
+	query.limit = limit
+	query.offset = offset
+	return query
+}
+
+// OrderBy falls back to an ascending order for anything else than QueryOrderDesc.
+func (query *SelectQuery) OrderBy(column string, order string) *SelectQuery {
+	if !strings.EqualFold(order, QueryOrderDesc) {
+		order = QueryOrderAsc
+	}
+	query.order = append(query.order, fmt.Sprintf("%s %s", QuoteIdentifier(column), strings.ToLower(order)))
+	return query
+}
+
+func (query *SelectQuery) Where(where *Where) *SelectQuery {
+	query.where = where
+	return query
+}
+
+func (query *SelectQuery) Build() (string, []any) {
+	var (
+		args    = []any{}
+		columns = make([]string, len(query.columns))
+		sb      = strings.Builder{}
+	)
+
+	for i, column := range query.columns {
+		columns[i] = QuoteIdentifier(column)
+	}
+
+	sb.WriteString(fmt.Sprintf("select %s from %s", strings.Join(columns, ", "), QuoteIdentifier(query.table)))
+
+	if query.where != nil {
+		clause, a := query.where.Build()
+		sb.WriteString(fmt.Sprintf(" where %s", clause))
+		args = append(args, a...)
+	}
+
+	if len(query.order) > 0 {
+		sb.WriteString(fmt.Sprintf(" order by %s", strings.Join(query.order, ", ")))
+	}
+
+	if query.limit != nil {
+		sb.WriteString(" limit ? offset ?")
+		args = append(args, query.limit, query.offset)
+	}
+
+	return sb.String(), args
+}
+
+func (query *SelectQuery) Statement() Statement {
+	q, args := query.Build()
+	return Statement{Query: q, Args: args}
+}
+
+type DeleteQuery struct {
+	table string
+	where *Where
+}
+
+func NewDeleteQuery(table string) *DeleteQuery {
+	return &DeleteQuery{table: table}
+}
+
+func (query *DeleteQuery) Where(where *Where) *DeleteQuery {
+	query.where = where
+	return query
+}
+
+func (query *DeleteQuery) Build() (string, []any) {
+	q := fmt.Sprintf("delete from %s", QuoteIdentifier(query.table))
+
+	if query.where == nil {
+		return q, []any{}
+	}
+
+	clause, args := query.where.Build()
+
+	return fmt.Sprintf("%s where %s", q, clause), args
+}
+
+// EscapeLike escapes the like wildcards so that s is matched literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// QuoteIdentifier quotes plain column and table names, anything else like
+// count(*) is returned untouched as it never comes from user input.
+func QuoteIdentifier(s string) string {
+	if queryIdentifierRegexp.MatchString(s) {
+		return fmt.Sprintf("`%s`", s)
+	}
+	return s
+}
+
+func placeholders(n int) string {
+	if n < 1 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
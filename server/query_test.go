@@ -0,0 +1,199 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeleteQueryBuild(t *testing.T) {
+	db := &Database{Config: &Config{DbType: DbTypeSqlite}}
+
+	tests := []struct {
+		name  string
+		query *DeleteQuery
+		sql   string
+		args  []any
+	}{
+		{
+			name:  "without where",
+			query: NewDeleteQuery("freeScannerLogs"),
+			sql:   "delete from `freeScannerLogs`",
+			args:  []any{},
+		},
+		{
+			name:  "with where",
+			query: NewDeleteQuery("freeScannerLogs").Where(db.NewWhere().Add("`dateTime` < ?", "2022-01-01")),
+			sql:   "delete from `freeScannerLogs` where `dateTime` < ?",
+			args:  []any{"2022-01-01"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sql, args := test.query.Build()
+			if sql != test.sql {
+				t.Errorf("got sql %q, want %q", sql, test.sql)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("got args %v, want %v", args, test.args)
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if s := EscapeLike(`50%_off\`); s != `50\%\_off\\` {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := map[string]string{
+		"_id":           "`_id`",
+		"count(*)":      "count(*)",
+		"dateTime":      "`dateTime`",
+		"freeScanner_1": "`freeScanner_1`",
+	}
+
+	for s, want := range tests {
+		if got := QuoteIdentifier(s); got != want {
+			t.Errorf("QuoteIdentifier(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestSelectQueryBuild(t *testing.T) {
+	db := &Database{Config: &Config{DbType: DbTypeSqlite}}
+
+	tests := []struct {
+		name  string
+		query *SelectQuery
+		sql   string
+		args  []any
+	}{
+		{
+			name:  "columns only",
+			query: NewSelectQuery("freeScannerCalls", "_id", "count(*)"),
+			sql:   "select `_id`, count(*) from `freeScannerCalls`",
+			args:  []any{},
+		},
+		{
+			name:  "empty where",
+			query: NewSelectQuery("freeScannerCalls", "_id").Where(db.NewWhere()),
+			sql:   "select `_id` from `freeScannerCalls` where true",
+			args:  []any{},
+		},
+		{
+			name:  "where, order and limit",
+			query: NewSelectQuery("freeScannerCalls", "_id").Where(db.NewWhere().Equal("system", uint(1)).Between("dateTime", "a", "b")).OrderBy("dateTime", QueryOrderDesc).OrderBy("_id", "bogus").Limit(10, 20),
+			sql:   "select `_id` from `freeScannerCalls` where `system` = ? and `dateTime` between ? and ? order by `dateTime` desc, `_id` asc limit ? offset ?",
+			args:  []any{uint(1), "a", "b", uint(10), uint(20)},
+		},
+		{
+			name:  "nested or",
+			query: NewSelectQuery("freeScannerCalls", "_id").Where(db.NewWhere().Equal("system", uint(1)).AddWhere(db.NewWhereOr().Equal("talkgroup", uint(2)).In("talkgroup", []uint{3, 4}))),
+			sql:   "select `_id` from `freeScannerCalls` where `system` = ? and (`talkgroup` = ? or `talkgroup` in (?, ?))",
+			args:  []any{uint(1), uint(2), uint(3), uint(4)},
+		},
+		{
+			name:  "empty nested or matches nothing",
+			query: NewSelectQuery("freeScannerCalls", "_id").Where(db.NewWhere().Equal("system", uint(1)).AddWhere(db.NewWhereOr())),
+			sql:   "select `_id` from `freeScannerCalls` where `system` = ? and (false)",
+			args:  []any{uint(1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sql, args := test.query.Build()
+			if sql != test.sql {
+				t.Errorf("got sql %q, want %q", sql, test.sql)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("got args %v, want %v", args, test.args)
+			}
+		})
+	}
+}
+
+func TestWhereBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbType string
+		where  func(db *Database) *Where
+		sql    string
+		args   []any
+	}{
+		{
+			name:   "empty and",
+			dbType: DbTypeSqlite,
+			where:  func(db *Database) *Where { return db.NewWhere() },
+			sql:    "true",
+			args:   []any{},
+		},
+		{
+			name:   "empty or",
+			dbType: DbTypeSqlite,
+			where:  func(db *Database) *Where { return db.NewWhereOr() },
+			sql:    "false",
+			args:   []any{},
+		},
+		{
+			name:   "empty in",
+			dbType: DbTypeSqlite,
+			where:  func(db *Database) *Where { return db.NewWhere().In("_id", []uint{}) },
+			sql:    "false",
+			args:   nil,
+		},
+		{
+			name:   "like sqlite",
+			dbType: DbTypeSqlite,
+			where:  func(db *Database) *Where { return db.NewWhere().Like("message", "%"+EscapeLike("a_b")+"%") },
+			sql:    "`message` like ? escape '\\'",
+			args:   []any{`%a\_b%`},
+		},
+		{
+			name:   "like mysql",
+			dbType: DbTypeMysql,
+			where:  func(db *Database) *Where { return db.NewWhere().Like("message", "%a%") },
+			sql:    "`message` like ? escape '\\\\'",
+			args:   []any{"%a%"},
+		},
+		{
+			name:   "values stay out of the sql",
+			dbType: DbTypeSqlite,
+			where:  func(db *Database) *Where { return db.NewWhereOr().Equal("label", "x' or '1'='1") },
+			sql:    "`label` = ?",
+			args:   []any{"x' or '1'='1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &Database{Config: &Config{DbType: test.dbType}}
+
+			sql, args := test.where(db).Build()
+			if sql != test.sql {
+				t.Errorf("got sql %q, want %q", sql, test.sql)
+			}
+			if len(args) != len(test.args) || (len(args) > 0 && !reflect.DeepEqual(args, test.args)) {
+				t.Errorf("got args %v, want %v", args, test.args)
+			}
+		})
+	}
+}
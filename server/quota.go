@@ -0,0 +1,214 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const quotaPruneBatch = 100
+
+// Quota prunes the oldest calls when the storage used by the database and the
+// audio files grows over the prune max size option, or when the free space
+// of the disk drops under the prune min free option, both in megabytes.
+type Quota struct {
+	usage      int64 // first for the 64-bit alignment required by atomic on 32-bit platforms
+	controller *Controller
+	measure    sync.Once
+	mutex      sync.Mutex
+}
+
+func NewQuota(controller *Controller) *Quota {
+	return &Quota{
+		controller: controller,
+		mutex:      sync.Mutex{},
+	}
+}
+
+// CallWritten accounts for the audio of a new call and prunes at once if a
+// limit is crossed, unless a pruning is already running.
+func (quota *Quota) CallWritten(call *Call) {
+	if !quota.IsEnabled() {
+		return
+	}
+
+	size := int64(len(call.Audio))
+
+	// the usage is otherwise only known after the first scheduled pruning,
+	// the audio of the call being already written
+	quota.measure.Do(func() {
+		if audio, database, err := quota.getUsage(); err == nil {
+			atomic.StoreInt64(&quota.usage, audio+database-size)
+		}
+	})
+
+	usage := atomic.AddInt64(&quota.usage, size)
+
+	// only when crossing a limit, the scheduled pruning taking over if it
+	// could not be met
+	if quota.getExcess(usage) == 0 || quota.getExcess(usage-size) > 0 {
+		return
+	}
+
+	go func() {
+		if !quota.mutex.TryLock() {
+			return
+		}
+		defer quota.mutex.Unlock()
+
+		if err := quota.prune(); err != nil {
+			quota.controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+	}()
+}
+
+func (quota *Quota) IsEnabled() bool {
+	options := quota.controller.Options
+
+	return options.PruneMaxSize > 0 || options.PruneMinFree > 0
+}
+
+// Prune measures the storage used and removes the oldest calls, except the ones
+// to keep forever, until both limits are met.
+func (quota *Quota) Prune() error {
+	if !quota.IsEnabled() {
+		return nil
+	}
+
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	return quota.prune()
+}
+
+// getUsage returns the bytes used by the audio files and by the sqlite
+// database, the size of a mysql database being out of reach.
+func (quota *Quota) getUsage() (audio int64, database int64, err error) {
+
+	config := quota.controller.Config
+
+	err = filepath.WalkDir(config.GetAudioDirPath(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !d.IsDir() {
+			if fi, err := d.Info(); err == nil {
+				audio += fi.Size()
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if config.DbType == DbTypeSqlite {
+		for _, suffix := range []string{"", "-shm", "-wal"} {
+			if fi, err := os.Stat(config.GetDbFilePath() + suffix); err == nil {
+				database += fi.Size()
+			}
+		}
+	}
+
+	return audio, database, nil
+}
+
+// getExcess returns how many bytes must be freed to meet both limits.
+func (quota *Quota) getExcess(usage int64) int64 {
+	const mb = 1024 * 1024
+
+	var excess int64
+
+	options := quota.controller.Options
+
+	if options.PruneMaxSize > 0 {
+		if v := usage - int64(options.PruneMaxSize)*mb; v > excess {
+			excess = v
+		}
+	}
+
+	if options.PruneMinFree > 0 {
+		if free, err := getDiskFree(quota.controller.Config.GetAudioDirPath()); err == nil {
+			if v := int64(options.PruneMinFree)*mb - int64(free); v > excess {
+				excess = v
+			}
+		}
+	}
+
+	return excess
+}
+
+func (quota *Quota) prune() error {
+	var (
+		controller = quota.controller
+		removed    int64
+		freed      int64
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("quota.prune: %v", err)
+	}
+
+	audio, database, err := quota.getUsage()
+	if err != nil {
+		return formatError(err)
+	}
+
+	usage := audio + database
+
+	// only the audio files shrink, the database file keeping its size after a
+	// delete, so no call is removed if that can't meet the limits
+	if excess := quota.getExcess(usage); excess > audio {
+		atomic.StoreInt64(&quota.usage, usage)
+		controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("disk quota pruning: %.1f MB over the limits but only %.1f MB of audio files to remove", float64(excess)/1024/1024, float64(audio)/1024/1024))
+		return nil
+	}
+
+	for excess := quota.getExcess(usage); excess > 0; excess = quota.getExcess(usage) {
+		count, size, err := controller.Calls.PruneOldest(controller.Database, quotaPruneBatch, excess)
+		if err != nil {
+			return formatError(err)
+		}
+
+		removed += count
+		freed += size
+		usage -= size
+
+		if count == 0 || size == 0 {
+			controller.Logs.LogEvent(LogLevelWarn, "disk quota pruning: limit still exceeded but no more audio files to remove")
+			break
+		}
+	}
+
+	atomic.StoreInt64(&quota.usage, usage)
+
+	if removed > 0 {
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("disk quota pruning: %d calls removed, %.1f MB freed", removed, float64(freed)/1024/1024))
+		controller.Metrics.QuotaPruned(removed, freed)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RetentionRule is the number of days the calls of a set of talkgroups are
+// kept, 0 to keep them forever.
+type RetentionRule struct {
+	Days       uint
+	Label      string
+	Talkgroups map[uint][]uint
+}
+
+func (rule *RetentionRule) GetWhere(db *Database) *Where {
+	where := db.NewWhereOr()
+
+	for systemId, talkgroupIds := range rule.Talkgroups {
+		where.AddWhere(db.NewWhere().Equal("system", systemId).In("talkgroup", talkgroupIds))
+	}
+
+	return where
+}
+
+type RetentionRules []*RetentionRule
+
+// NewRetentionRules resolves the retention of every configured talkgroup with
+// the most specific rule, the one of its tag, then of its group and then of
+// its system. The talkgroups without any rule are left to the prune days
+// option.
+func NewRetentionRules(controller *Controller) RetentionRules {
+	rules := map[string]*RetentionRule{}
+
+	getDays := func(v any) (uint, bool) {
+		switch v := v.(type) {
+		case uint:
+			return v, true
+		}
+		return 0, false
+	}
+
+	for _, system := range controller.Systems.List {
+		for _, talkgroup := range system.Talkgroups.List {
+			var (
+				days  uint
+				label string
+				ok    bool
+			)
+
+			if tag, found := controller.Tags.GetTag(talkgroup.TagId); found {
+				if days, ok = getDays(tag.PruneDays); ok {
+					label = fmt.Sprintf("tag %s", tag.Label)
+				}
+			}
+
+			if group, found := controller.Groups.GetGroup(talkgroup.GroupId); found && !ok {
+				if days, ok = getDays(group.PruneDays); ok {
+					label = fmt.Sprintf("group %s", group.Label)
+				}
+			}
+
+			if !ok {
+				if days, ok = getDays(system.PruneDays); ok {
+					label = fmt.Sprintf("system %s", system.Label)
+				}
+			}
+
+			if !ok {
+				continue
+			}
+
+			key := fmt.Sprintf("%s:%d", label, days)
+
+			rule := rules[key]
+			if rule == nil {
+				rule = &RetentionRule{Days: days, Label: label, Talkgroups: map[uint][]uint{}}
+				rules[key] = rule
+			}
+
+			rule.Talkgroups[system.Id] = append(rule.Talkgroups[system.Id], talkgroup.Id)
+		}
+	}
+
+	retentionRules := RetentionRules{}
+
+	for _, rule := range rules {
+		retentionRules = append(retentionRules, rule)
+	}
+
+	sort.Slice(retentionRules, func(i int, j int) bool {
+		return retentionRules[i].Label < retentionRules[j].Label
+	})
+
+	return retentionRules
+}
+
+// GetDefaultWhere matches the calls of the talkgroups without any rule.
+func (rules RetentionRules) GetDefaultWhere(db *Database) *Where {
+	where := db.NewWhereOr()
+
+	for _, rule := range rules {
+		where.AddWhere(rule.GetWhere(db))
+	}
+
+	if where.IsEmpty() {
+		return db.NewWhere()
+	}
+
+	clause, args := where.Build()
+
+	return db.NewWhere().Add(fmt.Sprintf("not (%s)", clause), args...)
+}
@@ -37,18 +37,62 @@ func NewScheduler(controller *Controller) *Scheduler {
 	}
 }
 
+// pruneAdminSessions removes the admin sessions idle for too long.
+func (scheduler *Scheduler) pruneAdminSessions() error {
+	controller := scheduler.Controller
+
+	count, err := controller.AdminSessions.Prune(controller.Database, controller.Admin.getSessionIdle())
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("%d expired admin sessions removed", count))
+	}
+
+	return nil
+}
+
+// pruneDatabase applies the retention rules of the systems, groups and tags,
+// then the prune days option to the calls without any rule and to the logs.
 func (scheduler *Scheduler) pruneDatabase() error {
-	if scheduler.Controller.Options.PruneDays == 0 {
+	var (
+		controller = scheduler.Controller
+		db         = controller.Database
+		rules      = NewRetentionRules(controller)
+	)
+
+	if controller.Options.PruneDays == 0 && len(rules) == 0 {
 		return nil
 	}
 
-	scheduler.Controller.Logs.LogEvent(LogLevelInfo, "database pruning")
+	controller.Logs.LogEvent(LogLevelInfo, "database pruning")
+
+	for _, rule := range rules {
+		if rule.Days == 0 {
+			continue
+		}
+
+		count, err := controller.Calls.Prune(db, rule.Days, rule.GetWhere(db))
+		if err != nil {
+			return err
+		}
+
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("database pruning: %d calls removed by %s after %d days", count, rule.Label, rule.Days))
+	}
+
+	if controller.Options.PruneDays == 0 {
+		return nil
+	}
 
-	if err := scheduler.Controller.Calls.Prune(scheduler.Controller.Database, scheduler.Controller.Options.PruneDays); err != nil {
+	count, err := controller.Calls.Prune(db, controller.Options.PruneDays, rules.GetDefaultWhere(db))
+	if err != nil {
 		return err
 	}
 
-	if err := scheduler.Controller.Logs.Prune(scheduler.Controller.Database, scheduler.Controller.Options.PruneDays); err != nil {
+	controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("database pruning: %d calls removed by prune days option after %d days", count, controller.Options.PruneDays))
+
+	if err := controller.Logs.Prune(db, controller.Options.PruneDays); err != nil {
 		return err
 	}
 
@@ -66,6 +110,14 @@ func (scheduler *Scheduler) run() {
 	if err := scheduler.pruneDatabase(); err != nil {
 		logError(err)
 	}
+
+	if err := scheduler.Controller.Quota.Prune(); err != nil {
+		logError(err)
+	}
+
+	if err := scheduler.pruneAdminSessions(); err != nil {
+		logError(err)
+	}
 }
 
 func (scheduler *Scheduler) Start() error {
@@ -0,0 +1,445 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StreamFormatAac  = "aac"
+	StreamFormatMp3  = "mp3"
+	StreamFormatOpus = "opus"
+)
+
+const (
+	streamChunk      = 100 * time.Millisecond
+	streamGap        = 500 * time.Millisecond
+	streamSampleRate = 16000
+)
+
+// Stream encodes the calls of a talkgroup selection into a continuous audio
+// stream. Calls are played one after the other and the gaps are filled with
+// silence, so that players never run dry.
+type Stream struct {
+	access     *Access
+	calls      chan *Call
+	controller *Controller
+	format     string
+	livefeed   *Livefeed
+	pcm        chan []byte
+	selection  string
+}
+
+// NewStream returns a stream of the talkgroup selection, like 1:100,1:101,2:*,
+// within what the access gives access to, everything if nil.
+func NewStream(controller *Controller, access *Access, selection string, format string) (*Stream, error) {
+	if !controller.FFMpeg.available {
+		return nil, errors.New("ffmpeg is required for streaming")
+	}
+
+	if getStreamCodec(format) == nil {
+		return nil, fmt.Errorf("unknown stream format %s", format)
+	}
+
+	return &Stream{
+		access:     access,
+		calls:      make(chan *Call, 64),
+		controller: controller,
+		format:     format,
+		livefeed:   newStreamLivefeed(controller, access, selection),
+		pcm:        make(chan []byte, 4),
+		selection:  selection,
+	}, nil
+}
+
+func (stream *Stream) ContentType() string {
+	switch stream.format {
+	case StreamFormatAac:
+		return "audio/aac"
+	case StreamFormatOpus:
+		return "audio/ogg"
+	default:
+		return "audio/mpeg"
+	}
+}
+
+// Queue adds the call to the stream if it is part of its selection. Calls are
+// dropped when the stream is too far behind.
+func (stream *Stream) Queue(call *Call) {
+	if stream.access != nil && !stream.access.HasAccess(call) {
+		return
+	}
+
+	if !stream.livefeed.IsEnabled(call) || len(call.Audio) == 0 {
+		return
+	}
+
+	select {
+	case stream.calls <- call:
+	default:
+	}
+}
+
+// Run writes the encoded stream to w until the context is done or w fails.
+func (stream *Stream) Run(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("stream.run: %v", err)
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "s16le", "-ar", strconv.Itoa(streamSampleRate), "-ac", "1", "-i", "-"}
+	args = append(args, getStreamCodec(stream.format)...)
+	args = append(args, "-flush_packets", "1", "-")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return formatError(err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return formatError(err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return formatError(err)
+	}
+
+	go stream.decode(ctx)
+	go stream.feed(ctx, cancel, stdin)
+
+	b := make([]byte, 4096)
+
+	for {
+		n, err := stdout.Read(b)
+		if n > 0 {
+			if _, err := w.Write(b[:n]); err != nil {
+				break
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	cancel()
+	cmd.Wait()
+
+	return nil
+}
+
+// decode turns the queued calls into raw pcm for the encoder.
+func (stream *Stream) decode(ctx context.Context) {
+	gap := make([]byte, getStreamPcmSize(streamGap))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case call := <-stream.calls:
+			stdout := bytes.Buffer{}
+
+			cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error", "-i", "-", "-f", "s16le", "-ar", strconv.Itoa(streamSampleRate), "-ac", "1", "-")
+			cmd.Stdin = bytes.NewReader(call.Audio)
+			cmd.Stdout = &stdout
+
+			if err := cmd.Run(); err != nil {
+				if ctx.Err() == nil {
+					stream.controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("stream.decode: call %v, %v", call.Id, err))
+				}
+				continue
+			}
+
+			select {
+			case stream.pcm <- append(stdout.Bytes(), gap...):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// feed writes the pcm to the encoder in real time, silence when idle.
+func (stream *Stream) feed(ctx context.Context, cancel context.CancelFunc, stdin io.WriteCloser) {
+	var (
+		buf     []byte
+		size    = getStreamPcmSize(streamChunk)
+		silence = make([]byte, size)
+	)
+
+	defer stdin.Close()
+
+	// a second of silence ahead, so that players start at once
+	if _, err := stdin.Write(bytes.Repeat(silence, int(time.Second/streamChunk))); err != nil {
+		cancel()
+		return
+	}
+
+	ticker := time.NewTicker(streamChunk)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if len(buf) == 0 {
+			select {
+			case buf = <-stream.pcm:
+			default:
+			}
+		}
+
+		chunk := silence
+
+		if len(buf) > 0 {
+			n := size
+			if n > len(buf) {
+				n = len(buf)
+			}
+			chunk, buf = buf[:n], buf[n:]
+		}
+
+		if _, err := stdin.Write(chunk); err != nil {
+			cancel()
+			return
+		}
+	}
+}
+
+type Streams struct {
+	List  map[*Stream]bool
+	mutex sync.Mutex
+}
+
+func NewStreams() *Streams {
+	return &Streams{
+		List:  map[*Stream]bool{},
+		mutex: sync.Mutex{},
+	}
+}
+
+func (streams *Streams) Add(stream *Stream) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	streams.List[stream] = true
+}
+
+// AddLimited adds the stream unless there are already max streams, 0 for no
+// limit.
+func (streams *Streams) AddLimited(stream *Stream, max uint) bool {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	if max > 0 && uint(len(streams.List)) >= max {
+		return false
+	}
+
+	streams.List[stream] = true
+
+	return true
+}
+
+func (streams *Streams) Count() int {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	return len(streams.List)
+}
+
+func (streams *Streams) EmitCall(call *Call) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	for stream := range streams.List {
+		stream.Queue(call)
+	}
+}
+
+// Refresh updates the talkgroups of the streams once the systems changed.
+func (streams *Streams) Refresh(controller *Controller) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	for stream := range streams.List {
+		stream.livefeed = newStreamLivefeed(controller, stream.access, stream.selection)
+	}
+}
+
+func (streams *Streams) Remove(stream *Stream) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+
+	delete(streams.List, stream)
+}
+
+// StartIcecast pushes a stream to the icecast mount of the config as a source
+// client, reconnecting as needed until the controller stops.
+func (streams *Streams) StartIcecast(controller *Controller) error {
+	config := controller.Config
+
+	if len(config.IcecastUrl) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(config.IcecastUrl)
+	if err != nil {
+		return fmt.Errorf("streams.starticecast: %v", err)
+	}
+
+	stream, err := NewStream(controller, nil, config.IcecastTalkgroups, config.IcecastFormat)
+	if err != nil {
+		return fmt.Errorf("streams.starticecast: %v", err)
+	}
+
+	streams.Add(stream)
+
+	go func() {
+		const retry = 10 * time.Second
+
+		logError := func(err error) {
+			controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("streams.icecast: %v", err))
+		}
+
+		for {
+			r, w := io.Pipe()
+
+			req, err := http.NewRequest(http.MethodPut, u.String(), r)
+			if err != nil {
+				logError(err)
+				return
+			}
+
+			req.Header.Set("Content-Type", stream.ContentType())
+			req.Header.Set("Ice-Name", controller.Options.Branding)
+			req.Header.Set("Ice-Public", "0")
+
+			if u.User != nil {
+				p, _ := u.User.Password()
+				req.SetBasicAuth(u.User.Username(), p)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			go func() {
+				stream.Run(ctx, w)
+				w.Close()
+			}()
+
+			if res, err := http.DefaultClient.Do(req); err != nil {
+				logError(err)
+			} else {
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					logError(fmt.Errorf("bad status: %s", res.Status))
+				}
+			}
+
+			cancel()
+			r.Close()
+
+			time.Sleep(retry)
+		}
+	}()
+
+	return nil
+}
+
+func getStreamCodec(format string) []string {
+	switch format {
+	case StreamFormatAac:
+		return []string{"-c:a", "aac", "-b:a", "32k", "-f", "adts"}
+	case StreamFormatMp3:
+		return []string{"-c:a", "libmp3lame", "-b:a", "32k", "-f", "mp3"}
+	case StreamFormatOpus:
+		return []string{"-c:a", "libopus", "-b:a", "32k", "-f", "ogg"}
+	}
+	return nil
+}
+
+func getStreamPcmSize(d time.Duration) int {
+	return int(int64(streamSampleRate) * 2 * int64(d) / int64(time.Second))
+}
+
+// newStreamLivefeed builds the livefeed matrix of a talkgroup selection out of
+// the systems the access gives access to, all of them if the selection is
+// empty. A system id alone selects all of its talkgroups.
+func newStreamLivefeed(controller *Controller, access *Access, selection string) *Livefeed {
+	livefeed := NewLivefeed()
+	selected := map[string]bool{}
+
+	for _, s := range strings.Split(selection, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			if !strings.Contains(s, ":") {
+				s += ":*"
+			}
+			selected[s] = true
+		}
+	}
+
+	systemsMap := controller.Systems.GetScopedSystems(&Client{Access: access}, controller.Groups, controller.Tags, false)
+
+	for _, systemMap := range systemsMap {
+		var systemId uint
+
+		switch v := systemMap["id"].(type) {
+		case uint:
+			systemId = v
+		default:
+			continue
+		}
+
+		switch v := systemMap["talkgroups"].(type) {
+		case TalkgroupsMap:
+			for _, talkgroupMap := range v {
+				switch talkgroupId := talkgroupMap["id"].(type) {
+				case uint:
+					if len(selected) == 0 || selected[fmt.Sprintf("%d:*", systemId)] || selected[fmt.Sprintf("%d:%d", systemId, talkgroupId)] {
+						if livefeed.Matrix[systemId] == nil {
+							livefeed.Matrix[systemId] = map[uint]bool{}
+						}
+						livefeed.Matrix[systemId][talkgroupId] = true
+					}
+				}
+			}
+		}
+	}
+
+	return livefeed
+}
@@ -17,7 +17,6 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -32,6 +31,7 @@ type System struct {
 	Label        string      `json:"label"`
 	Led          any         `json:"led"`
 	Order        uint        `json:"order"`
+	PruneDays    any         `json:"pruneDays"`
 	RowId        any         `json:"_id"`
 	Talkgroups   *Talkgroups `json:"talkgroups"`
 	Units        *Units      `json:"units"`
@@ -80,6 +80,11 @@ func (system *System) FromMap(m map[string]any) *System {
 		system.Order = uint(v)
 	}
 
+	switch v := m["pruneDays"].(type) {
+	case float64:
+		system.PruneDays = uint(v)
+	}
+
 	switch v := m["talkgroups"].(type) {
 	case []any:
 		system.Talkgroups.FromMap(v)
@@ -107,6 +112,26 @@ func NewSystems() *Systems {
 	}
 }
 
+func (systems *Systems) Add(system *System) (*Systems, bool) {
+	systems.mutex.Lock()
+	defer systems.mutex.Unlock()
+
+	added := true
+
+	for _, a := range systems.List {
+		if a.Id == system.Id {
+			added = false
+			break
+		}
+	}
+
+	if added {
+		systems.List = append(systems.List, system)
+	}
+
+	return systems, added
+}
+
 func (systems *Systems) FromMap(f []any) *Systems {
 	systems.mutex.Lock()
 	defer systems.mutex.Unlock()
@@ -326,6 +351,7 @@ func (systems *Systems) Read(db *Database) error {
 		err        error
 		led        sql.NullString
 		order      sql.NullFloat64
+		pruneDays  sql.NullFloat64
 		rowId      sql.NullFloat64
 		rows       *sql.Rows
 	)
@@ -339,7 +365,7 @@ func (systems *Systems) Read(db *Database) error {
 		return fmt.Errorf("systems.read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order` from `freeScannerSystems`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`, `pruneDays` from `freeScannerSystems`"); err != nil {
 		return formatError(err)
 	}
 
@@ -349,7 +375,7 @@ func (systems *Systems) Read(db *Database) error {
 			Units:      NewUnits(),
 		}
 
-		if err = rows.Scan(&rowId, &system.AutoPopulate, &blacklists, &system.Id, &system.Label, &led, &order); err != nil {
+		if err = rows.Scan(&rowId, &system.AutoPopulate, &blacklists, &system.Id, &system.Label, &led, &order, &pruneDays); err != nil {
 			break
 		}
 
@@ -371,6 +397,10 @@ func (systems *Systems) Read(db *Database) error {
 			system.Order = uint(order.Float64)
 		}
 
+		if pruneDays.Valid {
+			system.PruneDays = uint(pruneDays.Float64)
+		}
+
 		if err = system.Talkgroups.Read(db, system.Id); err != nil {
 			return err
 		}
@@ -442,30 +472,20 @@ func (systems *Systems) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerSystems` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerSystems").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
 	if len(systemIds) > 0 {
-		if b, err := json.Marshal(systemIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerTalkgroups` where `systemId` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
-			q = fmt.Sprintf("delete from `freeScannerUnits` where `systemId` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerTalkgroups").Where(db.NewWhere().In("systemId", systemIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
+		}
+		q, args = NewDeleteQuery("freeScannerUnits").Where(db.NewWhere().In("systemId", systemIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -481,11 +501,11 @@ func (systems *Systems) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerSystems` (`_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`) values (?, ?, ?, ?, ?, ?, ?)", system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerSystems` (`_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`, `pruneDays`) values (?, ?, ?, ?, ?, ?, ?, ?)", system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.PruneDays); err != nil {
 				break
 			}
 
-		} else if _, err = db.Sql.Exec("update `freeScannerSystems` set `_id` = ?, `autoPopulate` = ?, `blacklists` = ?, `id` = ?, `label` = ?, `led` = ?, `order` = ? where `_id` = ?", system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.RowId); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerSystems` set `_id` = ?, `autoPopulate` = ?, `blacklists` = ?, `id` = ?, `label` = ?, `led` = ?, `order` = ?, `pruneDays` = ? where `_id` = ?", system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.PruneDays, system.RowId); err != nil {
 			break
 		}
 
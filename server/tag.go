@@ -17,15 +17,14 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
 )
 
 type Tag struct {
-	Id    any    `json:"_id"`
-	Label string `json:"label"`
+	Id        any    `json:"_id"`
+	Label     string `json:"label"`
+	PruneDays any    `json:"pruneDays"`
 }
 
 func (tag *Tag) FromMap(m map[string]any) *Tag {
@@ -39,6 +38,11 @@ func (tag *Tag) FromMap(m map[string]any) *Tag {
 		tag.Label = v
 	}
 
+	switch v := m["pruneDays"].(type) {
+	case float64:
+		tag.PruneDays = uint(v)
+	}
+
 	return tag
 }
 
@@ -54,6 +58,26 @@ func NewTags() *Tags {
 	}
 }
 
+func (tags *Tags) Add(tag *Tag) (*Tags, bool) {
+	tags.mutex.Lock()
+	defer tags.mutex.Unlock()
+
+	added := true
+
+	for _, a := range tags.List {
+		if a.Label == tag.Label {
+			added = false
+			break
+		}
+	}
+
+	if added {
+		tags.List = append(tags.List, tag)
+	}
+
+	return tags, added
+}
+
 func (tags *Tags) FromMap(f []any) *Tags {
 	tags.mutex.Lock()
 	defer tags.mutex.Unlock()
@@ -164,9 +188,10 @@ func (tags *Tags) GetTagsMap(systemsMap *SystemsMap) TagsMap {
 
 func (tags *Tags) Read(db *Database) error {
 	var (
-		err  error
-		id   sql.NullFloat64
-		rows *sql.Rows
+		err       error
+		id        sql.NullFloat64
+		pruneDays sql.NullFloat64
+		rows      *sql.Rows
 	)
 
 	tags.mutex.Lock()
@@ -178,14 +203,14 @@ func (tags *Tags) Read(db *Database) error {
 		return fmt.Errorf("tags read: %v", err)
 	}
 
-	if rows, err = db.Sql.Query("select `_id`, `label` from `freeScannerTags`"); err != nil {
+	if rows, err = db.Sql.Query("select `_id`, `label`, `pruneDays` from `freeScannerTags`"); err != nil {
 		return formatError(err)
 	}
 
 	for rows.Next() {
 		tag := &Tag{}
 
-		if err = rows.Scan(&id, &tag.Label); err != nil {
+		if err = rows.Scan(&id, &tag.Label, &pruneDays); err != nil {
 			break
 		}
 
@@ -193,6 +218,10 @@ func (tags *Tags) Read(db *Database) error {
 			tag.Id = uint(id.Float64)
 		}
 
+		if pruneDays.Valid {
+			tag.PruneDays = uint(pruneDays.Float64)
+		}
+
 		tags.List = append(tags.List, tag)
 	}
 
@@ -248,14 +277,9 @@ func (tags *Tags) Write(db *Database) error {
 	}
 
 	if len(rowIds) > 0 {
-		if b, err := json.Marshal(rowIds); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerTags` where `_id` in %v", s)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerTags").Where(db.NewWhere().In("_id", rowIds)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -265,10 +289,10 @@ func (tags *Tags) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			if _, err = db.Sql.Exec("insert into `freeScannerTags` (`_id`, `label`) values (?, ?)", tag.Id, tag.Label); err != nil {
+			if _, err = db.Sql.Exec("insert into `freeScannerTags` (`_id`, `label`, `pruneDays`) values (?, ?, ?)", tag.Id, tag.Label, tag.PruneDays); err != nil {
 				break
 			}
-		} else if _, err = db.Sql.Exec("update `freeScannerTags` set `_id` = ?, `label` = ? where `_id` = ?", tag.Id, tag.Label, tag.Id); err != nil {
+		} else if _, err = db.Sql.Exec("update `freeScannerTags` set `_id` = ?, `label` = ?, `pruneDays` = ? where `_id` = ?", tag.Id, tag.Label, tag.PruneDays, tag.Id); err != nil {
 			break
 		}
 	}
@@ -17,10 +17,8 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"sort"
-	"strings"
 	"sync"
 )
 
@@ -105,6 +103,26 @@ func NewTalkgroups() *Talkgroups {
 	}
 }
 
+func (talkgroups *Talkgroups) Add(talkgroup *Talkgroup) (*Talkgroups, bool) {
+	talkgroups.mutex.Lock()
+	defer talkgroups.mutex.Unlock()
+
+	added := true
+
+	for _, a := range talkgroups.List {
+		if a.Id == talkgroup.Id {
+			added = false
+			break
+		}
+	}
+
+	if added {
+		talkgroups.List = append(talkgroups.List, talkgroup)
+	}
+
+	return talkgroups, added
+}
+
 func (talkgroups *Talkgroups) FromMap(f []any) *Talkgroups {
 	talkgroups.mutex.Lock()
 	defer talkgroups.mutex.Unlock()
@@ -240,14 +258,9 @@ func (talkgroups *Talkgroups) Write(db *Database, systemId uint) error {
 	}
 
 	if len(ids) > 0 {
-		if b, err := json.Marshal(ids); err == nil {
-			s := string(b)
-			s = strings.ReplaceAll(s, "[", "(")
-			s = strings.ReplaceAll(s, "]", ")")
-			q := fmt.Sprintf("delete from `freeScannerTalkgroups` where `id` in %v and `systemId` = %v", s, systemId)
-			if _, err = db.Sql.Exec(q); err != nil {
-				return formatError(err)
-			}
+		q, args := NewDeleteQuery("freeScannerTalkgroups").Where(db.NewWhere().In("id", ids).Equal("systemId", systemId)).Build()
+		if _, err = db.Sql.Exec(q, args...); err != nil {
+			return formatError(err)
 		}
 	}
 
@@ -0,0 +1,135 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits        = 6
+	totpIssuer        = "FreeScanner"
+	totpPeriod        = 30
+	totpRecoveryCodes = 10
+	totpSkew          = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret returns a random 160-bit secret, base32 encoded as the
+// authenticator apps expect it.
+func NewTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// NewTotpRecoveryCodes returns single-use codes like 4f7qk-2m9xa to log in
+// without the authenticator.
+func NewTotpRecoveryCodes() ([]string, error) {
+	codes := []string{}
+
+	for i := 0; i < totpRecoveryCodes; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, fmt.Sprintf("%s-%s", code[:5], code[5:]))
+	}
+
+	return codes, nil
+}
+
+// GetTotpCode returns the RFC 6238 code of the secret for the time step
+// count, with the default SHA-1, 6 digits and 30 seconds parameters.
+func GetTotpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits))), nil
+}
+
+// GetTotpStep returns the time step count at t.
+func GetTotpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// GetTotpUri returns the provisioning uri to be turned into a qr code for the
+// authenticator apps.
+func GetTotpUri(account string, secret string) string {
+	label := url.PathEscape(fmt.Sprintf("%s:%s", totpIssuer, account))
+
+	query := url.Values{}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("issuer", totpIssuer)
+	query.Set("period", fmt.Sprintf("%d", totpPeriod))
+	query.Set("secret", secret)
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// ValidateTotpCode checks the code against the steps around now, to allow for
+// clock drift, and returns the matching step.
+func ValidateTotpCode(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := GetTotpStep(now)
+
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		expected, err := GetTotpCode(secret, step+i)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + i, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the sha1 secret of the RFC 6238 appendix B, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the sha1 vectors of the RFC 6238 appendix B, truncated to
+// the 6 digits of the authenticators.
+var rfc6238Vectors = []struct {
+	time int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestAdminUserCheckTotp(t *testing.T) {
+	secret, err := NewTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &AdminUser{TotpEnabled: true, TotpSecret: secret, Username: "jane"}
+	if err := user.SetRecoveryCodes([]string{"abcd-efgh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := GetTotpCode(secret, GetTotpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recovery, ok := user.CheckTotp(code); !ok || recovery {
+		t.Errorf("got %v %v for the code", recovery, ok)
+	}
+
+	if _, ok := user.CheckTotp(code); ok {
+		t.Error("got the same step accepted twice")
+	}
+
+	if recovery, ok := user.CheckTotp(" ABCD-EFGH "); !ok || !recovery {
+		t.Errorf("got %v %v for the recovery code", recovery, ok)
+	}
+
+	if _, ok := user.CheckTotp("abcd-efgh"); ok {
+		t.Error("got the recovery code accepted twice")
+	}
+
+	if len(user.RecoveryCodes) != 0 {
+		t.Errorf("got %d recovery codes left", len(user.RecoveryCodes))
+	}
+
+	user.ResetTotp()
+
+	if _, ok := user.CheckTotp(code); ok {
+		t.Error("got a code accepted without two-factor authentication")
+	}
+}
+
+func TestGetTotpCode(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		code, err := GetTotpCode(rfc6238Secret, GetTotpStep(time.Unix(v.time, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("got %s at %d, want %s", code, v.time, v.code)
+		}
+	}
+
+	if _, err := GetTotpCode("not base32!", 1); err == nil {
+		t.Error("got no error for an invalid secret")
+	}
+}
+
+func TestValidateTotpCode(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		now := time.Unix(v.time, 0)
+		step := GetTotpStep(now)
+
+		if got, ok := ValidateTotpCode(rfc6238Secret, v.code, now); !ok || got != step {
+			t.Errorf("got %d %v at %d, want %d", got, ok, v.time, step)
+		}
+
+		for _, skew := range []int64{-1, 1} {
+			code, _ := GetTotpCode(rfc6238Secret, step+skew)
+			if got, ok := ValidateTotpCode(rfc6238Secret, code, now); !ok || got != step+skew {
+				t.Errorf("got %d %v at %d with a skew of %d", got, ok, v.time, skew)
+			}
+		}
+
+		for _, skew := range []int64{-2, 2} {
+			code, _ := GetTotpCode(rfc6238Secret, step+skew)
+			if _, ok := ValidateTotpCode(rfc6238Secret, code, now); ok {
+				t.Errorf("got a code accepted at %d with a skew of %d", v.time, skew)
+			}
+		}
+
+		for _, code := range []string{"", v.code[:5], v.code + "0"} {
+			if _, ok := ValidateTotpCode(rfc6238Secret, code, now); ok {
+				t.Errorf("got %q accepted at %d", code, v.time)
+			}
+		}
+	}
+}
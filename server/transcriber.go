@@ -0,0 +1,231 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const TranscriberWhisper = "whisper"
+
+// Transcriber turns the audio of a call into text.
+type Transcriber interface {
+	Transcribe(call *Call) (string, error)
+}
+
+func NewTranscriber(config *Config) (Transcriber, error) {
+	switch config.Transcriber {
+	case "":
+		return nil, nil
+	case TranscriberWhisper:
+		return NewWhisperTranscriber(config)
+	default:
+		return nil, fmt.Errorf("unknown transcriber %s", config.Transcriber)
+	}
+}
+
+// WhisperTranscriber runs a local whisper.cpp binary on each call. The audio
+// is first resampled with ffmpeg to the 16 kHz mono wav whisper.cpp expects.
+type WhisperTranscriber struct {
+	binary   string
+	ffmpeg   bool
+	language string
+	model    string
+}
+
+func NewWhisperTranscriber(config *Config) (*WhisperTranscriber, error) {
+	whisper := &WhisperTranscriber{
+		binary:   config.WhisperBinary,
+		language: config.WhisperLanguage,
+		model:    config.GetWhisperModelPath(),
+	}
+
+	if _, err := exec.LookPath(whisper.binary); err != nil {
+		return nil, fmt.Errorf("whisper: %v", err)
+	}
+
+	if len(config.WhisperModel) == 0 {
+		return nil, errors.New("whisper: no model file")
+	} else if _, err := os.Stat(whisper.model); err != nil {
+		return nil, fmt.Errorf("whisper: %v", err)
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		whisper.ffmpeg = true
+	}
+
+	return whisper, nil
+}
+
+func (whisper *WhisperTranscriber) Transcribe(call *Call) (string, error) {
+	const timeout = 5 * time.Minute
+
+	var (
+		ext    = ".wav"
+		stderr = bytes.NewBuffer([]byte(nil))
+		stdout = bytes.NewBuffer([]byte(nil))
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("whisper.transcribe: %v", err)
+	}
+
+	if !whisper.ffmpeg {
+		switch v := call.AudioName.(type) {
+		case string:
+			ext = path.Ext(v)
+		}
+	}
+
+	f, err := os.CreateTemp("", fmt.Sprintf("freescanner-*%s", ext))
+	if err != nil {
+		return "", formatError(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if whisper.ffmpeg {
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-loglevel", "error", "-i", "-", "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", "-f", "wav", f.Name())
+		cmd.Stdin = bytes.NewReader(call.Audio)
+		cmd.Stderr = stderr
+
+		if err = cmd.Run(); err != nil {
+			return "", formatError(fmt.Errorf("ffmpeg: %v, %s", err, strings.TrimSpace(stderr.String())))
+		}
+
+	} else if err = os.WriteFile(f.Name(), call.Audio, 0600); err != nil {
+		return "", formatError(err)
+	}
+
+	args := []string{"-m", whisper.model, "-f", f.Name(), "-nt", "-np"}
+	if len(whisper.language) > 0 {
+		args = append(args, "-l", whisper.language)
+	}
+
+	stderr.Reset()
+
+	cmd := exec.CommandContext(ctx, whisper.binary, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err != nil {
+		return "", formatError(fmt.Errorf("%v, %s", err, strings.TrimSpace(stderr.String())))
+	}
+
+	// drop the non speech markers like [BLANK_AUDIO] and join the segments
+	s := regexp.MustCompile(`\[[A-Z_ ]+\]`).ReplaceAllString(stdout.String(), " ")
+
+	return strings.Join(strings.Fields(s), " "), nil
+}
+
+// TranscriptionQueue transcribes the ingested calls one at a time, off the
+// ingest path. The calls are sent downstream once transcribed so that the
+// transcripts go along.
+type TranscriptionQueue struct {
+	Controller  *Controller
+	Transcriber Transcriber
+	calls       chan *Call
+	started     bool
+}
+
+func NewTranscriptionQueue(controller *Controller) *TranscriptionQueue {
+	return &TranscriptionQueue{
+		Controller: controller,
+		calls:      make(chan *Call, 8192),
+	}
+}
+
+func (queue *TranscriptionQueue) Add(call *Call) {
+	select {
+	case queue.calls <- call:
+	default:
+		queue.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("transcription: queue is full, call=%v not transcribed", call.Id))
+		go queue.Controller.Downstreams.Send(queue.Controller, call)
+	}
+}
+
+func (queue *TranscriptionQueue) IsEnabled() bool {
+	return queue.Transcriber != nil
+}
+
+func (queue *TranscriptionQueue) Start() error {
+	var err error
+
+	if queue.started {
+		return errors.New("transcription queue already started")
+	} else {
+		queue.started = true
+	}
+
+	if queue.Transcriber, err = NewTranscriber(queue.Controller.Config); err != nil {
+		return err
+	}
+
+	if queue.Transcriber != nil {
+		go func() {
+			for call := range queue.calls {
+				queue.transcribe(call)
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (queue *TranscriptionQueue) transcribe(call *Call) {
+	controller := queue.Controller
+
+	// calls from upstream instances may already be transcribed
+	switch v := call.Transcript.(type) {
+	case string:
+		if len(v) > 0 {
+			go controller.Downstreams.Send(controller, call)
+			return
+		}
+	}
+
+	// the call is shared with the listeners being sent to, work on a copy
+	transcribed := *call
+
+	if transcript, err := queue.Transcriber.Transcribe(call); err != nil {
+		controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("transcription: call=%v %v", call.Id, err))
+
+	} else if len(transcript) > 0 {
+		transcribed.Transcript = transcript
+
+		if err = controller.Calls.WriteTranscript(&transcribed, controller.Database); err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+
+		go controller.Clients.EmitTranscript(&transcribed, controller.Accesses.IsRestricted())
+
+		controller.Alerts.EvaluateTranscript(controller, &transcribed)
+	}
+
+	go controller.Downstreams.Send(controller, &transcribed)
+}